@@ -0,0 +1,175 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Accounting keeps per-namespace counts of awaits, for chargeback or showback when gotcha is run as a shared service.
+// The running totals are served at /admin/accounting, and the counts since the last export are handed to Export every
+// Interval.
+type Accounting struct {
+	// Interval is how often Export is called. Defaults to 5 minutes.
+	Interval time.Duration
+	// Export, if set, is sent the usage of every namespace that had any since the last export. If it fails, the usage
+	// is carried over to the next export, so that none is lost.
+	Export func(ctx context.Context, usage []AccountingPeriod) error
+
+	mu     sync.Mutex
+	totals map[string]*AccountingCounts
+	period map[string]*AccountingCounts
+	start  time.Time
+}
+
+// AccountingCounts counts a namespace's awaits.
+type AccountingCounts struct {
+	// Registered counts awaits that were registered.
+	Registered uint64 `json:"registered"`
+	// Verified counts awaits that were verified.
+	Verified uint64 `json:"verified"`
+	// Expired counts awaits that expired.
+	Expired uint64 `json:"expired"`
+	// Blocked counts awaits that were resolved because a blocked client tried to verify them.
+	Blocked uint64 `json:"blocked"`
+}
+
+// AccountingPeriod is a namespace's usage between Start and End.
+type AccountingPeriod struct {
+	Namespace string    `json:"namespace"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	AccountingCounts
+}
+
+func accountingRegistered(counts *AccountingCounts) *uint64 { return &counts.Registered }
+func accountingVerified(counts *AccountingCounts) *uint64   { return &counts.Verified }
+func accountingExpired(counts *AccountingCounts) *uint64    { return &counts.Expired }
+func accountingBlocked(counts *AccountingCounts) *uint64    { return &counts.Blocked }
+
+// observe counts an await in namespace, using count to pick the counter.
+func (accounting *Accounting) observe(namespace string, count func(*AccountingCounts) *uint64) {
+	if accounting == nil {
+		return
+	}
+	accounting.mu.Lock()
+	defer accounting.mu.Unlock()
+	if accounting.totals == nil {
+		accounting.totals, accounting.period = map[string]*AccountingCounts{}, map[string]*AccountingCounts{}
+	}
+	for _, counts := range []map[string]*AccountingCounts{accounting.totals, accounting.period} {
+		if counts[namespace] == nil {
+			counts[namespace] = &AccountingCounts{}
+		}
+		*count(counts[namespace])++
+	}
+}
+
+// Totals returns each namespace's counts since the server started.
+func (accounting *Accounting) Totals() map[string]AccountingCounts {
+	accounting.mu.Lock()
+	defer accounting.mu.Unlock()
+	totals := make(map[string]AccountingCounts, len(accounting.totals))
+	for namespace, counts := range accounting.totals {
+		totals[namespace] = *counts
+	}
+	return totals
+}
+
+// flush takes the counts since the last flush, sorted by namespace, and starts a new period.
+func (accounting *Accounting) flush(now time.Time) []AccountingPeriod {
+	accounting.mu.Lock()
+	defer accounting.mu.Unlock()
+	usage := make([]AccountingPeriod, 0, len(accounting.period))
+	for namespace, counts := range accounting.period {
+		usage = append(usage, AccountingPeriod{Namespace: namespace, Start: accounting.start, End: now, AccountingCounts: *counts})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Namespace < usage[j].Namespace })
+	accounting.period, accounting.start = map[string]*AccountingCounts{}, now
+	return usage
+}
+
+// restore adds usage that couldn't be exported back into the current period, which then starts when it did.
+func (accounting *Accounting) restore(usage []AccountingPeriod) {
+	accounting.mu.Lock()
+	defer accounting.mu.Unlock()
+	for _, period := range usage {
+		counts := accounting.period[period.Namespace]
+		if counts == nil {
+			counts = &AccountingCounts{}
+			accounting.period[period.Namespace] = counts
+		}
+		counts.Registered += period.Registered
+		counts.Verified += period.Verified
+		counts.Expired += period.Expired
+		counts.Blocked += period.Blocked
+		accounting.start = period.Start
+	}
+}
+
+// restoreTotals replaces the running totals of the namespaces in totals, such as from a snapshot. They aren't exported
+// again.
+func (accounting *Accounting) restoreTotals(totals map[string]AccountingCounts) {
+	accounting.mu.Lock()
+	defer accounting.mu.Unlock()
+	if accounting.totals == nil {
+		accounting.totals, accounting.period = map[string]*AccountingCounts{}, map[string]*AccountingCounts{}
+	}
+	for namespace, restored := range totals {
+		counts := restored
+		accounting.totals[namespace] = &counts
+	}
+}
+
+// forget deletes the counts for namespace, unless it has usage that's still to be exported. See Server.NamespaceIdle.
+func (accounting *Accounting) forget(namespace string) {
+	if accounting == nil {
+		return
+	}
+	accounting.mu.Lock()
+	defer accounting.mu.Unlock()
+	if accounting.Export == nil || accounting.period[namespace] == nil {
+		delete(accounting.totals, namespace)
+		delete(accounting.period, namespace)
+	}
+}
+
+// run calls Export every Interval until ctx is done.
+func (accounting *Accounting) run(ctx context.Context, server *Server, logger *slog.Logger) {
+	interval := accounting.Interval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	accounting.mu.Lock()
+	accounting.start = time.Now()
+	accounting.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		usage := accounting.flush(time.Now())
+		if len(usage) == 0 {
+			continue
+		}
+		if err := accounting.Export(ctx, usage); err != nil {
+			accounting.restore(usage)
+			logger.Error("gotcha: exporting accounting failed", "namespaces", len(usage), "error", err)
+			server.handleError(nil, fmt.Errorf("gotcha: exporting accounting: %w", err))
+		}
+	}
+}
+
+// serveAccounting handles GET /admin/accounting.
+func (server *Server) serveAccounting(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"totals": server.Accounting.Totals()})
+}
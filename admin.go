@@ -0,0 +1,69 @@
+package gotcha
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuth rejects requests that don't carry "Authorization: Bearer <AdminToken>". With the dashboard enabled, basic
+// auth as "admin" with AdminToken as the password is accepted too, so that browsers can log in.
+func (server *Server) adminAuth(c *gin.Context) {
+	credentials := Credentials{Tokens: []string{server.AdminToken}}
+	if server.Dashboard {
+		credentials.Accounts = map[string]string{"admin": server.AdminToken}
+	}
+	if !credentials.authorised(c.Request) {
+		c.Header("WWW-Authenticate", credentials.challenge())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]string{"message": http.StatusText(http.StatusUnauthorized)})
+	}
+}
+
+// registerAdmin adds the admin endpoints under /admin in root.
+func (server *Server) registerAdmin(root *gin.RouterGroup) {
+	admin := root.Group("/admin", server.adminAuth)
+	admin.GET("/metrics", server.serveMetrics)
+	admin.GET("/vars", func(c *gin.Context) {
+		c.JSON(http.StatusOK, server.vars())
+	})
+	admin.GET("/live", server.live)
+	admin.POST("/blocklist", server.importBlockList)
+	if server.Analytics != nil {
+		admin.GET("/analytics", server.serveAnalytics)
+	}
+	if server.Accounting != nil {
+		admin.GET("/accounting", server.serveAccounting)
+	}
+	if server.Dashboard {
+		admin.GET("/dashboard", server.dashboard)
+		admin.GET("/dashboard/data", server.dashboardData)
+	}
+	if server.DebugDumps {
+		admin.POST("/dumps", server.startDumps)
+		admin.GET("/dumps/:identifier", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"dumps": server.RequestDumps(c.Param("identifier"))})
+		})
+		admin.DELETE("/dumps/:identifier", func(c *gin.Context) {
+			if !server.StopDumps(c.Param("identifier")) {
+				c.JSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+				return
+			}
+			c.Status(http.StatusNoContent)
+		})
+	}
+	if server.Pprof {
+		admin.Any("/debug/pprof/*profile", gin.WrapH(http.StripPrefix(admin.BasePath(), pprofHandler())))
+	}
+}
+
+// pprofHandler serves net/http/pprof under /debug/pprof/.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,119 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// afterVerifyQueueSize is how many jobs can be waiting to run before new ones are dead-lettered.
+const afterVerifyQueueSize = 1024
+
+// AfterVerify runs actions in the background once awaits are verified, such as marking the user verified in a
+// database or provisioning their resources, so that slow downstream work doesn't hold up the success page. Each
+// action is retried with exponential backoff until it succeeds or has failed MaxAttempts times. Jobs are held in
+// memory, so anything still queued on exit is lost.
+type AfterVerify struct {
+	// Actions are run for each verified await, independently of each other, keyed by name.
+	Actions map[string]func(ctx context.Context, job AfterVerifyJob) error
+	// MaxAttempts is how many times an action is tried before it's dead-lettered. Defaults to 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each after it. Defaults to 1 second.
+	Backoff time.Duration
+	// Timeout is the longest that an attempt can take. Defaults to 30 seconds.
+	Timeout time.Duration
+	// Workers is how many actions can run at once. Defaults to 4.
+	Workers int
+	// DeadLetter, if set, is called with jobs that failed MaxAttempts times, or that couldn't be queued, and the last
+	// error, e.g. to store them for replaying by hand.
+	DeadLetter func(job AfterVerifyJob, err error)
+
+	once sync.Once
+	jobs chan AfterVerifyJob
+}
+
+// AfterVerifyJob is an action to run for a verified await.
+type AfterVerifyJob struct {
+	// Action is the name of the action in AfterVerify.Actions.
+	Action string
+	// Await is the await as it was when it was verified, and Result how it was verified.
+	Await  PendingAwait
+	Result Result
+	// Attempts is how many times the action has already been tried.
+	Attempts int
+}
+
+// enqueue queues the actions for a verified await, starting the workers the first time.
+func (after *AfterVerify) enqueue(server *Server, await PendingAwait, result Result) {
+	after.once.Do(func() {
+		workers := after.Workers
+		if workers <= 0 {
+			workers = 4
+		}
+		after.jobs = make(chan AfterVerifyJob, afterVerifyQueueSize)
+		for i := 0; i < workers; i++ {
+			go after.work(server)
+		}
+	})
+	names := make([]string, 0, len(after.Actions))
+	for name := range after.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		after.queue(server, AfterVerifyJob{Action: name, Await: await, Result: result})
+	}
+}
+
+// queue adds job to the queue, or dead-letters it if the queue is full. It can be called with the server's lock held,
+// so DeadLetter is called on its own goroutine.
+func (after *AfterVerify) queue(server *Server, job AfterVerifyJob) {
+	select {
+	case after.jobs <- job:
+	default:
+		server.logger().Warn("gotcha: after-verify queue full", "action", job.Action, "identifier", job.Await.Identifier)
+		go after.deadLetter(job, fmt.Errorf("gotcha: after-verify queue full"))
+	}
+}
+
+// work runs jobs from the queue, retrying those that fail.
+func (after *AfterVerify) work(server *Server) {
+	for job := range after.jobs {
+		timeout := after.Timeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := after.Actions[job.Action](ctx, job)
+		cancel()
+		if err == nil {
+			continue
+		}
+		job.Attempts++
+		server.logger().Warn("gotcha: after-verify action failed", "action", job.Action,
+			"identifier", job.Await.Identifier, "attempts", job.Attempts, "error", err)
+		maxAttempts := after.MaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = 5
+		}
+		if job.Attempts >= maxAttempts {
+			server.handleError(nil, fmt.Errorf("gotcha: after-verify action %s: %w", job.Action, err))
+			after.deadLetter(job, err)
+			continue
+		}
+		backoff := after.Backoff
+		if backoff <= 0 {
+			backoff = time.Second
+		}
+		retry := job
+		time.AfterFunc(backoff<<(job.Attempts-1), func() { after.queue(server, retry) })
+	}
+}
+
+func (after *AfterVerify) deadLetter(job AfterVerifyJob, err error) {
+	if after.DeadLetter != nil {
+		after.DeadLetter(job, err)
+	}
+}
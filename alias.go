@@ -0,0 +1,50 @@
+package gotcha
+
+import "github.com/gin-gonic/gin"
+
+// Alias is another identifier that verifies an await, such as a short code sent by SMS alongside an email's link.
+// Result.Alias says which one was used.
+type Alias struct {
+	// Name describes the alias in Result.Alias, e.g. "sms".
+	Name string
+	// Identifier is what the client can request /verify/:identifier with instead of the await's own identifier.
+	Identifier string
+}
+
+type alias struct {
+	name string
+	// key is the alias's identifier, as returned by HashIdentifier.
+	key string
+}
+
+// aliasRef points at an alias of a registered await.
+type aliasRef struct {
+	key   string
+	entry *awaited
+	name  string
+}
+
+// aliasKey is the gin.Context key of the name of the alias that a request is verifying with.
+const aliasKey = "gotcha.alias"
+
+// otherKeys returns the keys of entry's steps and aliases, which mustn't belong to other pending awaits.
+func (entry *awaited) otherKeys() []string {
+	if len(entry.steps) == 0 && len(entry.aliases) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(entry.steps)+len(entry.aliases))
+	for _, step := range entry.steps {
+		keys = append(keys, step.key)
+	}
+	for _, alias := range entry.aliases {
+		keys = append(keys, alias.key)
+	}
+	return keys
+}
+
+// markAlias records on c which of its aliases key is, if it's one, for identify. The server's lock must be held.
+func (server *Server) markAlias(c *gin.Context, key string) {
+	if ref, ok := server.aliases[key]; ok {
+		c.Set(aliasKey, ref.name)
+	}
+}
@@ -0,0 +1,167 @@
+package gotcha
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// analyticsDate is the layout of Rollup.Date.
+const analyticsDate = "2006-01-02"
+
+// Analytics aggregates verification funnels per namespace into daily rollups, served at /admin/analytics, so that
+// conversion can be seen without exporting raw events. Each stage is counted on the day that it happened, in UTC.
+type Analytics struct {
+	// Days is how many days of rollups are kept. Defaults to 30.
+	Days int
+
+	mu      sync.Mutex
+	rollups map[rollupKey]*Funnel
+}
+
+// Funnel counts awaits at each stage of verification.
+type Funnel struct {
+	// Sent counts awaits that were registered.
+	Sent uint64 `json:"sent"`
+	// Clicked counts awaits whose link was requested at least once.
+	Clicked uint64 `json:"clicked"`
+	// Confirmed counts awaits that were verified.
+	Confirmed uint64 `json:"confirmed"`
+	// Expired counts awaits that expired.
+	Expired uint64 `json:"expired"`
+}
+
+// Rollup is one day's Funnel for a namespace.
+type Rollup struct {
+	// Date is the day, as YYYY-MM-DD.
+	Date      string `json:"date"`
+	Namespace string `json:"namespace"`
+	Funnel
+}
+
+type rollupKey struct {
+	date      string
+	namespace string
+}
+
+// observe counts a stage for namespace at t, using count to pick the stage's counter.
+func (analytics *Analytics) observe(namespace string, t time.Time, count func(*Funnel) *uint64) {
+	if analytics == nil {
+		return
+	}
+	key := rollupKey{date: t.UTC().Format(analyticsDate), namespace: namespace}
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	if analytics.rollups == nil {
+		analytics.rollups = map[rollupKey]*Funnel{}
+	}
+	funnel, ok := analytics.rollups[key]
+	if !ok {
+		funnel = &Funnel{}
+		analytics.rollups[key] = funnel
+		analytics.prune(t)
+	}
+	*count(funnel)++
+}
+
+// prune deletes rollups older than Days before now. The analytics' lock must be held.
+func (analytics *Analytics) prune(now time.Time) {
+	days := analytics.Days
+	if days <= 0 {
+		days = 30
+	}
+	oldest := now.UTC().AddDate(0, 0, -days).Format(analyticsDate)
+	for key := range analytics.rollups {
+		if key.date < oldest {
+			delete(analytics.rollups, key)
+		}
+	}
+}
+
+// Rollups returns the daily rollups, oldest first, for namespace, or for every namespace if it's empty.
+func (analytics *Analytics) Rollups(namespace string) []Rollup {
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	rollups := []Rollup{}
+	for key, funnel := range analytics.rollups {
+		if namespace == "" || key.namespace == namespace {
+			rollups = append(rollups, Rollup{Date: key.date, Namespace: key.namespace, Funnel: *funnel})
+		}
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if rollups[i].Date != rollups[j].Date {
+			return rollups[i].Date < rollups[j].Date
+		}
+		return rollups[i].Namespace < rollups[j].Namespace
+	})
+	return rollups
+}
+
+// restore replaces the analytics' rollups for the days and namespaces in rollups, such as from a snapshot, dropping any
+// older than Days.
+func (analytics *Analytics) restore(rollups []Rollup) {
+	analytics.mu.Lock()
+	defer analytics.mu.Unlock()
+	if analytics.rollups == nil {
+		analytics.rollups = map[rollupKey]*Funnel{}
+	}
+	for _, rollup := range rollups {
+		key := rollupKey{date: rollup.Date, namespace: rollup.Namespace}
+		funnel := rollup.Funnel
+		analytics.rollups[key] = &funnel
+	}
+	analytics.prune(time.Now())
+}
+
+func funnelSent(funnel *Funnel) *uint64      { return &funnel.Sent }
+func funnelClicked(funnel *Funnel) *uint64   { return &funnel.Clicked }
+func funnelConfirmed(funnel *Funnel) *uint64 { return &funnel.Confirmed }
+func funnelExpired(funnel *Funnel) *uint64   { return &funnel.Expired }
+
+// markRequested records that a client has asked to verify entry. The server's lock must be held, exclusively or along
+// with the lock of entry's shard.
+func (server *Server) markRequested(entry *awaited) {
+	if !entry.requested && entry.pending() {
+		server.Analytics.observe(entry.namespace, time.Now(), funnelClicked)
+	}
+	entry.requested = true
+}
+
+// serveAnalytics handles GET /admin/analytics. ?namespace= narrows the rollups down to one namespace and ?days= to
+// the most recent days.
+func (server *Server) serveAnalytics(c *gin.Context) {
+	rollups := server.Analytics.Rollups(c.Query("namespace"))
+	if raw := c.Query("days"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid days"})
+			return
+		}
+		oldest := time.Now().UTC().AddDate(0, 0, 1-days).Format(analyticsDate)
+		recent := []Rollup{}
+		for _, rollup := range rollups {
+			if rollup.Date >= oldest {
+				recent = append(recent, rollup)
+			}
+		}
+		rollups = recent
+	}
+
+	totals := map[string]*Funnel{}
+	for _, rollup := range rollups {
+		total, ok := totals[rollup.Namespace]
+		if !ok {
+			total = &Funnel{}
+			totals[rollup.Namespace] = total
+		}
+		total.Sent += rollup.Sent
+		total.Clicked += rollup.Clicked
+		total.Confirmed += rollup.Confirmed
+		total.Expired += rollup.Expired
+	}
+	c.JSON(http.StatusOK, gin.H{"rollups": rollups, "totals": totals})
+}
@@ -0,0 +1,120 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Appeals enables /appeal, where blocked clients can ask to be unblocked, so that people caught by automatic blocks
+// have a way back in. The block page links to it.
+type Appeals struct {
+	// Submit is called with each appeal, such as to open a support ticket. If it fails, the client is asked to try
+	// again later.
+	Submit func(ctx context.Context, appeal Appeal) error
+	// MaxLength is the longest message that's accepted, in characters. Defaults to 1000.
+	MaxLength int
+}
+
+// Appeal is a blocked client's request to be unblocked.
+type Appeal struct {
+	ClientIP string
+	// Reason is why the client is blocked, as they were shown it.
+	Reason    string
+	Message   string
+	Time      time.Time
+	UserAgent string
+	RequestID string
+}
+
+func (appeals *Appeals) maxLength() int {
+	if appeals.MaxLength <= 0 {
+		return 1000
+	}
+	return appeals.MaxLength
+}
+
+// clientBlock returns why ip is blocked, if it is, by anything that isn't specific to an await.
+func (server *Server) clientBlock(ip string) (blocked, bool) {
+	if block, ok := server.lockedBlockReason(ip); ok {
+		return block, true
+	}
+	for _, list := range server.RemoteBlockLists {
+		if reason, ok := list.lookup(ip); ok {
+			return blocked{reason: reason}, true
+		}
+	}
+	return blocked{}, false
+}
+
+// appealForm handles GET /appeal.
+func (server *Server) appealForm(c *gin.Context) {
+	block, ok := server.clientBlock(server.clientIP(c))
+	if !ok {
+		server.renderError(c, http.StatusBadRequest, "not_blocked")
+		return
+	}
+	theme := server.theme(c)
+	theme.execute(c, http.StatusOK, "appeal.html", page{
+		Theme:     theme,
+		Heading:   "Ask to be unblocked",
+		Text:      server.expandReason(block, server.clientIP(c)),
+		Action:    server.basePath() + "/appeal",
+		MaxLength: server.Appeals.maxLength(),
+		CSRF:      server.csrfToken(c),
+	})
+}
+
+// appeal handles POST /appeal. The message is sent as the "message" form field or in a JSON body.
+func (server *Server) appeal(c *gin.Context) {
+	var message string
+	if c.ContentType() == gin.MIMEJSON {
+		var req struct {
+			Message string `json:"message"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			server.renderError(c, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		message = req.Message
+	} else {
+		message = c.PostForm("message")
+	}
+	message = strings.TrimSpace(message)
+	if message == "" || utf8.RuneCountInString(message) > server.Appeals.maxLength() {
+		server.renderError(c, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	block, ok := server.clientBlock(server.clientIP(c))
+	if !ok {
+		server.renderError(c, http.StatusBadRequest, "not_blocked")
+		return
+	}
+
+	appeal := Appeal{
+		ClientIP:  server.clientIP(c),
+		Reason:    server.expandReason(block, server.clientIP(c)),
+		Message:   message,
+		Time:      time.Now(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: requestIDOf(c),
+	}
+	server.logger().Info("gotcha: appeal", "client_ip", appeal.ClientIP, "reason", appeal.Reason,
+		"message", appeal.Message, "request_id", appeal.RequestID)
+	if submit := server.Appeals.Submit; submit != nil {
+		if err := submit(c.Request.Context(), appeal); err != nil {
+			server.logger().Error("gotcha: submitting appeal failed", "client_ip", appeal.ClientIP, "error", err)
+			if server.handleError(c, fmt.Errorf("gotcha: submitting appeal: %w", err)) {
+				return
+			}
+			server.renderError(c, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+	}
+	server.renderError(c, http.StatusAccepted, "appealed")
+}
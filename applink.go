@@ -0,0 +1,41 @@
+package gotcha
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appLink returns link if it's safe to send a browser to, or "" if it isn't, such as a javascript: URL.
+func appLink(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Scheme == "" {
+		return ""
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "javascript", "data", "vbscript", "file":
+		return ""
+	}
+	return link
+}
+
+// openApp sends a browser that verified an await to its AppLink: straight there if it's a universal link, or by way
+// of a page that tries the app's scheme and falls back to WebFallback if the app doesn't open.
+func (server *Server) openApp(c *gin.Context, link, fallback string) {
+	if scheme, _, _ := strings.Cut(link, ":"); strings.EqualFold(scheme, "https") || strings.EqualFold(scheme, "http") {
+		// The OS opens the app for universal links, and the site otherwise.
+		c.Redirect(http.StatusSeeOther, link)
+		return
+	}
+	theme := server.theme(c)
+	theme.execute(c, http.StatusOK, "applink.html", page{
+		Theme:    theme,
+		Heading:  "You're verified",
+		Text:     "Opening the app…",
+		AppLink:  template.URL(link),
+		Fallback: appLink(fallback),
+	})
+}
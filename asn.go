@@ -0,0 +1,74 @@
+package gotcha
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ASN is an autonomous system: a network, such as an ISP or hosting provider, that announces its own address ranges.
+type ASN struct {
+	Number       uint32
+	Organization string
+}
+
+// ASNFilter blocks or flags verifying clients by the autonomous system their address belongs to, so that whole hosting
+// providers can be turned away rather than one address at a time. Lookups that fail or time out are treated as
+// unknown, and nothing is blocked.
+type ASNFilter struct {
+	// Lookup returns the autonomous system that ip belongs to, or an ASN with Number 0 if it isn't known. It's required;
+	// use an ASNDatabase's Lookup for a MaxMind DB file such as GeoLite2-ASN, or a func that asks another service,
+	// caching its answers if it's slow.
+	Lookup func(ctx context.Context, ip netip.Addr) (ASN, error)
+	// Block maps the numbers of autonomous systems whose clients are blocked, as if they were on BlockList, to the reason
+	// they're shown. An empty reason shows a generic message.
+	Block map[uint32]string
+	// Flag are the numbers of autonomous systems whose clients are only flagged, with an "asn:<number>" entry in
+	// Result.Flags and PendingAwait.Flags, so that a Verifier can decide.
+	Flag []uint32
+	// Timeout is the longest that a lookup can hold a request up for. Defaults to 1 second.
+	Timeout time.Duration
+}
+
+// checkASN looks the client's autonomous system up in the ASNFilter, flagging the request if it's one to flag. It
+// returns a reason if the client should be blocked.
+func (server *Server) checkASN(c *gin.Context) (string, bool) {
+	filter := server.ASN
+	if filter == nil || filter.Lookup == nil {
+		return "", false
+	}
+	ip, err := netip.ParseAddr(server.clientIP(c))
+	if err != nil {
+		return "", false
+	}
+	timeout := filter.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	asn, err := filter.Lookup(ctx, ip)
+	if err != nil {
+		server.logger().Warn("gotcha: looking up ASN failed", "error", err)
+		return "", false
+	}
+	if asn.Number == 0 {
+		return "", false
+	}
+	if reason, ok := filter.Block[asn.Number]; ok {
+		if reason == "" {
+			reason = blocklistReason
+		}
+		return reason, true
+	}
+	for _, number := range filter.Flag {
+		if number == asn.Number {
+			addFlag(c, "asn:"+strconv.FormatUint(uint64(number), 10))
+			break
+		}
+	}
+	return "", false
+}
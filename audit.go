@@ -0,0 +1,137 @@
+package gotcha
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ErrAuditLogTampered is returned by VerifyAuditLog for logs whose entries have been changed, removed or reordered.
+var ErrAuditLogTampered = errors.New("gotcha: audit log has been tampered with")
+
+// AuditEntry is an entry in an AuditLog.
+type AuditEntry struct {
+	// Seq numbers the entries from 1.
+	Seq uint64 `json:"seq"`
+	// Prev is the Hash of the entry before, or empty for the first.
+	Prev string `json:"prev"`
+	// Event is the event, as JSON.
+	Event json.RawMessage `json:"event"`
+	// Hash is the hex SHA-256 of Seq, Prev and Event, or their HMAC with the log's key if it has one.
+	Hash string `json:"hash"`
+}
+
+// AuditLog is an EventSink that writes events as a hash chain of AuditEntries, one JSON object per line, so that
+// auditors can prove verification records weren't altered afterwards: each entry's Hash covers the one before, so
+// changing, removing or reordering any breaks every hash after it. See VerifyAuditLog, and cmd/gotchaaudit.
+//
+// Without a key, someone who can rewrite the whole log can rebuild the chain, so anchor it by keeping Head elsewhere
+// from time to time, or set a key that they don't have. Truncating the log is only caught against an anchor. Events
+// dropped because the event queue was full never reach the log.
+type AuditLog struct {
+	w    io.Writer
+	key  []byte
+	mu   sync.Mutex
+	seq  uint64
+	prev string
+}
+
+// NewAuditLog returns an AuditLog that writes a new chain to w. key, if set, signs the entries with HMAC-SHA256.
+func NewAuditLog(w io.Writer, key []byte) *AuditLog {
+	return &AuditLog{w: w, key: key}
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if it doesn't exist. An existing log is verified
+// first, and the chain carries on from its last entry.
+func OpenAuditLog(path string, key []byte) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	audit := &AuditLog{w: file, key: key}
+	if audit.seq, audit.prev, err = verifyAuditLog(file, key); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return audit, nil
+}
+
+// Send appends event to the log.
+func (audit *AuditLog) Send(ctx context.Context, event Event) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	entry := AuditEntry{Seq: audit.seq + 1, Prev: audit.prev, Event: raw}
+	entry.Hash = auditHash(audit.key, entry)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := audit.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	audit.seq, audit.prev = entry.Seq, entry.Hash
+	return nil
+}
+
+// Head returns the Seq and Hash of the last entry written, for anchoring the chain.
+func (audit *AuditLog) Head() (uint64, string) {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	return audit.seq, audit.prev
+}
+
+// VerifyAuditLog checks the chain of entries in r, as written by an AuditLog with key, returning how many there are
+// and the Hash of the last, which can be compared against an anchor that Head returned. Errors for broken chains wrap
+// ErrAuditLogTampered and give the line.
+func VerifyAuditLog(r io.Reader, key []byte) (int, string, error) {
+	seq, head, err := verifyAuditLog(r, key)
+	return int(seq), head, err
+}
+
+func verifyAuditLog(r io.Reader, key []byte) (uint64, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var seq uint64
+	var prev string
+	for line := 1; scanner.Scan(); line++ {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return seq, prev, fmt.Errorf("%w: line %d: %v", ErrAuditLogTampered, line, err)
+		}
+		if entry.Seq != seq+1 || entry.Prev != prev {
+			return seq, prev, fmt.Errorf("%w: line %d is out of sequence", ErrAuditLogTampered, line)
+		}
+		if !hmac.Equal([]byte(entry.Hash), []byte(auditHash(key, entry))) {
+			return seq, prev, fmt.Errorf("%w: line %d doesn't match its hash", ErrAuditLogTampered, line)
+		}
+		seq, prev = entry.Seq, entry.Hash
+	}
+	return seq, prev, scanner.Err()
+}
+
+// auditHash returns the Hash of entry.
+func auditHash(key []byte, entry AuditEntry) string {
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(strconv.FormatUint(entry.Seq, 10) + "\n" + entry.Prev + "\n"))
+	h.Write(entry.Event)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,60 @@
+package gotcha
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Credentials are what an authenticated endpoint accepts. A request is authorised if it presents any of them.
+type Credentials struct {
+	// Tokens are accepted as "Authorization: Bearer <token>".
+	Tokens []string
+	// Accounts maps usernames to passwords that are accepted with HTTP basic auth.
+	Accounts map[string]string
+}
+
+// authorised reports whether the request presents one of the credentials. Every credential is compared so that
+// timing doesn't reveal which one nearly matched.
+func (credentials *Credentials) authorised(r *http.Request) bool {
+	ok := false
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		presented := []byte(strings.TrimPrefix(header, "Bearer "))
+		for _, token := range credentials.Tokens {
+			if token != "" && subtle.ConstantTimeCompare(presented, []byte(token)) == 1 {
+				ok = true
+			}
+		}
+	}
+	if username, password, hasBasic := r.BasicAuth(); hasBasic {
+		for name, secret := range credentials.Accounts {
+			nameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(name))
+			secretMatches := subtle.ConstantTimeCompare([]byte(password), []byte(secret))
+			if nameMatches&secretMatches == 1 {
+				ok = true
+			}
+		}
+	}
+	return ok
+}
+
+// challenge returns the WWW-Authenticate header for an unauthorised request.
+func (credentials *Credentials) challenge() string {
+	if len(credentials.Accounts) > 0 {
+		return `Basic realm="gotcha", charset="UTF-8"`
+	}
+	return "Bearer"
+}
+
+// verifyAuth rejects verify requests that don't present VerifyAuth, without touching the await.
+func (server *Server) verifyAuth(c *gin.Context) {
+	if server.VerifyAuth == nil || server.VerifyAuth.authorised(c.Request) {
+		return
+	}
+	c.Header("WWW-Authenticate", server.VerifyAuth.challenge())
+	server.renderError(c, http.StatusUnauthorized, "unauthorized")
+	c.Abort()
+}
@@ -0,0 +1,792 @@
+package gotcha
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrPending is returned by Register when the identifier already has a pending await.
+var ErrPending = errors.New("gotcha: identifier is already pending")
+
+// ErrAtCapacity is returned by Register when MaxPending awaits are already pending.
+var ErrAtCapacity = errors.New("gotcha: too many awaits are pending")
+
+// ErrUnavailable is returned by Register when Health reports that the server can't serve awaits. It wraps the error
+// that Health returned.
+var ErrUnavailable = errors.New("gotcha: unavailable")
+
+// AwaitRequest describes an await to register.
+type AwaitRequest struct {
+	// Identifier is what the client must request /verify/:identifier with.
+	Identifier string
+	// Namespace groups related awaits, such as those belonging to one tenant or flow. It's carried through to events.
+	Namespace string
+	// Metadata is application data about the await, such as the account being confirmed or the IP address that asked
+	// for it. It's passed to hooks like Server.Verifier.
+	Metadata map[string]string
+	// Code, if set, must be presented along with the identifier: in the body of POST /verify, or as ?code= on other
+	// routes. A wrong code is rejected without consuming the await. It's meant for short codes that users type in.
+	Code string
+	// Methods, if set, restricts which of Server.Methods can verify this await. Requests using other methods are
+	// rejected without consuming it.
+	Methods []string
+	// AllowList, if set, holds the only IP addresses that can verify this await. Requests from others are rejected
+	// without consuming it.
+	AllowList []string
+	// BlockList is a map of IP addresses to reasons, as with Server.BlockList, that applies to this await on top of it.
+	BlockList map[string]string
+	// MaxAttempts, if set, is how many failed attempts to verify the await, such as with a wrong code or from a client
+	// that isn't allowed, it can take before it's resolved with StatusLocked. It protects short codes from guessing.
+	MaxAttempts int
+	// Steps, if set, must all be completed, in any order, before the await is fulfilled. Identifier can then only be
+	// used to wait on the await, not to verify it, and Code is ignored.
+	Steps []Step
+	// ActivateAt, if in the future, schedules the await, such as for invitations queued ahead of a launch: it can't be
+	// verified before then, and Timeout counts from then rather than from when it was registered.
+	ActivateAt time.Time
+	// Priority decides which awaits give way under load. Defaults to PriorityNormal.
+	Priority Priority
+	// Windows, if set, are the only times that the await can be verified, such as for approvals that must happen during
+	// business hours. Requests outside of them are rejected without consuming it. They don't extend Timeout.
+	Windows []Window
+	// AppLink, if set, is where browsers are sent once the await is verified, such as a universal link or an app's own
+	// scheme, e.g. "myapp://verified", so that verifications started in a mobile app end up back in it. For an app's
+	// scheme, they're shown a page that tries it and then goes to WebFallback, if it's set, when the app doesn't open.
+	AppLink     string
+	WebFallback string
+	// Aliases, if set, are other identifiers that verify the await, such as for delivering it over several channels.
+	// They're ignored if it has Steps.
+	Aliases []Alias
+	// Renderer, if set, replaces Server.Renderer for the await's responses, such as for a campaign's branded pages.
+	// It isn't carried over by Export.
+	Renderer Renderer
+	// Template, if set, is the template in TemplateDir, or the Tenant's, that browsers are shown instead of result.html
+	// for the await.
+	Template string
+	// Headers, if set, are set on the responses to requests to verify the await, replacing any that are already set,
+	// such as Clear-Site-Data once a sensitive change is confirmed.
+	Headers http.Header
+	// IdempotencyKey, if set, makes registering the await again with the same key in its namespace, such as when a
+	// call is retried, return the Handle of the first registration for as long as it's kept, rather than registering
+	// another. See Handle.Duplicate.
+	IdempotencyKey string
+}
+
+// Result is the outcome of an await.
+type Result struct {
+	// Status is one of the statuses returned by Await.
+	Status int
+	// Resolved is when the await was resolved.
+	Resolved time.Time
+	// ClientIP is the IP address of the client that resolved the await, if there was one.
+	ClientIP string
+	// Headers holds the client's values for Server.CaptureHeaders, keyed by canonical header name.
+	Headers map[string]string
+	// RequestID is the ID of the request that resolved the await. See Server.RequestIDHeader.
+	RequestID string
+	// TraceParent is the W3C traceparent header of the request that resolved the await, if it had one.
+	TraceParent string
+	// Flags are what was noticed about the client that resolved the await, such as "dnsbl:<zone>" if it's listed by a
+	// DNSBL that doesn't block.
+	Flags []string
+	// Fingerprint is the fingerprint of the device that resolved the await. See Server.Fingerprint.
+	Fingerprint string
+	// Attribution holds the "referer" and utm_* query parameters, such as "utm_source", of the request that resolved
+	// the await. See Server.CaptureAttribution.
+	Attribution map[string]string
+	// Alias is the Name of the alias that resolved the await, or "" if it wasn't one of AwaitRequest.Aliases.
+	Alias string
+	// Failures is how many attempts to verify the await failed before it resolved, with a wrong code or from a client
+	// that was blocked or rejected, so that applications can flag accounts whose links were being probed.
+	Failures int
+	// FailureCodes breaks the failed attempts down by the code they were answered with, such as "invalid_code",
+	// "not_allowed", "blocked" or "rejected", so that an await that expired because someone tried but was turned
+	// away can be told from one that nobody opened. It includes blocked attempts that ShadowBanPending left pending,
+	// which aren't counted in Failures.
+	FailureCodes map[string]int
+}
+
+type awaited struct {
+	start time.Time
+	// activates, if set, is when the await can first be verified. See AwaitRequest.ActivateAt.
+	activates time.Time
+	// expires is when the await expires, Timeout after it activates. It's set when it's added, and pushed back by
+	// Maintenance that pauses expiry, during which expiryParked is set if it would have expired.
+	expires      time.Time
+	expiryParked bool
+	// done is closed once the await has been resolved. status and resolved must not be read before then.
+	done     chan struct{}
+	status   int
+	resolved time.Time
+	// requested is set once a client has asked to verify the await.
+	requested bool
+	// opened is closed once the await's confirmation page has been shown. See Handle.Opened.
+	opened chan struct{}
+	// methods, if not empty, are the only HTTP methods that can verify the await.
+	methods []string
+	// code, if set, must be presented along with the identifier.
+	code string
+	// failures counts failed attempts to verify the await, which is locked once it reaches maxAttempts, if that's set.
+	failures int
+	// failureCodes counts failed attempts by the code they were answered with. See Result.FailureCodes.
+	failureCodes map[string]int
+	maxAttempts  int
+	// clientIP is the IP address of the client that resolved the await.
+	clientIP    string
+	headers     map[string]string
+	requestID   string
+	traceParent string
+	flags       []string
+	fingerprint string
+	attribution map[string]string
+	namespace   string
+	metadata    map[string]string
+	// steps, if not empty, must all be done before the await is fulfilled.
+	steps []*step
+	// aliases also verify the await, and alias is the name of the one that resolved it.
+	aliases []alias
+	alias   string
+	// challenge is the WebAuthn challenge last shown on the confirmation page.
+	challenge string
+	// nonce is the nonce last shown on the confirmation page, which its form must post.
+	nonce string
+	// resent is when Resend.Issue was last called for the await.
+	resent time.Time
+	// allowList and blockList override the server's lists for this await.
+	allowList []string
+	blockList map[string]string
+	// windows, if not empty, are the only times that the await can be verified.
+	windows  []Window
+	priority Priority
+	// appLink and webFallback are where browsers are sent once the await is verified.
+	appLink     string
+	webFallback string
+	// renderer and template, if set, render the await's responses. See AwaitRequest.Renderer.
+	renderer Renderer
+	template string
+	// responseHeaders are set on the responses to requests to verify the await.
+	responseHeaders http.Header
+	// idempotencyKey is the key that the await is kept under in the server's idempotencyKeys, if it has one.
+	idempotencyKey string
+}
+
+// allows reports whether a request with method can verify the await.
+func (entry *awaited) allows(method string) bool {
+	if len(entry.methods) == 0 {
+		return true
+	}
+	for _, allowed := range entry.methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIP reports whether a client with ip can verify the await.
+func (entry *awaited) allowsIP(ip string) bool {
+	if len(entry.allowList) == 0 {
+		return true
+	}
+	for _, allowed := range entry.allowList {
+		if allowed == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// validFrom returns when the await could first be verified, which Timeout counts from.
+func (entry *awaited) validFrom() time.Time {
+	if entry.activates.IsZero() {
+		return entry.start
+	}
+	return entry.activates
+}
+
+// scheduled reports whether the await isn't active yet.
+func (entry *awaited) scheduled() bool {
+	return time.Now().Before(entry.activates)
+}
+
+// pending reports whether the await is yet to be resolved. The server's lock must be held.
+func (entry *awaited) pending() bool {
+	return entry.resolved.IsZero()
+}
+
+// PendingAwait describes an await that a client is trying to verify.
+type PendingAwait struct {
+	Identifier string
+	Namespace  string
+	// Registered is when the await was registered, Activates when it could first be verified, and Expires when it will
+	// expire.
+	Registered time.Time
+	Activates  time.Time
+	Expires    time.Time
+	// Metadata is a copy of AwaitRequest.Metadata.
+	Metadata map[string]string
+	// Step is the name of the step being verified, if the await has steps.
+	Step string
+	// Flags are what's been noticed about the client, as with Result.Flags.
+	Flags []string
+	// Fingerprint is the fingerprint of the client's device. See Server.Fingerprint.
+	Fingerprint string
+	// Priority is AwaitRequest.Priority.
+	Priority Priority
+
+	// renderer and template are AwaitRequest.Renderer and Template.
+	renderer Renderer
+	template string
+}
+
+// pendingAwait describes entry for hooks. The server's lock must be held.
+func (entry *awaited) pendingAwait(identifier string, current *step) PendingAwait {
+	metadata := make(map[string]string, len(entry.metadata))
+	for key, value := range entry.metadata {
+		metadata[key] = value
+	}
+	pending := PendingAwait{
+		Identifier: identifier,
+		Namespace:  entry.namespace,
+		Registered: entry.start,
+		Activates:  entry.validFrom(),
+		Expires:    entry.expires,
+		Metadata:   metadata,
+		Priority:   entry.priority,
+		renderer:   entry.renderer,
+		template:   entry.template,
+	}
+	if current != nil {
+		pending.Step = current.name
+	}
+	return pending
+}
+
+// Handle is a registered await.
+type Handle struct {
+	// Identifier is the identifier of the await.
+	Identifier string
+	// Duplicate is set if the await was registered earlier with the same AwaitRequest.IdempotencyKey, in which case
+	// its link has probably been sent already.
+	Duplicate bool
+
+	server *Server
+	entry  *awaited
+}
+
+// Wait blocks until the await resolves and returns its Result. It can be called any number of times.
+func (handle *Handle) Wait() Result {
+	<-handle.entry.done
+	return handle.entry.result()
+}
+
+// WaitHeartbeat is Wait, calling heartbeat every interval with how long is left before the await expires, so that
+// command-line tools can show that they're still waiting. interval defaults to 10 seconds. heartbeat is called from
+// the goroutine that's waiting, and not once the await has resolved.
+func (handle *Handle) WaitHeartbeat(interval time.Duration, heartbeat func(remaining time.Duration)) Result {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-handle.entry.done:
+			return handle.entry.result()
+		case <-ticker.C:
+			remaining := time.Until(handle.entry.expires)
+			if remaining < 0 {
+				remaining = 0
+			}
+			heartbeat(remaining)
+		}
+	}
+}
+
+// result returns the Result of entry, which must have been resolved.
+func (entry *awaited) result() Result {
+	return Result{
+		Status:       entry.status,
+		Resolved:     entry.resolved,
+		ClientIP:     entry.clientIP,
+		Headers:      entry.headers,
+		RequestID:    entry.requestID,
+		TraceParent:  entry.traceParent,
+		Flags:        entry.flags,
+		Fingerprint:  entry.fingerprint,
+		Attribution:  entry.attribution,
+		Alias:        entry.alias,
+		Failures:     entry.failures,
+		FailureCodes: entry.failureCodes,
+	}
+}
+
+// Opened returns a channel that's closed once the await's confirmation page has been shown, before the request is
+// confirmed, so that callers can tell the user that their link has been opened. It's only closed if Server.Confirm is
+// set, or Server.Prefetch sends the request there.
+func (handle *Handle) Opened() <-chan struct{} {
+	return handle.entry.opened
+}
+
+// Done returns a channel that's closed once the await resolves.
+func (handle *Handle) Done() <-chan struct{} {
+	return handle.entry.done
+}
+
+// Await waits for a GET request to /verify/:identifier.
+// It'll return StatusVerified (0) if the request was fulfilled, StatusExpired (1) if Timeout elapsed, StatusBlocked (2)
+// if it was blocked, StatusCancelled (3) if it was cancelled, StatusDenied (4) if it was denied, StatusUndeliverable
+// (5) if its link couldn't be delivered, StatusLocked (6) if there were too many failed attempts to verify it, or
+// StatusUnavailable (7) straight away if it couldn't be registered. An earlier await for the same identifier is
+// cancelled.
+// This function blocks. To be told how long is left while it does, use Register and Handle.WaitHeartbeat.
+func (server *Server) Await(identifier string) int {
+	handle, err := server.register(AwaitRequest{Identifier: identifier}, true)
+	if err != nil {
+		return StatusUnavailable
+	}
+	return handle.Wait().Status
+}
+
+// Register registers an await without blocking, returning ErrPending if the identifier is already pending,
+// ErrAtCapacity or ErrUnavailable if the server can't take on more work, ErrDraining if it's draining, ErrOverQuota
+// if its namespace has used up its Quota, and a *RegistrationLimitError if its namespace is registering too quickly.
+// It resolves with StatusExpired once Timeout elapses, whether or not anything is waiting on the Handle.
+func (server *Server) Register(req AwaitRequest) (*Handle, error) {
+	return server.register(req, false)
+}
+
+// AwaitBatch registers many awaits at once, such as for bulk invitations. Either all of them are registered or, if
+// any of them can't be, for the same reasons as Register, none of them are. The Handles are in the order of reqs.
+func (server *Server) AwaitBatch(reqs []AwaitRequest) ([]*Handle, error) {
+	return server.registerBatch(reqs, false)
+}
+
+func (server *Server) register(req AwaitRequest, replace bool) (*Handle, error) {
+	handles, err := server.registerBatch([]AwaitRequest{req}, replace)
+	if err != nil {
+		return nil, err
+	}
+	return handles[0], nil
+}
+
+// registerBatch registers reqs under a single lock. If replace is set, earlier awaits for the same identifiers are
+// cancelled rather than failing with ErrPending.
+func (server *Server) registerBatch(reqs []AwaitRequest, replace bool) ([]*Handle, error) {
+	keys := make([]string, len(reqs))
+	entries := make([]*awaited, len(reqs))
+	for i, req := range reqs {
+		keys[i] = server.HashIdentifier(req.Identifier)
+		entries[i] = server.newEntry(req)
+	}
+	if server.Health != nil {
+		if err := server.Health(); err != nil {
+			server.metrics.observeRejectedRegistration()
+			return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+	}
+	// RegistrationLimiter is only charged for the awaits that would be registered, so that idempotent retries and
+	// batches that are turned away anyway don't use up the namespace's allowance. It's asked without the lock held,
+	// since it can be a remote store, so the batch is checked again afterwards.
+	if server.RegistrationLimiter != nil {
+		server.mu.Lock()
+		handles, _, err := server.admit(reqs, keys, entries, replace)
+		server.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if err := server.limitRegistrations(reqs, handles); err != nil {
+			server.metrics.observeRejectedRegistration()
+			return nil, err
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	handles, victims, err := server.admit(reqs, keys, entries, replace)
+	if err != nil {
+		return nil, err
+	}
+	for _, victim := range victims {
+		server.resolve(victim.key, victim.entry, StatusEvicted)
+	}
+	for i, req := range reqs {
+		if handles[i] != nil {
+			continue
+		}
+		key, entry := keys[i], entries[i]
+		if existing, ok := server.awaits.get(key); ok && existing.pending() {
+			server.resolve(key, existing, StatusCancelled)
+		}
+		server.add(key, entry)
+		server.rememberIdempotencyKey(req, key, entry)
+		server.countRegistration(entry.namespace, entry.start)
+		server.metrics.observeRegistered()
+		server.Analytics.observe(entry.namespace, entry.start, funnelSent)
+		server.Accounting.observe(entry.namespace, accountingRegistered)
+		server.emit(Event{Type: EventRegistered, Identifier: key, Namespace: req.Namespace, Time: entry.start})
+		server.notify(req.Identifier, entry.pendingAwait(key, nil))
+		handles[i] = &Handle{Identifier: req.Identifier, server: server, entry: entry}
+	}
+	return handles, nil
+}
+
+// admit checks that reqs can be registered, returning the Handles of those that were already registered with their
+// idempotency keys, which aren't registered again, and the awaits to evict to make room for the rest. Nothing is
+// changed. The server's lock must be held.
+func (server *Server) admit(reqs []AwaitRequest, keys []string, entries []*awaited, replace bool) (handles []*Handle,
+	victims []victim, err error) {
+	// Awaits that were already registered with the same idempotency keys are returned as they are.
+	handles = make([]*Handle, len(reqs))
+	registering := 0
+	idempotencyKeys := map[string]bool{}
+	for i, req := range reqs {
+		if key := entries[i].idempotencyKey; key != "" {
+			if idempotencyKeys[key] {
+				return nil, nil, ErrPending
+			}
+			idempotencyKeys[key] = true
+		}
+		if handles[i], _ = server.idempotent(req); handles[i] == nil {
+			registering++
+		}
+	}
+	if registering > 0 && server.drained != nil {
+		server.metrics.observeRejectedRegistration()
+		return nil, nil, ErrDraining
+	}
+	// Identifiers and steps in the batch mustn't collide with pending awaits or with each other.
+	claimed := map[string]bool{}
+	replacing := 0
+	added, replaced := map[string]int{}, map[string]int{}
+	for i, key := range keys {
+		if handles[i] != nil {
+			continue
+		}
+		if claimed[key] {
+			return nil, nil, ErrPending
+		}
+		claimed[key] = true
+		added[entries[i].namespace]++
+		if existing, ok := server.awaits.get(key); ok && existing.pending() {
+			if !replace {
+				return nil, nil, ErrPending
+			}
+			replacing++
+			replaced[existing.namespace]++
+		}
+		for _, other := range entries[i].otherKeys() {
+			if _, existing, _, ok := server.lookup(other); (ok && existing.pending()) || claimed[other] {
+				return nil, nil, ErrPending
+			}
+			claimed[other] = true
+		}
+	}
+	if err := server.checkQuotas(added, replaced); err != nil {
+		server.metrics.observeRejectedRegistration()
+		return nil, nil, err
+	}
+	if over := server.pendingCount - replacing + registering - server.MaxPending; server.MaxPending > 0 && over > 0 {
+		// A batch only evicts awaits with a lower priority than all of its own.
+		lowest := PriorityCritical
+		for _, entry := range entries {
+			lowest = min(lowest, entry.priority)
+		}
+		var ok bool
+		if victims, ok = server.evictable(over, lowest, claimed); !ok {
+			server.metrics.observeRejectedRegistration()
+			return nil, nil, ErrAtCapacity
+		}
+	}
+	return handles, victims, nil
+}
+
+// add stores entry under key and has it expire after Timeout. The server's lock must be held.
+func (server *Server) add(key string, entry *awaited) {
+	entry.expires = entry.validFrom().Add(server.Timeout)
+	server.awaits.put(key, entry)
+	server.pendingCount++
+	if server.pendingPriorities == nil {
+		server.pendingPriorities = map[Priority]int{}
+	}
+	server.pendingPriorities[entry.priority]++
+	if server.namespacePending == nil {
+		server.namespacePending = map[string]int{}
+	}
+	server.namespacePending[entry.namespace]++
+	server.touchNamespace(entry.namespace, entry.start)
+	if len(entry.steps) > 0 && server.steps == nil {
+		server.steps = map[string]stepRef{}
+	}
+	for _, step := range entry.steps {
+		server.steps[step.key] = stepRef{key: key, entry: entry, step: step}
+	}
+	if len(entry.aliases) > 0 && server.aliases == nil {
+		server.aliases = map[string]aliasRef{}
+	}
+	for _, alias := range entry.aliases {
+		server.aliases[alias.key] = aliasRef{key: key, entry: entry, name: alias.name}
+	}
+	server.scheduleExpiry(key, entry)
+}
+
+// scheduleExpiry resolves entry with StatusExpired once it expires. If its expiry has been pushed back by then, it's
+// scheduled again, and if Maintenance is pausing expiry, it's parked until that ends.
+func (server *Server) scheduleExpiry(key string, entry *awaited) {
+	time.AfterFunc(time.Until(entry.expires), func() {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		switch {
+		case !entry.pending():
+		case server.pausesExpiry():
+			entry.expiryParked = true
+		case time.Now().Before(entry.expires):
+			server.scheduleExpiry(key, entry)
+		default:
+			server.resolve(key, entry, StatusExpired)
+		}
+	})
+}
+
+// newEntry returns a pending await for req.
+func (server *Server) newEntry(req AwaitRequest) *awaited {
+	entry := &awaited{
+		start:           time.Now(),
+		done:            make(chan struct{}),
+		opened:          make(chan struct{}),
+		code:            req.Code,
+		maxAttempts:     req.MaxAttempts,
+		namespace:       req.Namespace,
+		metadata:        req.Metadata,
+		allowList:       req.AllowList,
+		blockList:       req.BlockList,
+		windows:         req.Windows,
+		priority:        req.Priority,
+		appLink:         appLink(req.AppLink),
+		webFallback:     req.WebFallback,
+		renderer:        req.Renderer,
+		template:        req.Template,
+		responseHeaders: req.Headers.Clone(),
+		idempotencyKey:  idempotencyKey(req),
+	}
+	if req.ActivateAt.After(entry.start) {
+		entry.activates = req.ActivateAt
+	}
+	for _, method := range req.Methods {
+		entry.methods = append(entry.methods, strings.ToUpper(method))
+	}
+	for _, s := range req.Steps {
+		entry.steps = append(entry.steps, &step{name: s.Name, key: server.HashIdentifier(s.Identifier), code: s.Code})
+	}
+	if len(entry.steps) == 0 {
+		for _, a := range req.Aliases {
+			entry.aliases = append(entry.aliases, alias{name: a.Name, key: server.HashIdentifier(a.Identifier)})
+		}
+	}
+	return entry
+}
+
+// Cancel resolves the pending await for identifier with StatusCancelled. It returns false if there was nothing to cancel.
+func (server *Server) Cancel(identifier string) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	key := server.HashIdentifier(identifier)
+	entry, ok := server.awaits.get(key)
+	if !ok || !entry.pending() {
+		return false
+	}
+	server.resolve(key, entry, StatusCancelled)
+	return true
+}
+
+// CancelNamespace resolves every pending await in namespace with StatusCancelled, such as when a tenant is suspended.
+// It returns how many were cancelled.
+func (server *Server) CancelNamespace(namespace string) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	cancelled := 0
+	server.awaits.each(func(key string, entry *awaited) bool {
+		if server.namespacePending[namespace] == 0 {
+			return false
+		}
+		if entry.namespace == namespace && entry.pending() {
+			server.resolve(key, entry, StatusCancelled)
+			cancelled++
+		}
+		return true
+	})
+	return cancelled
+}
+
+// Approve resolves the pending await for identifier with StatusVerified, for approvals made outside of /verify, such
+// as from chat. It returns false if there was nothing to approve.
+func (server *Server) Approve(identifier string) bool {
+	return server.decide(identifier, StatusVerified)
+}
+
+// Deny resolves the pending await for identifier with StatusDenied. It returns false if there was nothing to deny.
+func (server *Server) Deny(identifier string) bool {
+	return server.decide(identifier, StatusDenied)
+}
+
+func (server *Server) decide(identifier string, status int) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	key := server.HashIdentifier(identifier)
+	entry, ok := server.awaits.get(key)
+	if !ok || server.state(entry) != StatePending {
+		return false
+	}
+	entry.requested = true
+	server.resolve(key, entry, status)
+	return true
+}
+
+// Pending returns the identifiers of all awaits that are still pending, as returned by HashIdentifier.
+func (server *Server) Pending() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	identifiers := []string{}
+	server.awaits.each(func(identifier string, entry *awaited) bool {
+		if server.state(entry) == StatePending {
+			identifiers = append(identifiers, identifier)
+		}
+		return true
+	})
+	return identifiers
+}
+
+// resolve resolves a pending await with status, waking up anything waiting on it. The entry is kept around for
+// Retention so that it can still be seen by Peek. The server's lock must be held, exclusively or along with the lock
+// of entry's shard.
+func (server *Server) resolve(key string, entry *awaited, status int) {
+	if !entry.pending() {
+		return
+	}
+	entry.status = status
+	entry.resolved = time.Now()
+	close(entry.done)
+	server.resolveMu.Lock()
+	server.pendingCount--
+	server.checkDrained()
+	server.pendingPriorities[entry.priority]--
+	if server.namespacePending[entry.namespace]--; server.namespacePending[entry.namespace] == 0 {
+		delete(server.namespacePending, entry.namespace)
+	}
+	server.touchNamespace(entry.namespace, entry.resolved)
+	if status == StatusVerified {
+		server.markUsed(key, entry)
+	}
+	server.resolveMu.Unlock()
+	server.metrics.observeResolved(entry)
+	switch status {
+	case StatusVerified:
+		server.Analytics.observe(entry.namespace, entry.resolved, funnelConfirmed)
+		server.Accounting.observe(entry.namespace, accountingVerified)
+		if server.AfterVerify != nil && len(server.AfterVerify.Actions) > 0 {
+			server.AfterVerify.enqueue(server, entry.pendingAwait(key, nil), entry.result())
+		}
+	case StatusExpired:
+		server.Analytics.observe(entry.namespace, entry.resolved, funnelExpired)
+		server.Accounting.observe(entry.namespace, accountingExpired)
+	case StatusBlocked:
+		server.Accounting.observe(entry.namespace, accountingBlocked)
+	}
+	server.emit(Event{
+		Type:        eventType(status),
+		Identifier:  key,
+		Namespace:   entry.namespace,
+		Time:        entry.resolved,
+		ClientIP:    entry.clientIP,
+		Headers:     entry.headers,
+		RequestID:   entry.requestID,
+		TraceParent: entry.traceParent,
+	})
+
+	retention := server.Retention
+	if retention <= 0 {
+		retention = server.Timeout
+	}
+	if status == StatusExpired && server.Resend != nil && server.Resend.Grace > 0 {
+		retention = server.Resend.Grace
+	}
+	time.AfterFunc(retention, func() {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.forget(key, entry)
+	})
+}
+
+// forget removes entry, its steps, its aliases and its idempotency key. The server's lock must be held.
+func (server *Server) forget(key string, entry *awaited) {
+	if existing, _ := server.awaits.get(key); existing == entry {
+		server.awaits.remove(key)
+	}
+	for _, step := range entry.steps {
+		if server.steps[step.key].entry == entry {
+			delete(server.steps, step.key)
+		}
+	}
+	for _, alias := range entry.aliases {
+		if server.aliases[alias.key].entry == entry {
+			delete(server.aliases, alias.key)
+		}
+	}
+	if server.idempotencyKeys[entry.idempotencyKey].entry == entry {
+		delete(server.idempotencyKeys, entry.idempotencyKey)
+	}
+}
+
+// PurgeIdentifier deletes everything kept about the await for identifier, such as the client that resolved it, e.g.
+// to honour a deletion request. A pending await is cancelled first. It returns false if identifier isn't known.
+func (server *Server) PurgeIdentifier(identifier string) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	key := server.HashIdentifier(identifier)
+	entry, ok := server.awaits.get(key)
+	if !ok {
+		return false
+	}
+	server.resolve(key, entry, StatusCancelled)
+	server.forget(key, entry)
+	delete(server.used, key)
+	for _, other := range entry.otherKeys() {
+		delete(server.used, other)
+	}
+	for code, exchange := range server.exchanges {
+		if exchange.key == key {
+			delete(server.exchanges, code)
+		}
+	}
+	return true
+}
+
+// PurgeBefore deletes resolved awaits that resolved before t, returning how many were deleted. Pending awaits are
+// left alone.
+func (server *Server) PurgeBefore(t time.Time) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	purged := 0
+	server.awaits.each(func(key string, entry *awaited) bool {
+		if !entry.pending() && entry.resolved.Before(t) {
+			server.forget(key, entry)
+			purged++
+		}
+		return true
+	})
+	for key, used := range server.used {
+		if used.at.Before(t) {
+			delete(server.used, key)
+		}
+	}
+	return purged
+}
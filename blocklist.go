@@ -0,0 +1,182 @@
+package gotcha
+
+import (
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShadowBanMode is what Server.ShadowBan does with awaits that blocked clients try to verify.
+type ShadowBanMode int
+
+// Shadow-ban modes. In every mode but ShadowBanOff, blocked clients are shown the page that a successful verification
+// would, so that they can't tell they've been caught. They don't get a token, exchange code or session, though.
+const (
+	// ShadowBanOff tells blocked clients that they're blocked.
+	ShadowBanOff ShadowBanMode = iota
+	// ShadowBanBlock resolves the await with StatusBlocked, as if the client had been told.
+	ShadowBanBlock
+	// ShadowBanPending leaves the await pending, so that its real recipient can still verify it.
+	ShadowBanPending
+)
+
+// scanningReason is the reason given to clients blocked by honeypots and honeytokens.
+const scanningReason = "Automated scanning was detected from your address."
+
+// blocklistReason is the reason given to clients blocked by lists that don't give one.
+const blocklistReason = "Your address is on a blocklist."
+
+// blocked is an entry in the runtime blocklist.
+type blocked struct {
+	reason string
+	// until is when the block lifts. It's zero for permanent blocks.
+	until time.Time
+}
+
+// Block adds ip to the runtime blocklist, which is checked alongside BlockList. The block lifts after ttl, or never if
+// ttl is 0. An IPv6 address blocks its IPv6Prefix.
+func (server *Server) Block(ip, reason string, ttl time.Duration) {
+	ip = server.clientKey(ip)
+	entry := blocked{reason: reason}
+	if ttl > 0 {
+		entry.until = time.Now().Add(ttl)
+	}
+
+	server.blockMu.Lock()
+	defer server.blockMu.Unlock()
+	if server.blocked == nil {
+		server.blocked = map[string]blocked{}
+	}
+	// Expired entries are otherwise only removed when they're looked up, so sweep them out whenever the list has
+	// doubled in size.
+	if len(server.blocked) >= server.blockSweepAt {
+		now := time.Now()
+		for ip, entry := range server.blocked {
+			if !entry.until.IsZero() && now.After(entry.until) {
+				delete(server.blocked, ip)
+			}
+		}
+		server.blockSweepAt = 2*len(server.blocked) + 64
+	}
+	server.blocked[ip] = entry
+}
+
+// Unblock removes ip, or its IPv6Prefix, from the runtime blocklist. BlockList isn't changed.
+func (server *Server) Unblock(ip string) {
+	ip = server.clientKey(ip)
+	server.blockMu.Lock()
+	defer server.blockMu.Unlock()
+	delete(server.blocked, ip)
+}
+
+// blockReason returns why ip is blocked, and until when, if it is. The server's lock must be held, since Reload can
+// replace BlockList; see lockedBlockReason.
+func (server *Server) blockReason(ip string) (blocked, bool) {
+	if reason, ok := server.lookupClient(server.BlockList, ip); ok {
+		return blocked{reason: reason}, true
+	}
+
+	ip = server.clientKey(ip)
+	server.blockMu.Lock()
+	entry, ok := server.blocked[ip]
+	if ok && !entry.until.IsZero() && time.Now().After(entry.until) {
+		delete(server.blocked, ip)
+		ok = false
+	}
+	server.blockMu.Unlock()
+	return entry, ok
+}
+
+// lockedBlockReason is like blockReason, for callers that don't hold the server's lock.
+func (server *Server) lockedBlockReason(ip string) (blocked, bool) {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.blockReason(ip)
+}
+
+// blockReasonFor is like blockReason, but takes entry's own BlockList and the RemoteBlockLists for its namespace into
+// account too.
+func (server *Server) blockReasonFor(entry *awaited, ip string) (blocked, bool) {
+	if reason, ok := server.lookupClient(entry.blockList, ip); ok {
+		return blocked{reason: reason}, true
+	}
+	if reason, ok := server.lookupClient(server.Tenants[entry.namespace].BlockList, ip); ok {
+		return blocked{reason: reason}, true
+	}
+	if block, ok := server.blockReason(ip); ok {
+		return block, true
+	}
+	for _, list := range server.RemoteBlockLists {
+		if !list.appliesTo(entry.namespace) {
+			continue
+		}
+		if reason, ok := list.lookup(ip); ok {
+			return blocked{reason: reason}, true
+		}
+	}
+	return blocked{}, false
+}
+
+// BlockReason is what block reasons are executed with when they're text/templates, such as
+// "You're blocked until {{.Until}}. Contact {{.Support}} if this is a mistake."
+type BlockReason struct {
+	// ClientIP is the address of the blocked client.
+	ClientIP string
+	// Until is when the block lifts, such as "Mon, 02 Jan 2006 15:04 UTC", or "" if it's permanent. UntilTime is the
+	// same as a time.Time, for other formats.
+	Until     string
+	UntilTime time.Time
+	// Support is Server.Support.
+	Support string
+}
+
+// expandReason executes block's reason as a template for ip, if it is one. Reasons that fail to execute are shown as
+// they are.
+func (server *Server) expandReason(block blocked, ip string) string {
+	if !strings.Contains(block.reason, "{{") {
+		return block.reason
+	}
+	parsed, ok := server.reasonTemplates.Load(block.reason)
+	if !ok {
+		tmpl, err := template.New("reason").Parse(block.reason)
+		if err != nil {
+			server.logger().Warn("gotcha: parsing block reason failed", "reason", block.reason, "error", err)
+			return block.reason
+		}
+		parsed, _ = server.reasonTemplates.LoadOrStore(block.reason, tmpl)
+	}
+	data := BlockReason{ClientIP: ip, UntilTime: block.until, Support: server.Support}
+	if !block.until.IsZero() {
+		data.Until = block.until.UTC().Format("Mon, 02 Jan 2006 15:04 MST")
+	}
+	var text strings.Builder
+	if err := parsed.(*template.Template).Execute(&text, data); err != nil {
+		server.logger().Warn("gotcha: executing block reason failed", "reason", block.reason, "error", err)
+		return block.reason
+	}
+	return text.String()
+}
+
+// honeypot handles requests to Honeypots by blocking the client. It responds like any other missing page, so that
+// scanners can't tell.
+func (server *Server) honeypot(c *gin.Context) {
+	ttl := server.HoneypotTTL
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
+	server.blockScanner(server.clientIP(c), ttl)
+	c.String(http.StatusNotFound, "404 page not found")
+}
+
+// blockScanner blocks ip, which was caught scanning, for ttl. A trusted proxy is never blocked, since that would block
+// every client behind it; it only gets here if it didn't say whose request it was passing on.
+func (server *Server) blockScanner(ip string, ttl time.Duration) {
+	if server.trusted(ip) {
+		server.logger().Warn("gotcha: not blocking a trusted proxy for scanning", "client_ip", ip)
+		return
+	}
+	server.Block(ip, scanningReason, ttl)
+}
@@ -0,0 +1,66 @@
+package gotcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHoneypotSpoofed checks that a scanner can't get another address blocked by claiming it in X-Forwarded-For.
+func TestHoneypotSpoofed(t *testing.T) {
+	server := &Server{Timeout: 60e9, Honeypots: []string{"/wp-login.php"}}
+	handler, err := server.Handler("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Register(AwaitRequest{Identifier: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/wp-login.php", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if _, blocked := server.lockedBlockReason("198.51.100.9"); blocked {
+		t.Error("the address in X-Forwarded-For was blocked")
+	}
+	if _, blocked := server.lockedBlockReason("203.0.113.7"); !blocked {
+		t.Error("the scanner wasn't blocked")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/verify/a", nil)
+	req.RemoteAddr = "198.51.100.9:1234"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("verifying from the spoofed address got %d, want %d", w.Code, http.StatusOK)
+	}
+	if state, _ := server.Peek("a"); state != StateVerified {
+		t.Errorf("await is %s, want verified", state)
+	}
+}
+
+// TestHoneypotTrustedProxy checks that a trusted proxy that doesn't pass the client's address on isn't blocked, which
+// would block everyone behind it.
+func TestHoneypotTrustedProxy(t *testing.T) {
+	server := &Server{Timeout: 60e9, Honeypots: []string{"/wp-login.php"}, TrustedProxies: []string{"10.0.0.0/8"}}
+	handler, err := server.Handler("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/wp-login.php", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if _, blocked := server.lockedBlockReason("10.0.0.2"); blocked {
+		t.Error("the trusted proxy was blocked")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/wp-login.php", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if _, blocked := server.lockedBlockReason("203.0.113.7"); !blocked {
+		t.Error("the client behind the proxy wasn't blocked")
+	}
+}
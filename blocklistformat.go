@@ -0,0 +1,171 @@
+package gotcha
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ParseBlockList reads a blocklist, mapping each IP address or CIDR prefix on it to the reason it's blocked, which is
+// empty if the list doesn't give one. Existing deny lists can be used as they are:
+//
+//   - JSON, as an array of addresses, an object mapping addresses to reasons, or an array of {"ip": ..., "reason": ...}
+//   - plain text, with an address per line optionally followed by a reason
+//   - CSV, with an address and optionally a reason per row; a header row is skipped
+//   - ipset save output, whose "add" lines are read along with their comments, if any
+//   - nginx deny directives; allow directives are skipped
+//
+// The text formats can be mixed, and # starts a comment in all of them.
+func ParseBlockList(r io.Reader) (map[string]string, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]string{}
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, err
+		}
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			var ip string
+			if err := json.Unmarshal(item, &ip); err == nil {
+				entries[ip] = ""
+				continue
+			}
+			var object struct {
+				IP     string `json:"ip"`
+				Reason string `json:"reason"`
+			}
+			if err := json.Unmarshal(item, &object); err != nil {
+				return nil, err
+			}
+			entries[object.IP] = object.Reason
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		for scanner.Scan() {
+			if entry, reason, ok := parseBlockListLine(scanner.Text()); ok {
+				entries[entry] = reason
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// parseBlockListLine parses a line of a text blocklist, returning false if it doesn't block anything.
+func parseBlockListLine(raw string) (entry, reason string, ok bool) {
+	line, _, _ := strings.Cut(raw, "#")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", "", false
+	}
+	switch fields[0] {
+	case "create", "allow":
+		return "", "", false
+	case "add":
+		// ipset: add <set> <entry> [timeout <seconds>] [comment "<reason>"]. Entries of sets such as hash:ip,port have
+		// more dimensions after the address, which are dropped.
+		if len(fields) < 3 {
+			return "", "", false
+		}
+		entry, _, _ = strings.Cut(fields[2], ",")
+		if _, comment, found := strings.Cut(raw, ` comment "`); found {
+			reason, _, _ = strings.Cut(comment, `"`)
+		}
+		return entry, reason, true
+	case "deny":
+		// nginx: deny <address>;
+		if len(fields) < 2 {
+			return "", "", false
+		}
+		entry = strings.TrimSuffix(fields[1], ";")
+		return entry, "", entry != "" && entry != "all"
+	}
+	if strings.Contains(fields[0], ",") || strings.HasPrefix(fields[0], `"`) {
+		record, err := csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil || len(record) == 0 {
+			return "", "", false
+		}
+		entry = strings.TrimSpace(record[0])
+		if !isBlockListEntry(entry) {
+			// A header row.
+			return "", "", false
+		}
+		if len(record) > 1 {
+			reason = strings.TrimSpace(record[1])
+		}
+		return entry, reason, true
+	}
+	return fields[0], strings.Join(fields[1:], " "), true
+}
+
+// isBlockListEntry reports whether entry is an IP address or CIDR prefix.
+func isBlockListEntry(entry string) bool {
+	if _, err := netip.ParseAddr(entry); err == nil {
+		return true
+	}
+	_, err := netip.ParsePrefix(entry)
+	return err == nil
+}
+
+// ImportBlockList adds the addresses on a blocklist in any format that ParseBlockList reads to the runtime blocklist,
+// as if by Block, with reason for those it doesn't give one. CIDR prefixes, which only RemoteBlockLists match, are
+// skipped. It returns how many addresses were imported and how many entries were skipped.
+func (server *Server) ImportBlockList(r io.Reader, reason string, ttl time.Duration) (imported, skipped int, err error) {
+	entries, err := ParseBlockList(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if reason == "" {
+		reason = blocklistReason
+	}
+	for entry, entryReason := range entries {
+		if _, err := netip.ParseAddr(entry); err != nil {
+			skipped++
+			continue
+		}
+		if entryReason == "" {
+			entryReason = reason
+		}
+		server.Block(entry, entryReason, ttl)
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+// importBlockList handles POST /admin/blocklist, whose body is the blocklist. The reason and ttl query parameters are
+// passed to ImportBlockList.
+func (server *Server) importBlockList(c *gin.Context) {
+	var ttl time.Duration
+	if value := c.Query("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	imported, skipped, err := server.ImportBlockList(c.Request.Body, c.Query("reason"), ttl)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"imported": imported, "skipped": skipped})
+}
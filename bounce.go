@@ -0,0 +1,101 @@
+package gotcha
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetadataEmail is the AwaitRequest.Metadata key of the email address that the await's link was sent to. Bounces
+// that don't name an identifier are matched against it.
+const MetadataEmail = "email"
+
+// Bounce is a report from an email provider that a message bounced or was marked as spam.
+type Bounce struct {
+	// Identifier is the await that the message was for, if the provider echoed it back, e.g. from a custom header.
+	Identifier string `json:"identifier"`
+	// Recipient is the address that the message was sent to. If Identifier is empty, every pending await with this
+	// MetadataEmail is resolved.
+	Recipient string `json:"recipient"`
+	// Type is "bounce" or "complaint".
+	Type string `json:"type"`
+}
+
+// BounceWebhook configures POST /webhooks/bounces.
+type BounceWebhook struct {
+	// Credentials, if set, must be presented by the provider.
+	Credentials *Credentials
+	// Parse extracts bounces from a provider's webhook request. Defaults to a JSON array of Bounce, or a single one.
+	Parse func(r *http.Request) ([]Bounce, error)
+}
+
+// bounceWebhook handles POST /webhooks/bounces.
+func (server *Server) bounceWebhook(c *gin.Context) {
+	webhook := server.Bounces
+	if webhook.Credentials != nil && !webhook.Credentials.authorised(c.Request) {
+		c.Header("WWW-Authenticate", webhook.Credentials.challenge())
+		c.JSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	parse := webhook.Parse
+	if parse == nil {
+		parse = parseBounces
+	}
+	bounces, err := parse(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	resolved := 0
+	for _, bounce := range bounces {
+		resolved += server.bounce(bounce)
+	}
+	c.JSON(http.StatusOK, gin.H{"resolved": resolved})
+}
+
+// parseBounces is the default BounceWebhook.Parse.
+func parseBounces(r *http.Request) ([]Bounce, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		var bounces []Bounce
+		err := json.Unmarshal(body, &bounces)
+		return bounces, err
+	}
+	var bounce Bounce
+	err = json.Unmarshal(body, &bounce)
+	return []Bounce{bounce}, err
+}
+
+// bounce resolves the pending awaits that bounce is about with StatusUndeliverable, returning how many there were.
+func (server *Server) bounce(bounce Bounce) int {
+	if bounce.Identifier != "" {
+		if server.decide(bounce.Identifier, StatusUndeliverable) {
+			return 1
+		}
+		return 0
+	}
+	if bounce.Recipient == "" {
+		return 0
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	resolved := 0
+	server.awaits.each(func(key string, entry *awaited) bool {
+		if server.state(entry) == StatePending && strings.EqualFold(entry.metadata[MetadataEmail], bounce.Recipient) {
+			server.resolve(key, entry, StatusUndeliverable)
+			resolved++
+		}
+		return true
+	})
+	return resolved
+}
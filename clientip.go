@@ -0,0 +1,78 @@
+package gotcha
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseProxies parses TrustedProxies, each of which is an address or a prefix.
+func parseProxies(proxies []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, proxy := range proxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("gotcha: invalid trusted proxy %q", proxy)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0))
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// trusted reports whether ip is one of TrustedProxies.
+func (server *Server) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range server.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that made the request: the address that it came from or, if that's one
+// of TrustedProxies, the one that they passed in ClientIPHeader. Everything that checks or records the client's address
+// goes through here rather than gin's ClientIP, which believes X-Forwarded-For from anyone.
+func (server *Server) clientIP(c *gin.Context) string {
+	remote, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		remote = strings.TrimSpace(c.Request.RemoteAddr)
+	}
+	if !server.trusted(remote) {
+		return remote
+	}
+	name := server.ClientIPHeader
+	if name == "" {
+		name = "X-Forwarded-For"
+	}
+	// Each proxy appends the address that it got the request from, so the client is the last one that wasn't added
+	// by a trusted proxy; anything before that came from the client and can't be believed.
+	addrs := strings.Split(strings.Join(c.Request.Header.Values(name), ","), ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(addrs[i])
+		if addr == "" {
+			continue
+		}
+		if _, err := netip.ParseAddr(addr); err != nil {
+			break
+		}
+		if !server.trusted(addr) || i == 0 {
+			return addr
+		}
+	}
+	return remote
+}
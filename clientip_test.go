@@ -0,0 +1,89 @@
+package gotcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientIP(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		proxies []string
+		header  string
+		remote  string
+		values  map[string]string
+		want    string
+	}{
+		{name: "direct", remote: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "spoofed", remote: "203.0.113.7:1234", values: map[string]string{"X-Forwarded-For": "10.1.1.1"},
+			want: "203.0.113.7"},
+		{name: "spoofed real ip", remote: "203.0.113.7:1234", values: map[string]string{"X-Real-Ip": "10.1.1.1"},
+			want: "203.0.113.7"},
+		{name: "untrusted proxy", proxies: []string{"10.0.0.0/8"}, remote: "192.0.2.1:1234",
+			values: map[string]string{"X-Forwarded-For": "198.51.100.9"}, want: "192.0.2.1"},
+		{name: "trusted proxy", proxies: []string{"10.0.0.0/8"}, remote: "10.0.0.2:1234",
+			values: map[string]string{"X-Forwarded-For": "198.51.100.9"}, want: "198.51.100.9"},
+		{name: "client's own header", proxies: []string{"10.0.0.0/8"}, remote: "10.0.0.2:1234",
+			values: map[string]string{"X-Forwarded-For": "10.1.1.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "proxy chain", proxies: []string{"10.0.0.2", "10.0.0.3"}, remote: "10.0.0.2:1234",
+			values: map[string]string{"X-Forwarded-For": "10.1.1.1, 203.0.113.7, 10.0.0.3"}, want: "203.0.113.7"},
+		{name: "garbage", proxies: []string{"10.0.0.2"}, remote: "10.0.0.2:1234",
+			values: map[string]string{"X-Forwarded-For": "nonsense"}, want: "10.0.0.2"},
+		{name: "no header", proxies: []string{"10.0.0.2"}, remote: "10.0.0.2:1234", want: "10.0.0.2"},
+		{name: "other header", proxies: []string{"10.0.0.2"}, header: "X-Real-Ip", remote: "10.0.0.2:1234",
+			values: map[string]string{"X-Forwarded-For": "10.1.1.1", "X-Real-Ip": "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "ipv6 proxy", proxies: []string{"2001:db8::/32"}, remote: "[2001:db8::1]:1234",
+			values: map[string]string{"X-Forwarded-For": "2001:db9::1"}, want: "2001:db9::1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			proxies, err := parseProxies(test.proxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := &Server{ClientIPHeader: test.header, trustedProxies: proxies}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remote
+			for name, value := range test.values {
+				req.Header.Set(name, value)
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+			if got := server.clientIP(c); got != test.want {
+				t.Errorf("clientIP() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProxiesInvalid(t *testing.T) {
+	if _, err := parseProxies([]string{"10.0.0.0/8", "proxy.example.com"}); err == nil {
+		t.Error("parseProxies accepted a hostname")
+	}
+}
+
+// TestAllowListSpoofed checks that an await's AllowList can't be got around by claiming an allowed address in
+// X-Forwarded-For.
+func TestAllowListSpoofed(t *testing.T) {
+	server := &Server{Timeout: 60e9}
+	handler, err := server.Handler("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Register(AwaitRequest{Identifier: "a", AllowList: []string{"10.1.1.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/verify/a", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("X-Forwarded-For", "10.1.1.1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if state, _ := server.Peek("a"); state != StatePending {
+		t.Errorf("await is %s, want pending", state)
+	}
+}
@@ -0,0 +1,161 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cluster connects gotcha instances so that a verify request can be handled by any of them. If the instance that
+// receives a request doesn't have the await, it forwards the request to the one that does, which handles it as if it
+// had received it directly; the response is then relayed back to the client.
+type Cluster interface {
+	// Forward asks the other instances to handle req. It returns nil if none of them has the await.
+	Forward(ctx context.Context, req *ForwardedRequest) (*ForwardedResponse, error)
+	// Listen passes requests forwarded by other instances to handle until ctx is done. handle returns nil if this
+	// instance doesn't have the await.
+	Listen(ctx context.Context, handle func(*ForwardedRequest) *ForwardedResponse) error
+}
+
+// maxClusterBackoff is the longest that listenCluster waits before listening again.
+const maxClusterBackoff = time.Minute
+
+// listenCluster listens for forwarded requests until ctx is done. If Listen fails or stops, such as because the
+// cluster's backend is unreachable, that's logged and it listens again after a delay that starts at 1 second and
+// doubles, up to maxClusterBackoff, each time Listen fails without having run for that long.
+func (server *Server) listenCluster(ctx context.Context) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := server.Cluster.Listen(ctx, server.handleForwarded)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxClusterBackoff {
+			backoff = time.Second
+		}
+		server.logger().Error("gotcha: listening for forwarded requests stopped", "error", err, "retry", backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff = min(2*backoff, maxClusterBackoff)
+	}
+}
+
+// ForwardedRequest is a verify request passed between instances.
+type ForwardedRequest struct {
+	// Identifier is the identifier being verified, as returned by HashIdentifier. For the device flow, it's empty and
+	// UserCode, in the same form, is set instead.
+	Identifier string      `json:"identifier,omitempty"`
+	UserCode   string      `json:"user_code,omitempty"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body,omitempty"`
+	RemoteAddr string      `json:"remote_addr"`
+}
+
+// ForwardedResponse is the response to a ForwardedRequest.
+type ForwardedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+type forwardedKey struct{}
+
+// isForwarded reports whether the request was forwarded from another instance.
+func isForwarded(c *gin.Context) bool {
+	return c.Request.Context().Value(forwardedKey{}) != nil
+}
+
+// forward hands the request to the Cluster, writing the response if another instance handled it.
+func (server *Server) forward(c *gin.Context, key, userKey string) bool {
+	if server.Cluster == nil || isForwarded(c) {
+		return false
+	}
+	req := &ForwardedRequest{
+		Identifier: key,
+		UserCode:   userKey,
+		Method:     c.Request.Method,
+		URL:        c.Request.URL.RequestURI(),
+		Header:     c.Request.Header,
+		RemoteAddr: c.Request.RemoteAddr,
+	}
+	// By now the body has been read, so it's rebuilt from whatever it was parsed into.
+	if body, ok := c.Get(gin.BodyBytesKey); ok {
+		req.Body = body.([]byte)
+	} else if c.Request.PostForm != nil {
+		req.Body = []byte(c.Request.PostForm.Encode())
+	}
+
+	resp, err := server.Cluster.Forward(c.Request.Context(), req)
+	if err != nil || resp == nil {
+		return false
+	}
+	for key, values := range resp.Header {
+		c.Writer.Header()[key] = values
+	}
+	c.Status(resp.Status)
+	c.Writer.Write(resp.Body)
+	c.Abort()
+	return true
+}
+
+// handleForwarded handles a request forwarded by another instance, if the await is here.
+func (server *Server) handleForwarded(forwarded *ForwardedRequest) *ForwardedResponse {
+	server.mu.Lock()
+	owned := false
+	if forwarded.UserCode != "" {
+		_, owned = server.devices[forwarded.UserCode]
+	} else if _, entry, _, ok := server.lookup(forwarded.Identifier); ok {
+		owned = entry.pending()
+	}
+	server.mu.Unlock()
+	if !owned {
+		return nil
+	}
+
+	ctx := context.WithValue(context.Background(), forwardedKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, forwarded.Method, forwarded.URL, bytes.NewReader(forwarded.Body))
+	if err != nil {
+		return nil
+	}
+	req.Header = forwarded.Header
+	req.RemoteAddr = forwarded.RemoteAddr
+
+	recorder := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+	server.router.ServeHTTP(recorder, req)
+	return &ForwardedResponse{Status: recorder.status, Header: recorder.header, Body: recorder.body.Bytes()}
+}
+
+// responseRecorder captures a response so that it can be sent back to another instance.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (recorder *responseRecorder) Header() http.Header {
+	return recorder.header
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	if !recorder.wroteHeader {
+		recorder.status = status
+		recorder.wroteHeader = true
+	}
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	recorder.WriteHeader(http.StatusOK)
+	return recorder.body.Write(data)
+}
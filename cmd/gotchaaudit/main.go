@@ -0,0 +1,74 @@
+// Command gotchaaudit verifies an audit log written by gotcha.AuditLog, exiting with status 1 if it's been tampered
+// with.
+//
+//	gotchaaudit [-key-file path] [-head hash] audit.log
+//
+// The key, if the log was signed with one, is read from -key-file or GOTCHAAUDIT_KEY. -head checks that the log still
+// has the entry with this hash, as returned by an earlier AuditLog.Head, which catches truncation.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fjah/gotcha"
+)
+
+func main() {
+	keyFile := flag.String("key-file", "", "file holding the key that the log was signed with")
+	head := flag.String("head", "", "hash of an entry that the log must contain")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: gotchaaudit [-key-file path] [-head hash] audit.log")
+		os.Exit(2)
+	}
+	key := []byte(os.Getenv("GOTCHAAUDIT_KEY"))
+	if *keyFile != "" {
+		var err error
+		if key, err = os.ReadFile(*keyFile); err != nil {
+			fatal(err)
+		}
+		key = bytes.TrimSpace(key)
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	defer file.Close()
+	entries, last, err := gotcha.VerifyAuditLog(file, key)
+	if err != nil {
+		fatal(err)
+	}
+	if *head != "" && !contains(flag.Arg(0), *head) {
+		fatal(fmt.Errorf("%w: no entry has hash %s", gotcha.ErrAuditLogTampered, *head))
+	}
+	fmt.Printf("ok: %d entries, head %s\n", entries, last)
+}
+
+// contains reports whether the log at path has an entry with hash.
+func contains(path, hash string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry gotcha.AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "gotchaaudit: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fjah/gotcha"
+	"github.com/gin-gonic/gin"
+)
+
+// newControl returns the control API for server, over HTTP; there's no gRPC flavour of it. Every request must carry
+// "Authorization: Bearer <token>".
+//
+//	POST   /awaits              register an await; the body is {"identifier": "...", "activate_at": "..."}, where the
+//	                            identifier is generated if omitted and activate_at optionally schedules the await;
+//	                            retries with the same Idempotency-Key header get the first await back, with 200 OK
+//	POST   /awaits/batch        register many awaits at once; the body is {"identifiers": ["...", ...]}
+//	GET    /awaits              list pending identifiers
+//	GET    /awaits/:identifier  get the state of an await
+//	DELETE /awaits/:identifier  cancel a pending await
+//	DELETE /namespaces/:namespace/awaits
+//	                            cancel every pending await in a namespace
+//	POST   /drain               stop registering awaits while the pending ones finish; the response has how many are
+//	                            still pending, and ?wait=true holds it until there are none
+//	DELETE /drain               register awaits again
+//	GET    /export              export the pending awaits, for moving them to another instance
+//	POST   /import              import awaits from the body, which is an export
+//
+// Callers find out how an await resolved by polling its state, or with the /wait endpoint on the main server.
+func newControl(server *gotcha.Server, token string) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery(), func(c *gin.Context) {
+		authorization := c.GetHeader("Authorization")
+		presented := strings.TrimPrefix(authorization, "Bearer ")
+		if !strings.HasPrefix(authorization, "Bearer ") || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": http.StatusText(http.StatusUnauthorized)})
+		}
+	})
+
+	router.POST("/awaits", func(c *gin.Context) {
+		var body struct {
+			Identifier string    `json:"identifier"`
+			ActivateAt time.Time `json:"activate_at"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if body.Identifier == "" {
+			identifier := make([]byte, 32)
+			if _, err := rand.Read(identifier); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			body.Identifier = base64.RawURLEncoding.EncodeToString(identifier)
+		}
+		handle, err := server.Register(gotcha.AwaitRequest{Identifier: body.Identifier, ActivateAt: body.ActivateAt,
+			IdempotencyKey: c.GetHeader("Idempotency-Key")})
+		if err != nil {
+			registerFailed(c, err)
+			return
+		}
+		if handle.Duplicate {
+			state, _ := server.Peek(handle.Identifier)
+			c.JSON(http.StatusOK, gin.H{"identifier": handle.Identifier, "state": state.String()})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"identifier": handle.Identifier, "state": gotcha.StatePending.String()})
+	})
+
+	router.POST("/awaits/batch", func(c *gin.Context) {
+		var body struct {
+			Identifiers []string `json:"identifiers" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		reqs := make([]gotcha.AwaitRequest, len(body.Identifiers))
+		for i, identifier := range body.Identifiers {
+			reqs[i] = gotcha.AwaitRequest{Identifier: identifier}
+		}
+		if _, err := server.AwaitBatch(reqs); err != nil {
+			registerFailed(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"identifiers": body.Identifiers, "state": gotcha.StatePending.String()})
+	})
+
+	router.GET("/awaits", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"pending": server.Pending()})
+	})
+
+	router.GET("/awaits/:identifier", func(c *gin.Context) {
+		state, ok := server.Peek(c.Param("identifier"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"identifier": c.Param("identifier"), "state": state.String()})
+	})
+
+	router.DELETE("/awaits/:identifier", func(c *gin.Context) {
+		if !server.Cancel(c.Param("identifier")) {
+			c.JSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	router.DELETE("/namespaces/:namespace/awaits", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"cancelled": server.CancelNamespace(c.Param("namespace"))})
+	})
+
+	router.POST("/drain", func(c *gin.Context) {
+		drained := server.Drain()
+		if c.Query("wait") == "true" {
+			select {
+			case <-drained:
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"draining": true, "pending": len(server.Pending())})
+	})
+
+	router.DELETE("/drain", func(c *gin.Context) {
+		if !server.Undrain() {
+			c.JSON(http.StatusNotFound, gin.H{"message": http.StatusText(http.StatusNotFound)})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	router.GET("/export", func(c *gin.Context) {
+		var export bytes.Buffer
+		if _, err := server.Export(&export); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "application/octet-stream", export.Bytes())
+	})
+
+	router.POST("/import", func(c *gin.Context) {
+		imported, err := server.Import(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"imported": imported})
+	})
+
+	return router
+}
+
+// registerFailed responds to a request to register awaits that failed with err.
+func registerFailed(c *gin.Context, err error) {
+	var limited *gotcha.RegistrationLimitError
+	if errors.As(err, &limited) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": err.Error()})
+		return
+	}
+	status := http.StatusConflict
+	switch {
+	case errors.Is(err, gotcha.ErrAtCapacity), errors.Is(err, gotcha.ErrOverQuota):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, gotcha.ErrUnavailable), errors.Is(err, gotcha.ErrDraining):
+		status = http.StatusServiceUnavailable
+	}
+	if status != http.StatusConflict {
+		c.Header("Retry-After", "1")
+	}
+	c.JSON(status, gin.H{"message": err.Error()})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fjah/gotcha"
+)
+
+func TestControlAuthorization(t *testing.T) {
+	control := newControl(&gotcha.Server{}, "secret")
+	for _, test := range []struct {
+		authorization string
+		status        int
+	}{
+		{"Bearer secret", http.StatusOK},
+		{"secret", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"bearer secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/awaits", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		control.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("Authorization %q got %d, want %d", test.authorization, w.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Command gotchad runs a Gotcha server as a standalone daemon, so that services not written in Go can use it for
+// verification links. Awaits are managed over an authenticated HTTP control API; see control.go. On SIGHUP, the
+// config file is reread and the timeout, blocklist, templates and rate limit are applied without a restart. On
+// SIGUSR2, gotchad hands over to a new copy of its executable, pending awaits and all, for zero-downtime deploys.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fjah/gotcha"
+	"github.com/fjah/gotcha/gotchaquic"
+)
+
+// Config is the format of the gotchad config file.
+type Config struct {
+	// Address is the address that verification links are served on.
+	Address string `json:"address"`
+	// Listeners, if set, are listened on in place of Address, such as ":80" with redirect_https and ":443" with tls.
+	Listeners []struct {
+		Address       string `json:"address"`
+		TLS           bool   `json:"tls"`
+		RedirectHTTPS bool   `json:"redirect_https"`
+	} `json:"listeners"`
+	// Timeout is how long awaits stay valid for, e.g. "15m".
+	Timeout string `json:"timeout"`
+	// BlockList maps IP addresses to the reasons they're blocked.
+	BlockList map[string]string `json:"block_list"`
+	// BlockListFile, if set, is a file of IP addresses to block on top of BlockList, in any format that
+	// gotcha.ParseBlockList reads, e.g. one per line, each optionally followed by the reason, CSV, ipset save output or
+	// nginx deny directives.
+	BlockListFile string `json:"block_list_file"`
+	// Support is a contact address that block reasons can include as {{.Support}}.
+	Support string `json:"support"`
+	// Templates, if set, is a directory of templates that replace the built-in pages.
+	Templates string `json:"templates"`
+	// RateLimit, if requests is set, limits each client to that many verification requests per window, e.g. "1m".
+	RateLimit rateLimit `json:"rate_limit"`
+	// TLSCert and TLSKey enable HTTPS when both are set.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// Certificates are extra cert/key pairs, chosen by the hostname that clients ask for. Hosts defaults to the names
+	// in the certificate.
+	Certificates []struct {
+		Hosts []string `json:"hosts"`
+		Cert  string   `json:"cert"`
+		Key   string   `json:"key"`
+	} `json:"certificates"`
+	// HTTP3 also serves verification links over HTTP/3 on the same port. It needs TLSCert and TLSKey.
+	HTTP3 bool `json:"http3"`
+	// AdminToken enables the admin endpoints, such as /admin/metrics, on the main server.
+	AdminToken string `json:"admin_token"`
+	// Snapshot, if set, is a file that pending awaits and runtime blocks are saved to every minute and restored from
+	// on startup.
+	Snapshot string `json:"snapshot"`
+	// MaxPending is the most awaits that can be pending at once. Zero doesn't limit them.
+	MaxPending int `json:"max_pending"`
+	// RegistrationLimit, if requests is set, limits how many awaits can be registered per window, e.g. "1m".
+	RegistrationLimit rateLimit `json:"registration_limit"`
+	// Limits cap concurrent connections and verification requests. Zero doesn't limit them.
+	Limits struct {
+		MaxConnections   int `json:"max_connections"`
+		MaxInFlight      int `json:"max_in_flight"`
+		MaxInFlightPerIP int `json:"max_in_flight_per_ip"`
+	} `json:"limits"`
+	// StatsD, if its address is set, exports metrics to a statsd agent instead of, or as well as, /admin/metrics.
+	StatsD struct {
+		Address string `json:"address"`
+		Prefix  string `json:"prefix"`
+		// DogStatsD sends outcomes, and Tags, as DogStatsD tags.
+		DogStatsD bool     `json:"dogstatsd"`
+		Tags      []string `json:"tags"`
+	} `json:"statsd"`
+	// ASN, if its database is set, blocks or flags clients by their autonomous system, looked up in a MaxMind DB file
+	// such as GeoLite2-ASN. Block maps AS numbers to block reasons, which can be empty; Flag only flags them.
+	ASN struct {
+		Database string            `json:"database"`
+		Block    map[uint32]string `json:"block"`
+		Flag     []uint32          `json:"flag"`
+	} `json:"asn"`
+	// AuditLog, if set, is a file that events are appended to as a tamper-evident hash chain, signed with
+	// GOTCHAD_AUDIT_KEY if it's set. Check it with gotchaaudit.
+	AuditLog string `json:"audit_log"`
+	// Control configures the control API.
+	Control struct {
+		// Address is the address that the control API is served on. It should not be publicly reachable.
+		Address string `json:"address"`
+		// Token is the bearer token that callers must present. GOTCHAD_CONTROL_TOKEN overrides it.
+		Token string `json:"token"`
+	} `json:"control"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := &Config{Address: ":8080", Timeout: "15m"}
+	config.Control.Address = "127.0.0.1:8081"
+	if err := json.NewDecoder(file).Decode(config); err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("GOTCHAD_CONTROL_TOKEN"); token != "" {
+		config.Control.Token = token
+	}
+	if config.Control.Token == "" {
+		return nil, errors.New("a control token is required")
+	}
+	return config, nil
+}
+
+func main() {
+	configPath := flag.String("config", "gotchad.json", "path to the config file")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("gotchad: loading config: %v", err)
+	}
+	settings, err := config.settings(nil)
+	if err != nil {
+		log.Fatalf("gotchad: invalid config: %v", err)
+	}
+
+	server := &gotcha.Server{
+		Address:    config.Address,
+		UseTLS:     config.TLSCert != "" && config.TLSKey != "",
+		TLSCert:    config.TLSCert,
+		TLSKey:     config.TLSKey,
+		AdminToken: config.AdminToken,
+		MaxPending: config.MaxPending,
+		Support:    config.Support,
+	}
+	settings.apply(server)
+	if config.Snapshot != "" {
+		server.Snapshot = &gotcha.Snapshot{Path: config.Snapshot}
+	}
+	if config.RegistrationLimit.Requests > 0 {
+		window, err := time.ParseDuration(config.RegistrationLimit.Window)
+		if err != nil {
+			log.Fatalf("gotchad: invalid config: %v", err)
+		}
+		server.RegistrationLimiter = gotcha.NewRateLimiter(config.RegistrationLimit.Requests, window)
+	}
+	if config.Limits.MaxConnections > 0 || config.Limits.MaxInFlight > 0 || config.Limits.MaxInFlightPerIP > 0 {
+		server.Limits = &gotcha.Limits{
+			MaxConnections:   config.Limits.MaxConnections,
+			MaxInFlight:      config.Limits.MaxInFlight,
+			MaxInFlightPerIP: config.Limits.MaxInFlightPerIP,
+		}
+	}
+	for _, listener := range config.Listeners {
+		server.Listeners = append(server.Listeners, gotcha.Listener{
+			Address:       listener.Address,
+			TLS:           listener.TLS,
+			RedirectHTTPS: listener.RedirectHTTPS,
+		})
+	}
+	for _, certificate := range config.Certificates {
+		server.TLSCertificates = append(server.TLSCertificates, gotcha.TLSCertificate{
+			Hosts:    certificate.Hosts,
+			CertFile: certificate.Cert,
+			KeyFile:  certificate.Key,
+		})
+	}
+	if config.HTTP3 {
+		server.HTTP3 = &gotchaquic.Listener{}
+	}
+	if config.StatsD.Address != "" {
+		server.StatsD = &gotcha.StatsD{
+			Address:   config.StatsD.Address,
+			Prefix:    config.StatsD.Prefix,
+			DogStatsD: config.StatsD.DogStatsD,
+			Tags:      config.StatsD.Tags,
+		}
+	}
+	if config.ASN.Database != "" {
+		db, err := gotcha.OpenASNDatabase(config.ASN.Database)
+		if err != nil {
+			log.Fatalf("gotchad: opening ASN database: %v", err)
+		}
+		server.ASN = &gotcha.ASNFilter{Lookup: db.Lookup, Block: config.ASN.Block, Flag: config.ASN.Flag}
+	}
+	if config.AuditLog != "" {
+		audit, err := gotcha.OpenAuditLog(config.AuditLog, []byte(os.Getenv("GOTCHAD_AUDIT_KEY")))
+		if err != nil {
+			log.Fatalf("gotchad: opening audit log: %v", err)
+		}
+		server.EventSinks = append(server.EventSinks, audit)
+	}
+
+	go reloadOnHangup(server, *configPath, settings)
+
+	go upgradeOnSignal(server, newControl(server, config.Control.Token), config.Control.Address)
+	if err := server.Serve(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("gotchad: %v", err)
+	}
+	// An upgrade has stopped the server; let it finish handing over before exiting.
+	<-upgraded
+}
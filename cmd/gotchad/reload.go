@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fjah/gotcha"
+)
+
+// settings are the parts of the config that are reread on SIGHUP.
+type settings struct {
+	timeout     time.Duration
+	blockList   map[string]string
+	templates   string
+	rateLimit   rateLimit
+	rateLimiter gotcha.RateLimiter
+}
+
+type rateLimit struct {
+	Requests int    `json:"requests"`
+	Window   string `json:"window"`
+}
+
+// settings parses the reloadable parts of config. previous, if set, are the settings in use, whose rate limiter is
+// kept if the limit hasn't changed so that clients' counts aren't reset.
+func (config *Config) settings(previous *settings) (*settings, error) {
+	timeout, err := time.ParseDuration(config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	blockList := map[string]string{}
+	for ip, reason := range config.BlockList {
+		blockList[ip] = reason
+	}
+	if config.BlockListFile != "" {
+		if err := readBlockList(config.BlockListFile, blockList); err != nil {
+			return nil, err
+		}
+	}
+	next := &settings{timeout: timeout, blockList: blockList, templates: config.Templates, rateLimit: config.RateLimit}
+	if config.RateLimit.Requests > 0 {
+		if previous != nil && previous.rateLimit == config.RateLimit {
+			next.rateLimiter = previous.rateLimiter
+		} else {
+			window, err := time.ParseDuration(config.RateLimit.Window)
+			if err != nil {
+				return nil, err
+			}
+			next.rateLimiter = gotcha.NewRateLimiter(config.RateLimit.Requests, window)
+		}
+	}
+	return next, nil
+}
+
+// apply sets the settings on server, which mustn't be running unless it's locked, as it is in Reload.
+func (settings *settings) apply(server *gotcha.Server) {
+	server.Timeout = settings.timeout
+	server.BlockList = settings.blockList
+	server.TemplateDir = settings.templates
+	server.RateLimiter = settings.rateLimiter
+}
+
+// readBlockList adds the entries in the file at path, in any format that gotcha.ParseBlockList reads, to blockList.
+func readBlockList(path string, blockList map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entries, err := gotcha.ParseBlockList(file)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for ip, reason := range entries {
+		blockList[ip] = reason
+	}
+	return nil
+}
+
+// reloadOnHangup rereads the config at path whenever the process gets SIGHUP, applying the settings that can change
+// while server is running. Bad configs are logged and ignored.
+func reloadOnHangup(server *gotcha.Server, path string, current *settings) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		config, err := loadConfig(path)
+		var next *settings
+		if err == nil {
+			next, err = config.settings(current)
+		}
+		if err == nil {
+			err = server.Reload(next.apply)
+		}
+		if err != nil {
+			log.Printf("gotchad: reloading config: %v", err)
+			continue
+		}
+		current = next
+		log.Printf("gotchad: reloaded config")
+	}
+}
+
+// upgraded is closed once an upgrade has handed over to the new process.
+var upgraded = make(chan struct{})
+
+// upgradeOnSignal serves the control API on address, and hands over to a new process when this one gets SIGUSR2.
+// The control API isn't handed over, so it's closed first to free its address; it's briefly unavailable while the new
+// process starts.
+func upgradeOnSignal(server *gotcha.Server, control http.Handler, address string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	for {
+		controlServer := &http.Server{Addr: address, Handler: control}
+		go func() {
+			if err := controlServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("gotchad: %v", err)
+			}
+		}()
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		controlServer.Shutdown(ctx)
+		process, err := server.Upgrade(ctx)
+		cancel()
+		if process == nil {
+			// Nothing was started, so this process carries on.
+			log.Printf("gotchad: upgrading: %v", err)
+			continue
+		}
+		if err != nil {
+			log.Printf("gotchad: upgrading: %v", err)
+		}
+		log.Printf("gotchad: handed over to process %d", process.Pid)
+		close(upgraded)
+		return
+	}
+}
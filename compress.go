@@ -0,0 +1,148 @@
+package gotcha
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Compression configures response compression.
+type Compression struct {
+	// Types are the content types to compress. Defaults to HTML and JSON.
+	Types []string
+	// Level is the compression level, as defined by compress/flate. Defaults to flate.DefaultCompression.
+	Level int
+}
+
+func (compression *Compression) compresses(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := compression.Types
+	if len(types) == 0 {
+		types = []string{gin.MIMEHTML, gin.MIMEJSON}
+	}
+	for _, compressed := range types {
+		if strings.EqualFold(mediaType, compressed) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, preferring gzip. It returns "" if the client
+// doesn't accept either.
+func negotiateEncoding(header string) string {
+	// This runs on every request, so it scans the header rather than splitting it up.
+	gzip, deflate := false, false
+	for header != "" {
+		var part string
+		part, header, _ = strings.Cut(header, ",")
+		coding, params, _ := strings.Cut(part, ";")
+		accepted := true
+		for params != "" {
+			var param string
+			param, params, _ = strings.Cut(params, ";")
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					accepted = false
+				}
+			}
+		}
+		switch coding = strings.TrimSpace(coding); {
+		case strings.EqualFold(coding, "gzip"):
+			gzip = accepted
+		case strings.EqualFold(coding, "deflate"):
+			deflate = accepted
+		}
+	}
+	switch {
+	case gzip:
+		return "gzip"
+	case deflate:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter compresses the response if its content type is one of Compression.Types. It decides on the first
+// write, by which point handlers have set Content-Type.
+type compressWriter struct {
+	gin.ResponseWriter
+	compression *Compression
+	encoding    string
+	decided     bool
+	encoder     io.WriteCloser
+}
+
+func (w *compressWriter) decide() {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || !w.compression.compresses(header.Get("Content-Type")) {
+		return
+	}
+	header.Add("Vary", "Accept-Encoding")
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+
+	level := w.compression.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var err error
+	if w.encoding == "gzip" {
+		w.encoder, err = gzip.NewWriterLevel(w.ResponseWriter, level)
+	} else {
+		w.encoder, err = zlib.NewWriterLevel(w.ResponseWriter, level)
+	}
+	if err != nil {
+		header.Del("Content-Encoding")
+		w.encoder = nil
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decide()
+	}
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.encoder.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Flush() {
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// compress is middleware that compresses responses when Compression is set.
+func (server *Server) compress(c *gin.Context) {
+	if server.Compression == nil || c.Request.Method == http.MethodHead {
+		return
+	}
+	encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+	if encoding == "" {
+		return
+	}
+	writer := &compressWriter{ResponseWriter: c.Writer, compression: server.Compression, encoding: encoding}
+	c.Writer = writer
+	c.Next()
+	if writer.encoder != nil {
+		writer.encoder.Close()
+	}
+}
@@ -0,0 +1,153 @@
+package gotcha
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// markOpened records that entry's confirmation page has been shown to the client, the first time that it is. The
+// server's lock must be held.
+func (server *Server) markOpened(key string, entry *awaited, c *gin.Context) {
+	if entry.isOpened() {
+		return
+	}
+	close(entry.opened)
+	server.emit(Event{Type: EventOpened, Identifier: key, Namespace: entry.namespace, Time: time.Now(), ClientIP: server.clientIP(c),
+		TraceParent: traceParent(c)})
+}
+
+// isOpened reports whether entry's confirmation page has been shown.
+func (entry *awaited) isOpened() bool {
+	select {
+	case <-entry.opened:
+		return true
+	default:
+		return false
+	}
+}
+
+// confirmPage handles GET /verify/:identifier when Confirm is set. Pending awaits get a page with a button that
+// confirms them at POST /confirm/:identifier; anything else is answered as verify would.
+func (server *Server) confirmPage(c *gin.Context) {
+	identifier := c.Param("identifier")
+	server.fallback(c, identifier)
+	key := server.HashIdentifier(identifier)
+
+	server.mu.Lock()
+	awaitKey, entry, current, ok := server.lookupIn(c, key)
+	pending := ok && server.state(entry) == StatePending && !entry.scheduled() && entry.open(time.Now())
+	if pending {
+		server.markRequested(entry)
+		server.markOpened(awaitKey, entry, c)
+		entry.nonce = newChallenge()
+	}
+	data := page{
+		Theme:       server.currentTheme(c),
+		Heading:     "Confirm this request",
+		Text:        "Press the button below to continue.",
+		Action:      server.basePath() + "/confirm/" + url.PathEscape(identifier),
+		Fingerprint: server.Fingerprint != nil,
+	}
+	if pending {
+		data.Nonce = entry.nonce
+	}
+	if pending {
+		await := entry.pendingAwait(awaitKey, current)
+		data.Context = RenderContext{Status: http.StatusOK, Code: "pending", Await: &await,
+			Remaining: time.Until(entry.expires), ClientIP: server.clientIP(c), UserAgent: c.Request.UserAgent()}
+	}
+	// The code, and the attribution that CaptureAttribution looks for, are carried over to the confirming request.
+	query := url.Values{}
+	for name, values := range c.Request.URL.Query() {
+		if name == "code" || server.CaptureAttribution && strings.HasPrefix(name, "utm_") {
+			query[name] = values[:1]
+		}
+	}
+	if len(query) > 0 {
+		data.Action += "?" + query.Encode()
+	}
+	if pending && server.WebAuthn != nil {
+		if credentials := entry.metadata[MetadataWebAuthnCredentials]; credentials != "" {
+			entry.challenge = newChallenge()
+			data.Challenge, data.RPID = entry.challenge, server.WebAuthn.RPID
+			data.Credentials = strings.Split(credentials, ",")
+			data.Text = "Press the button below and use your passkey to continue."
+		}
+	}
+	server.mu.Unlock()
+
+	if !ok && server.forward(c, key, "") {
+		return
+	}
+	if !pending {
+		server.verify(c, key)
+		return
+	}
+	data.CSRF = server.csrfToken(c)
+	data.Theme.execute(c, http.StatusOK, "confirm.html", data)
+}
+
+// confirm handles POST /confirm/:identifier, from the page served by confirmPage. The form's nonce must be the one
+// that the page was last served with. Awaits that need a passkey are only verified once its assertion has been
+// checked.
+func (server *Server) confirm(c *gin.Context) {
+	key := server.HashIdentifier(c.Param("identifier"))
+	nonce := c.PostForm("nonce")
+
+	server.mu.Lock()
+	_, entry, current, ok := server.lookupIn(c, key)
+	var credentials, challenge string
+	var pending PendingAwait
+	replayed := false
+	if ok && entry.pending() {
+		// Each nonce and challenge can only be used once.
+		replayed = entry.nonce == "" || subtle.ConstantTimeCompare([]byte(nonce), []byte(entry.nonce)) != 1
+		entry.nonce = ""
+		if server.WebAuthn != nil {
+			credentials, challenge = entry.metadata[MetadataWebAuthnCredentials], entry.challenge
+			entry.challenge = ""
+			pending = entry.pendingAwait(key, current)
+		}
+	}
+	server.mu.Unlock()
+
+	if !ok && server.forward(c, key, "") {
+		return
+	}
+	if replayed {
+		server.metrics.observeReplay()
+		status := http.StatusUnauthorized
+		server.render(c, RenderContext{Status: status, Code: "invalid_nonce", Body: map[string]string{
+			"message": http.StatusText(status),
+			"reason":  "This page has already been submitted, or is out of date. Reload it and try again.",
+		}})
+		return
+	}
+	if credentials != "" {
+		if err := server.WebAuthn.verify(c, server.BaseURL, pending, challenge, strings.Split(credentials, ",")); err != nil {
+			c.Error(err)
+			server.logger().Warn("gotcha: passkey verification failed", "identifier", key, "error", err)
+			status := http.StatusUnauthorized
+			server.render(c, RenderContext{Status: status, Code: "invalid_passkey", Body: map[string]string{
+				"message": http.StatusText(status),
+				"reason":  "Your passkey couldn't be verified. Reload the page and try again.",
+			}})
+			return
+		}
+	}
+	server.verify(c, key)
+}
+
+// newChallenge returns a random WebAuthn challenge.
+func newChallenge() string {
+	challenge := make([]byte, 32)
+	rand.Read(challenge)
+	return base64.RawURLEncoding.EncodeToString(challenge)
+}
@@ -0,0 +1,53 @@
+package gotcha
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// CSRFCookie is the cookie that the built-in forms' CSRF token is set in when Server.CSRF is enabled. The same token
+// is posted back in the "csrf_token" form field.
+const CSRFCookie = "gotcha_csrf"
+
+// csrfToken returns the client's CSRF token, setting CSRFCookie to a new one if it doesn't have one yet. It returns
+// "" if CSRF isn't enabled.
+func (server *Server) csrfToken(c *gin.Context) string {
+	if !server.CSRF {
+		return ""
+	}
+	if token, err := c.Cookie(CSRFCookie); err == nil && len(token) == 43 {
+		return token
+	}
+	token := newChallenge()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    token,
+		Path:     server.prefix + "/",
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// checkCSRF is middleware that rejects form posts whose "csrf_token" field doesn't match CSRFCookie, when CSRF is
+// enabled. JSON bodies aren't checked, as a browser won't send them cross-site without a CORS preflight.
+func (server *Server) checkCSRF(c *gin.Context) {
+	if !server.CSRF || c.ContentType() == binding.MIMEJSON {
+		return
+	}
+	cookie, err := c.Cookie(CSRFCookie)
+	field := c.PostForm("csrf_token")
+	if err != nil || cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(field)) != 1 {
+		server.metrics.observeCSRFFailure()
+		status := http.StatusForbidden
+		server.render(c, RenderContext{Status: status, Code: "invalid_csrf_token", Body: map[string]string{
+			"message": http.StatusText(status),
+			"reason":  "This form has expired. Reload the page and try again.",
+		}})
+		c.Abort()
+	}
+}
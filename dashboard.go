@@ -0,0 +1,103 @@
+package gotcha
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardRecent is how many recent outcomes the dashboard shows.
+const dashboardRecent = 50
+
+// dashboardAwait is a row in the dashboard's tables.
+type dashboardAwait struct {
+	Identifier string    `json:"identifier"`
+	Namespace  string    `json:"namespace,omitempty"`
+	State      string    `json:"state"`
+	Registered time.Time `json:"registered"`
+	Expires    time.Time `json:"expires"`
+	Resolved   time.Time `json:"resolved,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+}
+
+// dashboardBlock is an entry in the blocklist.
+type dashboardBlock struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until,omitempty"`
+	// Static is set for entries in Server.BlockList, which can't be removed at runtime.
+	Static bool `json:"static"`
+}
+
+// dashboard handles GET /admin/dashboard.
+func (server *Server) dashboard(c *gin.Context) {
+	theme := server.theme(c)
+	theme.execute(c, http.StatusOK, "dashboard.html", page{Theme: theme, Heading: "Dashboard"})
+}
+
+// dashboardData handles GET /admin/dashboard/data, which the dashboard polls.
+func (server *Server) dashboardData(c *gin.Context) {
+	pending, recent := []dashboardAwait{}, []dashboardAwait{}
+	server.mu.Lock()
+	server.awaits.each(func(key string, entry *awaited) bool {
+		row := dashboardAwait{
+			Identifier: key,
+			Namespace:  entry.namespace,
+			State:      server.state(entry).String(),
+			Registered: entry.start,
+			Expires:    entry.expires,
+		}
+		if entry.pending() {
+			pending = append(pending, row)
+			return true
+		}
+		row.Resolved, row.ClientIP = entry.resolved, entry.clientIP
+		recent = append(recent, row)
+		return true
+	})
+	blocks := []dashboardBlock{}
+	for ip, reason := range server.BlockList {
+		blocks = append(blocks, dashboardBlock{IP: ip, Reason: reason, Static: true})
+	}
+	server.mu.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Registered.Before(pending[j].Registered) })
+	sort.Slice(recent, func(i, j int) bool { return recent[i].Resolved.After(recent[j].Resolved) })
+	if len(recent) > dashboardRecent {
+		recent = recent[:dashboardRecent]
+	}
+
+	now := time.Now()
+	server.blockMu.Lock()
+	for ip, entry := range server.blocked {
+		if entry.until.IsZero() || now.Before(entry.until) {
+			blocks = append(blocks, dashboardBlock{IP: ip, Reason: entry.reason, Until: entry.until})
+		}
+	}
+	server.blockMu.Unlock()
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].IP < blocks[j].IP })
+
+	server.metrics.mu.Lock()
+	outcomes := map[string]uint64{}
+	for status, count := range server.metrics.resolved {
+		outcomes[stateOf(status).String()] = count
+	}
+	timeToVerify := make([]gin.H, 0, len(server.metrics.verifyCounts))
+	for i, count := range server.metrics.verifyCounts {
+		bound := "+Inf"
+		if i < len(verifyBuckets) {
+			bound = verifyBuckets[i].String()
+		}
+		timeToVerify = append(timeToVerify, gin.H{"le": bound, "count": count})
+	}
+	server.metrics.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"pending":        pending,
+		"recent":         recent,
+		"blocklist":      blocks,
+		"outcomes":       outcomes,
+		"time_to_verify": timeToVerify,
+	})
+}
@@ -0,0 +1,220 @@
+package gotcha
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Delivery is a message, such as a verification email or SMS, that's sent in the background by a Dispatcher.
+type Delivery struct {
+	// ID identifies the delivery. Enqueue fills it in if it's empty.
+	ID string `json:"id"`
+	// Channel says how to deliver it, e.g. "email" or "sms".
+	Channel string `json:"channel"`
+	// To is the recipient, e.g. an email address or phone number.
+	To string `json:"to"`
+	// URL is the verification link, and Expires is when it stops working. Deliver fills them in.
+	URL     string    `json:"url,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+	// Data is anything else that's needed to deliver it, such as a template name.
+	Data map[string]string `json:"data,omitempty"`
+	// Attempts is how many times delivery has already been tried.
+	Attempts int `json:"attempts"`
+}
+
+// Queue holds deliveries until they're due. It's safe for concurrent use. See NewMemoryQueue and gotcharedis.Queue.
+type Queue interface {
+	// Enqueue adds delivery to the queue, to be dequeued once at has passed.
+	Enqueue(ctx context.Context, delivery Delivery, at time.Time) error
+	// Dequeue blocks until a delivery is due and removes it from the queue.
+	Dequeue(ctx context.Context) (Delivery, error)
+}
+
+// Dispatcher sends deliveries from a Queue, retrying failures with exponential backoff. Set it as Server.Dispatcher
+// to have Serve run it and to use Server.Deliver.
+type Dispatcher struct {
+	// Queue holds deliveries. Defaults to an in-memory queue, which loses anything still queued on exit.
+	Queue Queue
+	// Send delivers a message, returning the ID that the provider gave it, if there is one. An error means it should be
+	// retried.
+	Send func(ctx context.Context, delivery Delivery) (id string, err error)
+	// MaxAttempts is how many times a delivery is tried before it's dropped. Defaults to 5.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each after it. Defaults to 1 second.
+	Backoff time.Duration
+	// Workers is how many deliveries can be sent at once. Defaults to 4.
+	Workers int
+	// Sent, if set, is called with deliveries once they've been sent and the provider's ID for them, e.g. to keep an
+	// audit trail that bounces and complaints can be matched against.
+	Sent func(delivery Delivery, id string)
+	// Dropped, if set, is called with deliveries that failed MaxAttempts times.
+	Dropped func(delivery Delivery, err error)
+
+	queueOnce sync.Once
+}
+
+func (dispatcher *Dispatcher) queue() Queue {
+	dispatcher.queueOnce.Do(func() {
+		if dispatcher.Queue == nil {
+			dispatcher.Queue = NewMemoryQueue()
+		}
+	})
+	return dispatcher.Queue
+}
+
+// Enqueue queues delivery to be sent straight away.
+func (dispatcher *Dispatcher) Enqueue(ctx context.Context, delivery Delivery) error {
+	if delivery.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		delivery.ID = hex.EncodeToString(id)
+	}
+	return dispatcher.queue().Enqueue(ctx, delivery, time.Now())
+}
+
+// Run sends deliveries until ctx is done.
+func (dispatcher *Dispatcher) Run(ctx context.Context) error {
+	workers := dispatcher.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	queue := dispatcher.queue()
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				delivery, err := queue.Dequeue(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				dispatcher.send(ctx, delivery)
+			}
+		}()
+	}
+	return <-errs
+}
+
+// send tries to send delivery, requeuing it if it fails.
+func (dispatcher *Dispatcher) send(ctx context.Context, delivery Delivery) {
+	id, err := dispatcher.Send(ctx, delivery)
+	if err == nil {
+		if dispatcher.Sent != nil {
+			dispatcher.Sent(delivery, id)
+		}
+		return
+	}
+	delivery.Attempts++
+	maxAttempts := dispatcher.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	if delivery.Attempts >= maxAttempts {
+		if dispatcher.Dropped != nil {
+			dispatcher.Dropped(delivery, err)
+		}
+		return
+	}
+	backoff := dispatcher.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	backoff <<= delivery.Attempts - 1
+	if err := dispatcher.queue().Enqueue(ctx, delivery, time.Now().Add(backoff)); err != nil && dispatcher.Dropped != nil {
+		dispatcher.Dropped(delivery, err)
+	}
+}
+
+// Deliver registers an await and queues delivery of its link, so that the caller isn't held up by a slow mail
+// server. The link is set as delivery.URL, and its expiry as delivery.Expires. If the delivery can't be queued, the await is cancelled.
+func (server *Server) Deliver(req AwaitRequest, delivery Delivery) (*Handle, error) {
+	if server.Dispatcher == nil {
+		return nil, errors.New("gotcha: Deliver needs a Dispatcher")
+	}
+	handle, err := server.Register(req)
+	if err != nil || handle.Duplicate {
+		// A duplicate's link was queued when it was first registered.
+		return handle, err
+	}
+	delivery.URL = server.VerifyURL(req.Identifier)
+	delivery.Expires = handle.entry.expires
+	if err := server.Dispatcher.Enqueue(context.Background(), delivery); err != nil {
+		server.Cancel(req.Identifier)
+		return nil, err
+	}
+	return handle, nil
+}
+
+// memoryQueue is a Queue held in memory.
+type memoryQueue struct {
+	mu    sync.Mutex
+	items queuedDeliveries
+	// added is closed and replaced when a delivery is added, waking up every waiting Dequeue.
+	added chan struct{}
+}
+
+// NewMemoryQueue returns a Queue that's held in memory.
+func NewMemoryQueue() Queue {
+	return &memoryQueue{added: make(chan struct{})}
+}
+
+func (queue *memoryQueue) Enqueue(ctx context.Context, delivery Delivery, at time.Time) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	heap.Push(&queue.items, queuedDelivery{delivery: delivery, at: at})
+	close(queue.added)
+	queue.added = make(chan struct{})
+	return nil
+}
+
+func (queue *memoryQueue) Dequeue(ctx context.Context) (Delivery, error) {
+	for {
+		queue.mu.Lock()
+		added := queue.added
+		wait := time.Hour
+		if len(queue.items) > 0 {
+			if wait = time.Until(queue.items[0].at); wait <= 0 {
+				next := heap.Pop(&queue.items).(queuedDelivery)
+				queue.mu.Unlock()
+				return next.delivery, nil
+			}
+		}
+		queue.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-added:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return Delivery{}, ctx.Err()
+		}
+	}
+}
+
+type queuedDelivery struct {
+	delivery Delivery
+	at       time.Time
+}
+
+// queuedDeliveries is a heap of deliveries ordered by when they're due.
+type queuedDeliveries []queuedDelivery
+
+func (q queuedDeliveries) Len() int            { return len(q) }
+func (q queuedDeliveries) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q queuedDeliveries) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queuedDeliveries) Push(x interface{}) { *q = append(*q, x.(queuedDelivery)) }
+func (q *queuedDeliveries) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
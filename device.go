@@ -0,0 +1,121 @@
+package gotcha
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userCodeAlphabet leaves out vowels and easily confused characters, as recommended by RFC 8628.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// DeviceCode pairs a device code with a short code that a user can type in, for the device authorization flow used
+// by CLIs and TVs.
+type DeviceCode struct {
+	// DeviceCode is the identifier that the device should Await. It should never be shown to the user.
+	DeviceCode string
+	// UserCode is the code that the user enters at /device, formatted as XXXX-XXXX.
+	UserCode string
+}
+
+// NewDeviceCode generates a DeviceCode. Await should be called with its DeviceCode straight away; the user can then
+// verify it by entering UserCode at /device until Timeout elapses.
+func (server *Server) NewDeviceCode() (DeviceCode, error) {
+	deviceCode := make([]byte, 32)
+	if _, err := rand.Read(deviceCode); err != nil {
+		return DeviceCode{}, err
+	}
+	code := DeviceCode{DeviceCode: base64.RawURLEncoding.EncodeToString(deviceCode)}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.devices == nil {
+		server.devices = map[string]string{}
+	}
+	for {
+		userCode, err := newUserCode()
+		if err != nil {
+			return DeviceCode{}, err
+		}
+		userKey := server.HashIdentifier(userCode)
+		if _, taken := server.devices[userKey]; !taken {
+			code.UserCode = userCode[:4] + "-" + userCode[4:]
+			server.devices[userKey] = server.HashIdentifier(code.DeviceCode)
+			time.AfterFunc(server.Timeout, func() {
+				server.mu.Lock()
+				defer server.mu.Unlock()
+				delete(server.devices, userKey)
+			})
+			return code, nil
+		}
+	}
+}
+
+// newUserCode returns 8 random characters from userCodeAlphabet.
+func newUserCode() (string, error) {
+	return randomCode(userCodeAlphabet, 8)
+}
+
+// randomCode returns n random characters from alphabet, which must have fewer than 256 characters.
+func randomCode(alphabet string, n int) (string, error) {
+	code := make([]byte, 0, n)
+	buf := make([]byte, 16)
+	for len(code) < cap(code) {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// Reject bytes that would bias the result towards the start of the alphabet.
+			if int(b) < 256-256%len(alphabet) && len(code) < cap(code) {
+				code = append(code, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// normaliseUserCode strips the separators and case that users are likely to type.
+func normaliseUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ':
+			return -1
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return r
+	}, code)
+}
+
+// deviceForm handles GET /device. The code can be pre-filled with ?user_code=, but the user still has to submit it.
+func (server *Server) deviceForm(c *gin.Context) {
+	theme := server.theme(c)
+	theme.execute(c, http.StatusOK, "device.html", page{
+		Theme:    theme,
+		Heading:  "Connect a device",
+		Text:     "Enter the code shown on your device.",
+		UserCode: c.Query("user_code"),
+		Action:   server.basePath() + "/device",
+		CSRF:     server.csrfToken(c),
+	})
+}
+
+// deviceSubmit handles POST /device, verifying the device code that the entered user code belongs to.
+func (server *Server) deviceSubmit(c *gin.Context) {
+	userKey := server.HashIdentifier(normaliseUserCode(c.PostForm("user_code")))
+	server.mu.Lock()
+	deviceKey, ok := server.devices[userKey]
+	server.mu.Unlock()
+	if !ok && server.forward(c, "", userKey) {
+		return
+	}
+	if !ok {
+		server.renderError(c, http.StatusUnauthorized, "invalid_user_code")
+		return
+	}
+	server.verify(c, deviceKey)
+}
@@ -0,0 +1,170 @@
+package gotcha
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flagsKey is the gin context key that a request's flags are stored under.
+const flagsKey = "gotcha.flags"
+
+// DNSBL checks verifying clients against DNS-based blocklists, such as zen.spamhaus.org, for cheap reputation
+// filtering. Lookups that fail or time out are treated as not listed.
+type DNSBL struct {
+	// Zones are the DNSBL zones to query.
+	Zones []string
+	// Block blocks listed clients, as if they were on BlockList. Otherwise they're only flagged, with a "dnsbl:<zone>"
+	// entry in Result.Flags and PendingAwait.Flags for each zone that lists them, so that a Verifier can decide.
+	Block bool
+	// Reason is shown to blocked clients. Defaults to a generic message.
+	Reason string
+	// Timeout is the longest that lookups can hold a request up for. Defaults to 1 second.
+	Timeout time.Duration
+	// CacheTTL is how long results are cached for. Defaults to 1 hour.
+	CacheTTL time.Duration
+	// Resolver does the lookups. Defaults to net.DefaultResolver.
+	Resolver *net.Resolver
+
+	mu      sync.Mutex
+	cache   map[string]dnsblResult
+	sweepAt int
+}
+
+type dnsblResult struct {
+	zones   []string
+	expires time.Time
+}
+
+// listed returns the zones that list ip.
+func (dnsbl *DNSBL) listed(ctx context.Context, ip string) []string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	dnsbl.mu.Lock()
+	cached, ok := dnsbl.cache[ip]
+	dnsbl.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.zones
+	}
+
+	timeout := dnsbl.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resolver := dnsbl.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	name := reverseName(addr.Unmap())
+	found := make([]bool, len(dnsbl.Zones))
+	var wg sync.WaitGroup
+	for i, zone := range dnsbl.Zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := resolver.LookupHost(ctx, name+"."+strings.TrimSuffix(zone, "."))
+			if err != nil {
+				return
+			}
+			// Listings are answered with addresses in 127.0.0.0/8; anything else is an error from the zone.
+			for _, answer := range addrs {
+				if strings.HasPrefix(answer, "127.") {
+					found[i] = true
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	zones := []string{}
+	for i, zone := range dnsbl.Zones {
+		if found[i] {
+			zones = append(zones, zone)
+		}
+	}
+	if ctx.Err() != nil && len(zones) == 0 {
+		// The lookups timed out, so try again next time rather than caching a result that might be wrong.
+		return nil
+	}
+
+	ttl := dnsbl.CacheTTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	dnsbl.mu.Lock()
+	defer dnsbl.mu.Unlock()
+	if dnsbl.cache == nil {
+		dnsbl.cache = map[string]dnsblResult{}
+	}
+	if len(dnsbl.cache) >= dnsbl.sweepAt {
+		for cachedIP, result := range dnsbl.cache {
+			if now.After(result.expires) {
+				delete(dnsbl.cache, cachedIP)
+			}
+		}
+		dnsbl.sweepAt = 2*len(dnsbl.cache) + 64
+	}
+	dnsbl.cache[ip] = dnsblResult{zones: zones, expires: now.Add(ttl)}
+	return zones
+}
+
+// reverseName returns the DNSBL query name for addr, without a zone: its octets reversed for IPv4, or its nibbles
+// reversed for IPv6.
+func reverseName(addr netip.Addr) string {
+	var parts []string
+	if addr.Is4() {
+		for _, b := range addr.As4() {
+			parts = append([]string{strconv.Itoa(int(b))}, parts...)
+		}
+	} else {
+		for _, b := range addr.As16() {
+			parts = append([]string{strconv.FormatUint(uint64(b&0xf), 16), strconv.FormatUint(uint64(b>>4), 16)}, parts...)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// checkDNSBL looks the client up in DNSBL, flagging the request with the zones that list it. It returns a reason if
+// the client should be blocked.
+func (server *Server) checkDNSBL(c *gin.Context) (string, bool) {
+	if server.DNSBL == nil {
+		return "", false
+	}
+	zones := server.DNSBL.listed(c.Request.Context(), server.clientIP(c))
+	if len(zones) == 0 {
+		return "", false
+	}
+	if server.DNSBL.Block {
+		reason := server.DNSBL.Reason
+		if reason == "" {
+			reason = blocklistReason
+		}
+		return reason, true
+	}
+	for _, zone := range zones {
+		addFlag(c, "dnsbl:"+zone)
+	}
+	return "", false
+}
+
+// addFlag flags the request, e.g. as suspicious. Flags end up in PendingAwait and the Result.
+func addFlag(c *gin.Context, flag string) {
+	c.Set(flagsKey, append(flagsOf(c), flag))
+}
+
+// flagsOf returns the flags added to the request.
+func flagsOf(c *gin.Context) []string {
+	flags, _ := c.Get(flagsKey)
+	list, _ := flags.([]string)
+	return list
+}
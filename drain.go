@@ -0,0 +1,55 @@
+package gotcha
+
+import "errors"
+
+// ErrDraining is returned by Register while the server is draining. See Drain.
+var ErrDraining = errors.New("gotcha: draining")
+
+// Drain stops new awaits from being registered, so that the instance can be taken out of a fleet without cutting off
+// verifications that are under way: pending awaits are still served and resolved until they're verified or expire.
+// Registering fails with ErrDraining, though retries with the IdempotencyKey of an await that's already registered
+// still get it back. The returned channel is closed once no awaits are pending, unless Undrain is called first; calling
+// Drain again returns the same one.
+func (server *Server) Drain() <-chan struct{} {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.drained == nil {
+		server.drained = make(chan struct{})
+		server.logger().Info("gotcha: draining", "pending", server.pendingCount)
+		server.checkDrained()
+	}
+	return server.drained
+}
+
+// Undrain lets awaits be registered again, returning false if the server wasn't draining.
+func (server *Server) Undrain() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.drained == nil {
+		return false
+	}
+	server.drained = nil
+	server.logger().Info("gotcha: stopped draining")
+	return true
+}
+
+// Draining reports whether Drain has been called, and Undrain hasn't since.
+func (server *Server) Draining() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.drained != nil
+}
+
+// checkDrained closes the Drain channel if the server is draining and nothing's pending. The server's lock must be
+// held, and resolveMu too if it's only held shared.
+func (server *Server) checkDrained() {
+	if server.drained == nil || server.pendingCount > 0 {
+		return
+	}
+	select {
+	case <-server.drained:
+	default:
+		close(server.drained)
+		server.logger().Info("gotcha: drained")
+	}
+}
@@ -0,0 +1,62 @@
+package gotcha
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunKey is the gin.Context key that marks a request as a dry run.
+const dryRunKey = "gotcha.dry_run"
+
+// DryRun lets load tests exercise verification in production without consuming real awaits: requests for
+// identifiers that start with Prefix, and aren't registered, are verified against a synthetic await that's made up for
+// each request. They go through routing, rate limiting, blocklists and the Renderer like any other, and are answered
+// as if they were verified, with "dry_run" in the body, but nothing is resolved, and Verifier, Precondition, Session
+// and the like aren't called. Only GET /verify/:identifier and its equivalents, including Tenants' paths, are dry
+// runs; with Confirm set, GET shows the confirmation page as usual.
+type DryRun struct {
+	// Prefix marks identifiers as synthetic. Defaults to "dryrun-".
+	Prefix string
+	// Token, if set, must be sent as the X-Gotcha-Dry-Run header for requests to be dry runs, so that no one else can
+	// make them.
+	Token string
+	// Namespace is the namespace of the synthetic awaits, unless the request is for a Tenant's host or path.
+	Namespace string
+}
+
+// markDryRun marks the request as a dry run if identifier is synthetic.
+func (server *Server) markDryRun(c *gin.Context, identifier string) {
+	dryRun := server.DryRun
+	if dryRun == nil {
+		return
+	}
+	prefix := dryRun.Prefix
+	if prefix == "" {
+		prefix = "dryrun-"
+	}
+	if !strings.HasPrefix(identifier, prefix) {
+		return
+	}
+	if dryRun.Token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Gotcha-Dry-Run")), []byte(dryRun.Token)) != 1 {
+		return
+	}
+	c.Set(dryRunKey, true)
+}
+
+// dryRunEntry returns a synthetic await for a dry run, which is never stored.
+func (server *Server) dryRunEntry(c *gin.Context) *awaited {
+	namespace, ok := namespaceOf(c)
+	if !ok {
+		namespace = server.DryRun.Namespace
+	}
+	entry := server.newEntry(AwaitRequest{Namespace: namespace})
+	entry.expires = entry.start.Add(server.Timeout)
+	return entry
+}
+
+// isDryRun reports whether the request was marked as a dry run.
+func isDryRun(c *gin.Context) bool {
+	return c.GetBool(dryRunKey)
+}
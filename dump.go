@@ -0,0 +1,226 @@
+package gotcha
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDumpBody is how much of a response body a Dump keeps.
+const maxDumpBody = 16 << 10
+
+// redacted replaces secrets in dumps.
+const redacted = "[redacted]"
+
+// Dump is a record of a verification request and its response, for diagnosing links that don't work, with
+// credentials, cookies, codes and tokens redacted. See Server.DebugDumps.
+type Dump struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	// Route is the route that the request matched, such as "/verify/:identifier", which leaves the identifier out.
+	Route  string      `json:"route"`
+	Query  url.Values  `json:"query,omitempty"`
+	Header http.Header `json:"header"`
+	// Status, ResponseHeader and ResponseBody are the response. Only JSON bodies are kept, leaving out their tokens.
+	Status         int           `json:"status"`
+	ResponseHeader http.Header   `json:"response_header"`
+	ResponseBody   string        `json:"response_body,omitempty"`
+	Duration       time.Duration `json:"duration"`
+}
+
+// dumpWatch is an identifier whose requests are being dumped.
+type dumpWatch struct {
+	limit int
+	until time.Time
+	dumps []Dump
+}
+
+// DumpRequests starts keeping Dumps of up to limit requests for identifier, which defaults to 10, for the next ttl,
+// which defaults to an hour. It fails unless DebugDumps is set.
+func (server *Server) DumpRequests(identifier string, limit int, ttl time.Duration) error {
+	if !server.DebugDumps {
+		return errors.New("gotcha: DumpRequests needs DebugDumps")
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	server.dumpMu.Lock()
+	defer server.dumpMu.Unlock()
+	if server.dumpWatches == nil {
+		server.dumpWatches = map[string]*dumpWatch{}
+	}
+	now := time.Now()
+	for key, watch := range server.dumpWatches {
+		if now.After(watch.until) {
+			delete(server.dumpWatches, key)
+		}
+	}
+	server.dumpWatches[server.HashIdentifier(identifier)] = &dumpWatch{limit: limit, until: now.Add(ttl)}
+	return nil
+}
+
+// RequestDumps returns the Dumps kept for identifier, oldest first.
+func (server *Server) RequestDumps(identifier string) []Dump {
+	server.dumpMu.Lock()
+	defer server.dumpMu.Unlock()
+	dumps := []Dump{}
+	if watch, ok := server.dumpWatches[server.HashIdentifier(identifier)]; ok {
+		dumps = append(dumps, watch.dumps...)
+	}
+	return dumps
+}
+
+// StopDumps stops dumping requests for identifier and deletes its Dumps, returning false if they weren't being
+// dumped.
+func (server *Server) StopDumps(identifier string) bool {
+	server.dumpMu.Lock()
+	defer server.dumpMu.Unlock()
+	key := server.HashIdentifier(identifier)
+	_, ok := server.dumpWatches[key]
+	delete(server.dumpWatches, key)
+	return ok
+}
+
+// dumpRequest is middleware that keeps a Dump of requests for identifiers passed to DumpRequests.
+func (server *Server) dumpRequest(c *gin.Context) {
+	if !server.DebugDumps {
+		return
+	}
+	identifier := c.Param("identifier")
+	if identifier == "" {
+		identifier = c.Query("token")
+	}
+	if identifier == "" {
+		return
+	}
+	key := server.HashIdentifier(identifier)
+	server.dumpMu.Lock()
+	watch, ok := server.dumpWatches[key]
+	watching := ok && len(watch.dumps) < watch.limit && time.Now().Before(watch.until)
+	server.dumpMu.Unlock()
+	if !watching {
+		return
+	}
+
+	start := time.Now()
+	dump := Dump{
+		Time:     start,
+		ClientIP: server.clientIP(c),
+		Method:   c.Request.Method,
+		Route:    c.FullPath(),
+		Query:    sanitizeValues(c.Request.URL.Query()),
+		Header:   sanitizeHeader(c.Request.Header, "Authorization", "Proxy-Authorization", "Cookie"),
+	}
+	writer := &dumpWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+	dump.RequestID = requestIDOf(c)
+	dump.Duration = time.Since(start)
+	dump.Status = writer.Status()
+	dump.ResponseHeader = sanitizeHeader(writer.Header(), "Set-Cookie")
+	if strings.HasPrefix(writer.Header().Get("Content-Type"), gin.MIMEJSON) {
+		dump.ResponseBody = sanitizeJSON(writer.body.Bytes())
+	}
+
+	server.dumpMu.Lock()
+	defer server.dumpMu.Unlock()
+	if watch, ok := server.dumpWatches[key]; ok && len(watch.dumps) < watch.limit {
+		watch.dumps = append(watch.dumps, dump)
+	}
+}
+
+// dumpWriter keeps the start of the response body for a Dump.
+type dumpWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (writer *dumpWriter) Write(data []byte) (int, error) {
+	writer.keep(data)
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *dumpWriter) WriteString(s string) (int, error) {
+	writer.keep([]byte(s))
+	return writer.ResponseWriter.WriteString(s)
+}
+
+func (writer *dumpWriter) keep(data []byte) {
+	if room := maxDumpBody - writer.body.Len(); room > 0 {
+		writer.body.Write(data[:min(len(data), room)])
+	}
+}
+
+// sanitizeHeader returns a copy of header with the values of names redacted.
+func sanitizeHeader(header http.Header, names ...string) http.Header {
+	sanitized := header.Clone()
+	for _, name := range names {
+		if _, ok := sanitized[name]; ok {
+			sanitized[name] = []string{redacted}
+		}
+	}
+	return sanitized
+}
+
+// sanitizeValues returns values with the identifier, codes and signatures redacted.
+func sanitizeValues(values url.Values) url.Values {
+	for _, name := range []string{"token", "code", "sig", "nonce", "csrf_token"} {
+		if _, ok := values[name]; ok {
+			values[name] = []string{redacted}
+		}
+	}
+	return values
+}
+
+// sanitizeJSON returns body, a JSON response, with the tokens and codes that it hands out redacted.
+func sanitizeJSON(body []byte) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	for _, name := range []string{"token", "exchange_code", "nonce"} {
+		if _, ok := fields[name]; ok {
+			fields[name] = redacted
+		}
+	}
+	sanitized, _ := json.Marshal(fields)
+	return string(sanitized)
+}
+
+// startDumps handles POST /admin/dumps, with {"identifier": "...", "limit": 10, "ttl": "1h"}, which starts dumping
+// requests for the identifier.
+func (server *Server) startDumps(c *gin.Context) {
+	var body struct {
+		Identifier string `json:"identifier" binding:"required"`
+		Limit      int    `json:"limit"`
+		TTL        string `json:"ttl"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	var ttl time.Duration
+	if body.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(body.TTL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+	if err := server.DumpRequests(body.Identifier, body.Limit, ttl); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
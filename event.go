@@ -0,0 +1,266 @@
+package gotcha
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Event types.
+const (
+	EventRegistered    = "registered"
+	EventOpened        = "opened"
+	EventVerified      = "verified"
+	EventExpired       = "expired"
+	EventBlocked       = "blocked"
+	EventCancelled     = "cancelled"
+	EventDenied        = "denied"
+	EventUndeliverable = "undeliverable"
+	EventLocked        = "locked"
+	EventEvicted       = "evicted"
+	EventReplayed      = "replayed"
+	EventPrefetched    = "prefetched"
+)
+
+// maxEventBackoff is the longest that dispatch waits before retrying a sink.
+const maxEventBackoff = time.Minute
+
+// Event describes something that happened to an await.
+type Event struct {
+	// Type is one of the Event types.
+	Type string `json:"type"`
+	// Identifier is the identifier of the await.
+	Identifier string `json:"identifier"`
+	// Time is when it happened.
+	Time time.Time `json:"time"`
+	// ClientIP is the IP address of the client that resolved the await, if there was one.
+	ClientIP string `json:"client_ip,omitempty"`
+	// Namespace is the namespace of the await.
+	Namespace string `json:"namespace,omitempty"`
+	// Headers are the captured request headers of the client that resolved the await. See Server.CaptureHeaders.
+	Headers map[string]string `json:"headers,omitempty"`
+	// RequestID is the ID of the request that resolved the await, if there was one. See Server.RequestIDHeader.
+	RequestID string `json:"request_id,omitempty"`
+	// TraceParent is the W3C traceparent header of the request that resolved the await, if it had one, so that
+	// consumers can join its trace.
+	TraceParent string `json:"traceparent,omitempty"`
+	// Flags are what was noticed about the client, such as the checks that made Server.Prefetch treat the request as
+	// a prefetch.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// MarshalProto encodes the event as the Event message in event.proto.
+func (event Event) MarshalProto() []byte {
+	var buf []byte
+	buf = appendProtoString(buf, 1, event.Type)
+	buf = appendProtoString(buf, 2, event.Identifier)
+	if !event.Time.IsZero() {
+		buf = appendProtoVarint(buf, 3, uint64(event.Time.UnixNano()))
+	}
+	buf = appendProtoString(buf, 4, event.ClientIP)
+	buf = appendProtoString(buf, 5, event.Namespace)
+	names := make([]string, 0, len(event.Headers))
+	for name := range event.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buf = appendProtoMessage(buf, 6, appendProtoString(appendProtoString(nil, 1, name), 2, event.Headers[name]))
+	}
+	buf = appendProtoString(buf, 7, event.RequestID)
+	buf = appendProtoString(buf, 8, event.TraceParent)
+	for _, flag := range event.Flags {
+		buf = appendProtoString(buf, 9, flag)
+	}
+	return buf
+}
+
+func appendProtoVarint(buf []byte, field int, value uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3)
+	return binary.AppendUvarint(buf, value)
+}
+
+func appendProtoString(buf []byte, field int, value string) []byte {
+	if value == "" {
+		return buf
+	}
+	return appendProtoMessage(buf, field, []byte(value))
+}
+
+func appendProtoMessage(buf []byte, field int, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// EventSink receives events, such as to publish them to a message broker.
+type EventSink interface {
+	// Send delivers event. It's called from a single goroutine, in the order that events happened. If it fails, it's
+	// called with the same event again until it succeeds. Delivery is at least once: an event that was sent just
+	// before a snapshot was saved is sent again after it's restored.
+	Send(ctx context.Context, event Event) error
+}
+
+// queuedEvent is an event in the outbox, numbered so that dispatch can tell it apart from those put in front of it by
+// restore.
+type queuedEvent struct {
+	seq   uint64
+	event Event
+}
+
+// emit queues event in the outbox for delivery to EventSinks. Events about an await changing are emitted as it
+// changes, with the server's lock held, and a Snapshot takes the lock to save both, so it never has one without the
+// other, however long the sinks take.
+func (server *Server) emit(event Event) {
+	server.broadcast(event)
+	if len(server.EventSinks) == 0 {
+		return
+	}
+	server.outboxMu.Lock()
+	server.outboxSeq++
+	server.outbox = append(server.outbox, queuedEvent{seq: server.outboxSeq, event: event})
+	server.outboxMu.Unlock()
+	server.wakeDispatch()
+}
+
+// wakeDispatch has dispatch look at the outbox, starting it if it hasn't been.
+func (server *Server) wakeDispatch() {
+	server.eventsOnce.Do(func() {
+		server.outboxReady = make(chan struct{}, 1)
+		go server.dispatch()
+	})
+	select {
+	case server.outboxReady <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch sends the events in the outbox to each of EventSinks, in order. An event stays in the outbox until every
+// sink has taken it: those that fail are retried with backoff, and later events wait, while those that took it aren't
+// sent it again.
+func (server *Server) dispatch() {
+	sent := make([]bool, len(server.EventSinks))
+	var sending uint64
+	backoff := server.eventBackoff()
+	for range server.outboxReady {
+		for {
+			server.outboxMu.Lock()
+			if len(server.outbox) == 0 {
+				server.outboxMu.Unlock()
+				break
+			}
+			queued := server.outbox[0]
+			server.outboxMu.Unlock()
+			if queued.seq != sending {
+				sending = queued.seq
+				clear(sent)
+			}
+
+			failed := false
+			for i, sink := range server.EventSinks {
+				if sent[i] {
+					continue
+				}
+				if err := sink.Send(context.Background(), queued.event); err != nil {
+					failed = true
+					server.metrics.observeEventSinkFailure()
+					server.logger().Warn("gotcha: event sink failed", "type", queued.event.Type,
+						"identifier", queued.event.Identifier, "retry", backoff, "error", err)
+					server.handleError(nil, fmt.Errorf("gotcha: sending %s event: %w", queued.event.Type, err))
+					continue
+				}
+				sent[i] = true
+			}
+			if failed {
+				time.Sleep(backoff)
+				backoff = min(2*backoff, maxEventBackoff)
+				continue
+			}
+			backoff = server.eventBackoff()
+			server.outboxMu.Lock()
+			for i, other := range server.outbox {
+				if other.seq != queued.seq {
+					continue
+				}
+				if i == 0 {
+					server.outbox = server.outbox[1:]
+				} else {
+					server.outbox = append(server.outbox[:i:i], server.outbox[i+1:]...)
+				}
+				break
+			}
+			server.outboxMu.Unlock()
+		}
+	}
+}
+
+// eventBackoff returns EventBackoff, or its default.
+func (server *Server) eventBackoff() time.Duration {
+	if server.EventBackoff <= 0 {
+		return time.Second
+	}
+	return server.EventBackoff
+}
+
+// outboxEvents returns the events in the outbox, for a Snapshot.
+func (server *Server) outboxEvents() []Event {
+	server.outboxMu.Lock()
+	defer server.outboxMu.Unlock()
+	events := make([]Event, 0, len(server.outbox))
+	for _, queued := range server.outbox {
+		events = append(events, queued.event)
+	}
+	return events
+}
+
+// restoreOutbox puts events, saved by a Snapshot, back in the outbox ahead of any that have been emitted since. Events
+// that are already there, since both Snapshot and Upgrade hand them over, aren't added again.
+func (server *Server) restoreOutbox(events []Event) {
+	if len(events) == 0 || len(server.EventSinks) == 0 {
+		return
+	}
+	server.outboxMu.Lock()
+	type id struct {
+		kind, identifier string
+		time             int64
+	}
+	queued := map[id]bool{}
+	for _, other := range server.outbox {
+		queued[id{other.event.Type, other.event.Identifier, other.event.Time.UnixNano()}] = true
+	}
+	var restored []queuedEvent
+	for _, event := range events {
+		if queued[id{event.Type, event.Identifier, event.Time.UnixNano()}] {
+			continue
+		}
+		server.outboxSeq++
+		restored = append(restored, queuedEvent{seq: server.outboxSeq, event: event})
+	}
+	server.outbox = append(restored, server.outbox...)
+	server.outboxMu.Unlock()
+	server.wakeDispatch()
+}
+
+// eventType returns the type of event for an await resolving with status.
+func eventType(status int) string {
+	switch status {
+	case StatusVerified:
+		return EventVerified
+	case StatusExpired:
+		return EventExpired
+	case StatusCancelled:
+		return EventCancelled
+	case StatusDenied:
+		return EventDenied
+	case StatusUndeliverable:
+		return EventUndeliverable
+	case StatusLocked:
+		return EventLocked
+	case StatusEvicted:
+		return EventEvicted
+	default:
+		return EventBlocked
+	}
+}
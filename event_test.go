@@ -0,0 +1,131 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink records the identifiers of the events that it's sent. It fails while failing is set, and Send blocks
+// until hold is closed if it's set.
+type recordingSink struct {
+	mu       sync.Mutex
+	sent     []string
+	failing  bool
+	failures int
+	hold     chan struct{}
+}
+
+func (sink *recordingSink) Send(ctx context.Context, event Event) error {
+	if sink.hold != nil {
+		<-sink.hold
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.failing {
+		sink.failures++
+		return errors.New("unavailable")
+	}
+	sink.sent = append(sink.sent, event.Identifier)
+	return nil
+}
+
+func (sink *recordingSink) setFailing(failing bool) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.failing = failing
+}
+
+// waitFor waits for sink to have been sent n events, returning them.
+func (sink *recordingSink) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sink.mu.Lock()
+		sent := append([]string(nil), sink.sent...)
+		sink.mu.Unlock()
+		if len(sent) >= n || time.Now().After(deadline) {
+			if len(sent) != n {
+				t.Fatalf("sink was sent %d events, want %d", len(sent), n)
+			}
+			return sent
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func emitEvents(server *Server, from, to int) {
+	for i := from; i < to; i++ {
+		server.emit(Event{Type: EventVerified, Identifier: fmt.Sprint(i), Time: time.Unix(0, int64(i+1))})
+	}
+}
+
+func checkOrder(t *testing.T, sent []string, from int) {
+	t.Helper()
+	for i, identifier := range sent {
+		if identifier != fmt.Sprint(from+i) {
+			t.Fatalf("event %d is %s, want %d", i, identifier, from+i)
+		}
+	}
+}
+
+func TestEventSinkRetried(t *testing.T) {
+	good, flaky := &recordingSink{}, &recordingSink{failing: true}
+	server := &Server{EventSinks: []EventSink{good, flaky}, EventBackoff: time.Millisecond}
+	emitEvents(server, 0, 10)
+	for {
+		flaky.mu.Lock()
+		failures := flaky.failures
+		flaky.mu.Unlock()
+		if failures >= 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	flaky.setFailing(false)
+	checkOrder(t, flaky.waitFor(t, 10), 0)
+	// The sink that didn't fail is sent each event once, even though they were retried for the other one.
+	checkOrder(t, good.waitFor(t, 10), 0)
+}
+
+func TestEventOutboxFull(t *testing.T) {
+	// More events than the old delivery queue held pile up behind a sink that's stuck; none are dropped.
+	sink := &recordingSink{hold: make(chan struct{})}
+	server := &Server{EventSinks: []EventSink{sink}}
+	emitEvents(server, 0, 5000)
+	close(sink.hold)
+	checkOrder(t, sink.waitFor(t, 5000), 0)
+}
+
+func TestEventOutboxSnapshot(t *testing.T) {
+	down := &recordingSink{failing: true}
+	server := &Server{Timeout: time.Minute, EventSinks: []EventSink{down}, EventBackoff: time.Hour}
+	emitEvents(server, 0, 5)
+	data, _, err := server.export(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The events are delivered by the server that restores the snapshot, ahead of any that it emits itself, and one
+	// that's in both the snapshot and the outbox already, as when Snapshot and Upgrade both hand it over, only once.
+	up := &recordingSink{hold: make(chan struct{})}
+	restored := &Server{Timeout: time.Minute, EventSinks: []EventSink{up}}
+	emitEvents(restored, 4, 7)
+	if _, err := restored.restore(data); err != nil {
+		t.Fatal(err)
+	}
+	close(up.hold)
+	sent := up.waitFor(t, 7)
+	// The first event that it emitted may already have been on its way to the sink when the snapshot was restored.
+	want := []string{"0", "1", "2", "3", "4", "5", "6"}
+	if sent[0] == "4" {
+		want = []string{"4", "0", "1", "2", "3", "5", "6"}
+	}
+	if !slices.Equal(sent, want) {
+		t.Errorf("sink was sent %v, want %v", sent, want)
+	}
+}
@@ -0,0 +1,89 @@
+package gotcha
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// exchange is the result of a verification, waiting to be collected with its exchange code.
+type exchange struct {
+	key       string
+	namespace string
+	metadata  map[string]string
+	result    Result
+}
+
+// newExchangeCode stores the result of verifying entry and returns the single-use code that it can be collected
+// with. The server's lock must be held, shared or exclusively, and entry must have resolved.
+func (server *Server) newExchangeCode(key string, entry *awaited) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(raw)
+	result := entry.result()
+	server.resolveMu.Lock()
+	if server.exchanges == nil {
+		server.exchanges = map[string]exchange{}
+	}
+	server.exchanges[code] = exchange{
+		key:       key,
+		namespace: entry.namespace,
+		metadata:  entry.metadata,
+		result:    result,
+	}
+	server.resolveMu.Unlock()
+	time.AfterFunc(server.Timeout, func() {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		delete(server.exchanges, code)
+	})
+	return code, nil
+}
+
+// exchangeCode handles POST /exchange. The body is {"code": "..."}, and the response describes the verification that
+// the code was issued for. Each code can only be exchanged once.
+func (server *Server) exchangeCode(c *gin.Context) {
+	if !server.Exchange.authorised(c.Request) {
+		c.Header("WWW-Authenticate", server.Exchange.challenge())
+		server.respond(c, http.StatusUnauthorized, gin.H{"error": "unauthorized", "message": http.StatusText(http.StatusUnauthorized)})
+		return
+	}
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+		server.respond(c, http.StatusBadRequest, gin.H{"error": "invalid_request", "message": err.Error()})
+		return
+	}
+
+	server.mu.Lock()
+	found, ok := server.exchanges[req.Code]
+	delete(server.exchanges, req.Code)
+	server.mu.Unlock()
+	if !ok {
+		server.respond(c, http.StatusNotFound, gin.H{"error": "invalid_code", "message": http.StatusText(http.StatusNotFound)})
+		return
+	}
+	server.respond(c, http.StatusOK, gin.H{
+		"identifier":    found.key,
+		"namespace":     found.namespace,
+		"metadata":      found.metadata,
+		"state":         stateOf(found.result.Status).String(),
+		"resolved":      found.result.Resolved,
+		"client_ip":     found.result.ClientIP,
+		"headers":       found.result.Headers,
+		"request_id":    found.result.RequestID,
+		"flags":         found.result.Flags,
+		"fingerprint":   found.result.Fingerprint,
+		"failures":      found.result.Failures,
+		"failure_codes": found.result.FailureCodes,
+		"alias":         found.result.Alias,
+		"attribution":   found.result.Attribution,
+	})
+}
@@ -0,0 +1,309 @@
+package gotcha
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// exportVersion is the version of the format that Export writes.
+const exportVersion = 1
+
+// export is the format that Export writes: a JSON object with the version of the format and the pending awaits.
+// Snapshots add the runtime blocklist, the statistics (the Analytics rollups, the Accounting totals and the dashboard's
+// outcomes) and the events that EventSinks haven't taken yet. Fields are only ever added to it, so that older exports can still be imported.
+type export struct {
+	Version    int                         `json:"version"`
+	Exported   time.Time                   `json:"exported"`
+	Awaits     []exportedAwait             `json:"awaits"`
+	Blocks     []exportedBlock             `json:"blocks,omitempty"`
+	Analytics  []Rollup                    `json:"analytics,omitempty"`
+	Accounting map[string]AccountingCounts `json:"accounting,omitempty"`
+	Outcomes   *exportedOutcomes           `json:"outcomes,omitempty"`
+	Events     []Event                     `json:"events,omitempty"`
+}
+
+// exportedOutcomes are the counts behind the dashboard's outcomes and time to verify.
+type exportedOutcomes struct {
+	// Resolved counts resolved awaits by Status.
+	Resolved map[int]uint64 `json:"resolved"`
+	// TimeToVerify counts verified awaits by how long they took, indexed like verifyBuckets with a final +Inf bucket,
+	// and TimeToVerifySum is their total.
+	TimeToVerify    []uint64      `json:"time_to_verify"`
+	TimeToVerifySum time.Duration `json:"time_to_verify_sum"`
+}
+
+type exportedAwait struct {
+	// Identifier is the key of the await, as returned by HashIdentifier.
+	Identifier   string            `json:"identifier"`
+	Registered   time.Time         `json:"registered"`
+	Activates    *time.Time        `json:"activates,omitempty"`
+	Namespace    string            `json:"namespace,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Code         string            `json:"code,omitempty"`
+	Methods      []string          `json:"methods,omitempty"`
+	AllowList    []string          `json:"allow_list,omitempty"`
+	BlockList    map[string]string `json:"block_list,omitempty"`
+	Failures     int               `json:"failures,omitempty"`
+	FailureCodes map[string]int    `json:"failure_codes,omitempty"`
+	MaxAttempts  int               `json:"max_attempts,omitempty"`
+	Requested    bool              `json:"requested,omitempty"`
+	Opened       bool              `json:"opened,omitempty"`
+	Steps        []exportedStep    `json:"steps,omitempty"`
+	Aliases      []exportedAlias   `json:"aliases,omitempty"`
+	Windows      []exportedWindow  `json:"windows,omitempty"`
+	Priority     Priority          `json:"priority,omitempty"`
+	AppLink      string            `json:"app_link,omitempty"`
+	WebFallback  string            `json:"web_fallback,omitempty"`
+	Template     string            `json:"template,omitempty"`
+	Headers      http.Header       `json:"response_headers,omitempty"`
+}
+
+type exportedWindow struct {
+	Days     []time.Weekday `json:"days,omitempty"`
+	Start    time.Duration  `json:"start"`
+	End      time.Duration  `json:"end"`
+	Location string         `json:"location,omitempty"`
+}
+
+type exportedStep struct {
+	Name       string `json:"name"`
+	Identifier string `json:"identifier"`
+	Code       string `json:"code,omitempty"`
+	Done       bool   `json:"done,omitempty"`
+}
+
+type exportedAlias struct {
+	Name       string `json:"name"`
+	Identifier string `json:"identifier"`
+}
+
+type exportedBlock struct {
+	IP     string     `json:"ip"`
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// Export writes the pending awaits to w, so that they can be moved to another instance or store with Import, such as
+// to drain an instance during an upgrade. The awaits stay pending here; cancel them once the import has succeeded.
+// The export is JSON, sealed with Keyring if it's set, since it contains metadata and codes. Identifiers are written
+// as returned by HashIdentifier, so the importing server must have the same HashKey. It returns how many awaits were
+// exported.
+func (server *Server) Export(w io.Writer) (int, error) {
+	data, count, err := server.export(false)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// export encodes the pending awaits, and for a snapshot the runtime blocklist and statistics too, returning how many
+// awaits there were.
+func (server *Server) export(snapshot bool) ([]byte, int, error) {
+	exported := export{Version: exportVersion, Exported: time.Now(), Awaits: []exportedAwait{}}
+	server.mu.Lock()
+	server.awaits.each(func(key string, entry *awaited) bool {
+		if server.state(entry) != StatePending {
+			return true
+		}
+		await := exportedAwait{
+			Identifier:   key,
+			Registered:   entry.start,
+			Activates:    optionalTime(entry.activates),
+			Namespace:    entry.namespace,
+			Metadata:     entry.metadata,
+			Code:         entry.code,
+			Methods:      entry.methods,
+			AllowList:    entry.allowList,
+			BlockList:    entry.blockList,
+			Failures:     entry.failures,
+			FailureCodes: entry.failureCodes,
+			MaxAttempts:  entry.maxAttempts,
+			Requested:    entry.requested,
+			Opened:       entry.isOpened(),
+			Priority:     entry.priority,
+			AppLink:      entry.appLink,
+			WebFallback:  entry.webFallback,
+			Template:     entry.template,
+			Headers:      entry.responseHeaders,
+		}
+		for _, step := range entry.steps {
+			await.Steps = append(await.Steps, exportedStep{Name: step.name, Identifier: step.key, Code: step.code,
+				Done: step.done})
+		}
+		for _, alias := range entry.aliases {
+			await.Aliases = append(await.Aliases, exportedAlias{Name: alias.name, Identifier: alias.key})
+		}
+		for _, window := range entry.windows {
+			await.Windows = append(await.Windows, exportedWindow{Days: window.Days, Start: window.Start,
+				End: window.End, Location: window.location().String()})
+		}
+		exported.Awaits = append(exported.Awaits, await)
+		return true
+	})
+	if snapshot {
+		if server.Analytics != nil {
+			exported.Analytics = server.Analytics.Rollups("")
+		}
+		if server.Accounting != nil {
+			exported.Accounting = server.Accounting.Totals()
+		}
+		exported.Outcomes = server.metrics.outcomes()
+		exported.Events = server.outboxEvents()
+		now := time.Now()
+		server.blockMu.Lock()
+		for ip, entry := range server.blocked {
+			if entry.until.IsZero() || now.Before(entry.until) {
+				exported.Blocks = append(exported.Blocks, exportedBlock{IP: ip, Reason: entry.reason,
+					Until: optionalTime(entry.until)})
+			}
+		}
+		server.blockMu.Unlock()
+	}
+	// Marshal before unlocking, since the maps are shared with the awaits.
+	data, err := json.Marshal(exported)
+	server.mu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	if server.Keyring != nil {
+		if data, err = server.Keyring.Seal(data); err != nil {
+			return nil, 0, err
+		}
+	}
+	return data, len(exported.Awaits), nil
+}
+
+// Import registers the awaits written by Export, or saved by Snapshot along with the runtime blocklist and statistics,
+// which replace the server's own, and the events that its sinks hadn't taken, which are queued again. Awaits that have expired since, or whose identifiers are already pending here, are
+// skipped. Timeout counts from when each await
+// was registered or activated (see AwaitRequest.ActivateAt) on the original server, but is this server's. Nothing
+// waits on imported awaits here, so their results are only seen through Peek, /wait and events. It returns how many
+// awaits were imported.
+func (server *Server) Import(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	return server.restore(data)
+}
+
+// restore imports data, written by export, restoring its runtime blocklist and statistics too. The statistics replace
+// the server's own rather than adding to them, since Serve restores both Snapshot and the state handed over by
+// Upgrade, which each include everything counted before.
+func (server *Server) restore(data []byte) (int, error) {
+	var err error
+	if server.Keyring != nil {
+		if data, err = server.Keyring.Open(data); err != nil {
+			return 0, err
+		}
+	}
+	var imported export
+	if err := json.Unmarshal(data, &imported); err != nil {
+		return 0, err
+	}
+	if imported.Version != exportVersion {
+		return 0, errors.New("gotcha: unsupported export version")
+	}
+
+	for _, block := range imported.Blocks {
+		until := timeOf(block.Until)
+		if ttl := time.Until(until); until.IsZero() || ttl > 0 {
+			server.Block(block.IP, block.Reason, ttl)
+		}
+	}
+	if server.Analytics != nil {
+		server.Analytics.restore(imported.Analytics)
+	}
+	if server.Accounting != nil {
+		server.Accounting.restoreTotals(imported.Accounting)
+	}
+	if imported.Outcomes != nil {
+		server.metrics.restoreOutcomes(imported.Outcomes)
+	}
+	server.restoreOutbox(imported.Events)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	count := 0
+	for _, await := range imported.Awaits {
+		entry := &awaited{
+			start:           await.Registered,
+			activates:       timeOf(await.Activates),
+			done:            make(chan struct{}),
+			opened:          make(chan struct{}),
+			code:            await.Code,
+			methods:         await.Methods,
+			failures:        await.Failures,
+			failureCodes:    await.FailureCodes,
+			maxAttempts:     await.MaxAttempts,
+			requested:       await.Requested,
+			namespace:       await.Namespace,
+			metadata:        await.Metadata,
+			allowList:       await.AllowList,
+			blockList:       await.BlockList,
+			priority:        await.Priority,
+			appLink:         await.AppLink,
+			webFallback:     await.WebFallback,
+			template:        await.Template,
+			responseHeaders: await.Headers,
+		}
+		if await.Opened {
+			close(entry.opened)
+		}
+		for _, s := range await.Steps {
+			entry.steps = append(entry.steps, &step{name: s.Name, key: s.Identifier, code: s.Code, done: s.Done})
+		}
+		for _, a := range await.Aliases {
+			entry.aliases = append(entry.aliases, alias{name: a.Name, key: a.Identifier})
+		}
+		for _, w := range await.Windows {
+			location, err := time.LoadLocation(w.Location)
+			if err != nil {
+				return count, err
+			}
+			entry.windows = append(entry.windows, Window{Days: w.Days, Start: w.Start, End: w.End, Location: location})
+		}
+		if !time.Now().Before(entry.validFrom().Add(server.Timeout)) || server.claimed(await.Identifier, entry) {
+			continue
+		}
+		server.add(await.Identifier, entry)
+		count++
+	}
+	return count, nil
+}
+
+// optionalTime returns t, or nil if it's zero, for fields that are left out of exports when they're unset.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// timeOf returns what t points to, or the zero time if it's nil.
+func timeOf(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// claimed reports whether key or one of entry's steps or aliases belongs to a pending await. The server's lock must
+// be held.
+func (server *Server) claimed(key string, entry *awaited) bool {
+	if existing, ok := server.awaits.get(key); ok && existing.pending() {
+		return true
+	}
+	for _, other := range entry.otherKeys() {
+		if _, existing, _, ok := server.lookup(other); ok && existing.pending() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package gotcha
+
+import "expvar"
+
+// PublishExpvar publishes the server's pending count, outcome counters and store health as the expvar variable name,
+// so they're served at /debug/vars alongside the process's other variables. Like expvar.Publish, it panics if name
+// is already in use.
+func (server *Server) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(server.vars))
+}
+
+func (server *Server) vars() interface{} {
+	server.mu.Lock()
+	entries := server.awaits.len()
+	server.mu.Unlock()
+	pending := len(server.Pending())
+	healthy := server.Health == nil || server.Health() == nil
+	draining := server.Draining()
+	namespaces := map[string]interface{}{}
+	for namespace, usage := range server.namespaceUsage() {
+		namespaces[namespace] = map[string]int{
+			"pending":     usage.Pending,
+			"registered":  usage.Registered,
+			"max_pending": usage.Quota.MaxPending,
+			"per_hour":    usage.Quota.PerHour,
+		}
+	}
+
+	server.metrics.mu.Lock()
+	defer server.metrics.mu.Unlock()
+	resolved := map[string]uint64{}
+	for status, count := range server.metrics.resolved {
+		resolved[stateOf(status).String()] = count
+	}
+	blockHits := map[string]uint64{}
+	for reason, count := range server.metrics.blockHits {
+		blockHits[reason] = count
+	}
+	overQuota := map[string]uint64{}
+	for namespace, count := range server.metrics.overQuota {
+		overQuota[namespace] = count
+	}
+	return map[string]interface{}{
+		"pending":           pending,
+		"registered":        server.metrics.registered,
+		"resolved":          resolved,
+		"unrequested":       server.metrics.unrequested,
+		"blocklist_hits":    blockHits,
+		"allowlist_denials": server.metrics.allowListDenials,
+		"overloaded":        server.metrics.overloaded,
+		"replays":           server.metrics.replays,
+		"csrf_failures":     server.metrics.csrfFailures,
+		"resends":           server.metrics.resends,
+		"reissues":          server.metrics.reissues,
+		"rejected":          server.metrics.rejectedRegistrations,
+		"over_quota":        overQuota,
+		"namespaces":        namespaces,
+		"draining":          draining,
+		"store": map[string]interface{}{
+			"type":    "memory",
+			"healthy": healthy,
+			"entries": entries,
+		},
+	}
+}
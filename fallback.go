@@ -0,0 +1,43 @@
+package gotcha
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fallback registers the await that Fallback returns for identifier, if it isn't already known, so that the request
+// can be verified against it.
+func (server *Server) fallback(c *gin.Context, identifier string) {
+	if server.Fallback == nil || identifier == "" {
+		return
+	}
+	key := server.HashIdentifier(identifier)
+	server.mu.Lock()
+	_, _, _, known := server.lookup(key)
+	_, honeytoken := server.honeytokens[key]
+	server.mu.Unlock()
+	if known || honeytoken {
+		return
+	}
+
+	req, err := server.Fallback(c, identifier)
+	if err != nil {
+		server.logger().Error("gotcha: fallback failed", "identifier", key, "error", err)
+		// The request carries on as if the identifier didn't exist, so the hook isn't given the chance to respond.
+		server.handleError(nil, fmt.Errorf("gotcha: fallback: %w", err))
+		return
+	}
+	if req == nil {
+		return
+	}
+	req.Identifier = identifier
+	if namespace, ok := namespaceOf(c); ok {
+		req.Namespace = namespace
+	}
+	// Another request for the identifier might have got there first, which is fine.
+	if _, err := server.Register(*req); err != nil && !errors.Is(err, ErrPending) {
+		server.logger().Error("gotcha: registering fallback await failed", "identifier", key, "error", err)
+	}
+}
@@ -0,0 +1,22 @@
+package gotcha
+
+import "github.com/gin-gonic/gin"
+
+// FingerprintCookie is the cookie that the confirmation page sets to a fingerprint of the browser when
+// Server.Fingerprint is set. It's a hash of things like the user agent, language, screen size and time zone: enough to
+// tell devices apart, but not to identify one.
+const FingerprintCookie = "gotcha_fingerprint"
+
+// CookieFingerprint returns the fingerprint from FingerprintCookie. It can be used as Server.Fingerprint.
+func CookieFingerprint(c *gin.Context) string {
+	fingerprint, _ := c.Cookie(FingerprintCookie)
+	return fingerprint
+}
+
+// fingerprint returns the verifying device's fingerprint, if there's a Fingerprint hook.
+func (server *Server) fingerprint(c *gin.Context) string {
+	if server.Fingerprint == nil {
+		return ""
+	}
+	return server.Fingerprint(c)
+}
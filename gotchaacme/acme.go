@@ -0,0 +1,318 @@
+// Package gotchaacme obtains and renews certificates from an ACME CA, such as Let's Encrypt, with DNS-01 challenges.
+// They work for wildcard certificates and for instances that the CA can't reach on port 80. Set Manager.GetCertificate
+// as the GetCertificate of gotcha.Server.TLSConfig.
+package gotchaacme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DNSProvider publishes the TXT records that DNS-01 challenges are answered with. The cloudflare and route53
+// subpackages implement it.
+type DNSProvider interface {
+	// Present creates a TXT record at fqdn, such as "_acme-challenge.example.com.", with value.
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the record that Present created.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// Manager obtains a certificate for Hosts, and renews it before it expires.
+type Manager struct {
+	// Hosts are the hostnames that the certificate is for, such as "verify.example.com" or "*.example.com".
+	Hosts []string
+	// Provider publishes challenge records.
+	Provider DNSProvider
+	// Email is the contact address of the ACME account, for notices about expiring certificates.
+	Email string
+	// DirectoryURL is the ACME directory of the CA. Defaults to Let's Encrypt.
+	DirectoryURL string
+	// Cache stores the account key and certificate, so that they survive restarts. Without it, a new certificate is
+	// obtained each time the process starts, which soon runs into the CA's rate limits.
+	Cache autocert.Cache
+	// Propagation is how long to wait after publishing challenge records before asking the CA to check them. Defaults
+	// to 30 seconds.
+	Propagation time.Duration
+	// RenewBefore is how long before it expires that the certificate is renewed. Defaults to 30 days.
+	RenewBefore time.Duration
+	// Logger logs renewals. Defaults to slog.Default().
+	Logger *slog.Logger
+
+	// renewing is held while obtaining a certificate, and guards client; mu guards cert, so that handshakes aren't
+	// held up by renewals.
+	renewing sync.Mutex
+	client   *acme.Client
+	mu       sync.Mutex
+	cert     *tls.Certificate
+}
+
+const (
+	accountKeyName = "acme_account+key"
+	challengeLabel = "_acme-challenge."
+)
+
+// GetCertificate returns the certificate, obtaining it first if there isn't one yet. It has the signature of
+// tls.Config.GetCertificate.
+func (manager *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	manager.mu.Lock()
+	cert := manager.cert
+	manager.mu.Unlock()
+	if cert != nil {
+		return cert, nil
+	}
+	ctx := context.Background()
+	if hello.Context() != nil {
+		ctx = hello.Context()
+	}
+	return manager.Certificate(ctx)
+}
+
+// Certificate returns the certificate, from Cache or by obtaining a new one if there isn't one that's valid for
+// longer than RenewBefore.
+func (manager *Manager) Certificate(ctx context.Context) (*tls.Certificate, error) {
+	manager.renewing.Lock()
+	defer manager.renewing.Unlock()
+	manager.mu.Lock()
+	cert := manager.cert
+	manager.mu.Unlock()
+	if cert != nil && !manager.due(cert) {
+		return cert, nil
+	}
+	if cached, err := manager.cached(ctx); cert == nil && err == nil && !manager.due(cached) {
+		cert = cached
+	} else if cert, err = manager.obtain(ctx); err != nil {
+		return nil, err
+	}
+	manager.mu.Lock()
+	manager.cert = cert
+	manager.mu.Unlock()
+	return cert, nil
+}
+
+// Run renews the certificate as it comes due until ctx is done.
+func (manager *Manager) Run(ctx context.Context) {
+	for {
+		wait := time.Hour
+		if _, err := manager.Certificate(ctx); err != nil {
+			manager.logger().Warn("gotchaacme: obtaining certificate failed", "hosts", manager.Hosts, "error", err)
+			wait = time.Minute
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// due reports whether cert should be renewed.
+func (manager *Manager) due(cert *tls.Certificate) bool {
+	renewBefore := manager.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = 30 * 24 * time.Hour
+	}
+	return cert.Leaf == nil || time.Until(cert.Leaf.NotAfter) < renewBefore
+}
+
+// certName is the Cache key of the certificate.
+func (manager *Manager) certName() string {
+	return strings.Join(manager.Hosts, ",") + "+dns01"
+}
+
+func (manager *Manager) cached(ctx context.Context) (*tls.Certificate, error) {
+	if manager.Cache == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	data, err := manager.Cache.Get(ctx, manager.certName())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// acmeClient returns the ACME client, registering the account the first time. renewing must be held.
+func (manager *Manager) acmeClient(ctx context.Context) (*acme.Client, error) {
+	if manager.client != nil {
+		return manager.client, nil
+	}
+	key, err := manager.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{Key: key, DirectoryURL: manager.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = acme.LetsEncryptURL
+	}
+	account := &acme.Account{}
+	if manager.Email != "" {
+		account.Contact = []string{"mailto:" + manager.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("gotchaacme: registering account: %w", err)
+	}
+	manager.client = client
+	return client, nil
+}
+
+// accountKey returns the account key from Cache, or generates one.
+func (manager *Manager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	if manager.Cache != nil {
+		if data, err := manager.Cache.Get(ctx, accountKeyName); err == nil {
+			if block, _ := pem.Decode(data); block != nil {
+				return x509.ParseECPrivateKey(block.Bytes)
+			}
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if manager.Cache != nil {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := manager.Cache.Put(ctx, accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// obtain orders a new certificate, answering each authorization with a DNS-01 challenge. renewing must be held.
+func (manager *Manager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	if len(manager.Hosts) == 0 || manager.Provider == nil {
+		return nil, errors.New("gotchaacme: Hosts and Provider must be set")
+	}
+	client, err := manager.acmeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(manager.Hosts...))
+	if err != nil {
+		return nil, fmt.Errorf("gotchaacme: ordering certificate: %w", err)
+	}
+
+	type record struct{ fqdn, value string }
+	var records []record
+	defer func() {
+		for _, record := range records {
+			if err := manager.Provider.CleanUp(context.WithoutCancel(ctx), record.fqdn, record.value); err != nil {
+				manager.logger().Warn("gotchaacme: removing challenge record failed", "name", record.fqdn, "error", err)
+			}
+		}
+	}()
+	var challenges []*acme.Challenge
+	var authorizations []string
+	for _, url := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+			}
+		}
+		if challenge == nil {
+			return nil, fmt.Errorf("gotchaacme: no dns-01 challenge for %s", authz.Identifier.Value)
+		}
+		value, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		fqdn := challengeLabel + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+		if err := manager.Provider.Present(ctx, fqdn, value); err != nil {
+			return nil, fmt.Errorf("gotchaacme: publishing challenge record %s: %w", fqdn, err)
+		}
+		records = append(records, record{fqdn, value})
+		challenges = append(challenges, challenge)
+		authorizations = append(authorizations, authz.URI)
+	}
+
+	if len(challenges) > 0 {
+		propagation := manager.Propagation
+		if propagation <= 0 {
+			propagation = 30 * time.Second
+		}
+		select {
+		case <-time.After(propagation):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	for i, challenge := range challenges {
+		if _, err := client.Accept(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if _, err := client.WaitAuthorization(ctx, authorizations[i]); err != nil {
+			return nil, fmt.Errorf("gotchaacme: authorizing: %w", err)
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: manager.Hosts}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("gotchaacme: finalizing order: %w", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if manager.Cache != nil {
+		if err := manager.Cache.Put(ctx, manager.certName(), data); err != nil {
+			manager.logger().Warn("gotchaacme: caching certificate failed", "error", err)
+		}
+	}
+	manager.logger().Info("gotchaacme: obtained certificate", "hosts", manager.Hosts, "expires", cert.Leaf.NotAfter)
+	return &cert, nil
+}
+
+func (manager *Manager) logger() *slog.Logger {
+	if manager.Logger != nil {
+		return manager.Logger
+	}
+	return slog.Default()
+}
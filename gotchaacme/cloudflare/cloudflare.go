@@ -0,0 +1,130 @@
+// Package cloudflare publishes DNS-01 challenge records with the Cloudflare API. Set a Provider as
+// gotchaacme.Manager.Provider.
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const apiURL = "https://api.cloudflare.com/client/v4"
+
+// Provider is a gotchaacme.DNSProvider for zones hosted on Cloudflare.
+type Provider struct {
+	// Token is an API token with permission to edit DNS records of the zone.
+	Token string
+	// ZoneID is the zone that records are created in. Defaults to looking up the zone by name.
+	ZoneID string
+	// Client makes API requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+type response struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+type record struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// Present implements gotchaacme.DNSProvider.
+func (provider *Provider) Present(ctx context.Context, fqdn, value string) error {
+	zone, err := provider.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(record{Type: "TXT", Name: strings.TrimSuffix(fqdn, "."), Content: value, TTL: 120})
+	if err != nil {
+		return err
+	}
+	return provider.do(ctx, http.MethodPost, "/zones/"+zone+"/dns_records", body, nil)
+}
+
+// CleanUp implements gotchaacme.DNSProvider.
+func (provider *Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	zone, err := provider.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"type": {"TXT"}, "name": {strings.TrimSuffix(fqdn, ".")}, "content": {value}}
+	var records []record
+	if err := provider.do(ctx, http.MethodGet, "/zones/"+zone+"/dns_records?"+query.Encode(), nil, &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := provider.do(ctx, http.MethodDelete, "/zones/"+zone+"/dns_records/"+record.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zone returns the ID of the zone that fqdn is in: ZoneID, or that of the longest suffix of fqdn that's a zone.
+func (provider *Provider) zone(ctx context.Context, fqdn string) (string, error) {
+	if provider.ZoneID != "" {
+		return provider.ZoneID, nil
+	}
+	name := strings.TrimSuffix(fqdn, ".")
+	for {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := provider.do(ctx, http.MethodGet, "/zones?"+url.Values{"name": {name}}.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+		var ok bool
+		if _, name, ok = strings.Cut(name, "."); !ok || !strings.Contains(name, ".") {
+			return "", fmt.Errorf("cloudflare: no zone for %s", fqdn)
+		}
+	}
+}
+
+func (provider *Provider) do(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+provider.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := provider.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var decoded response
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return fmt.Errorf("cloudflare: %s %s: %s", method, path, resp.Status)
+	}
+	if !decoded.Success {
+		messages := make([]string, 0, len(decoded.Errors))
+		for _, e := range decoded.Errors {
+			messages = append(messages, e.Message)
+		}
+		return errors.New("cloudflare: " + strings.Join(messages, "; "))
+	}
+	if result != nil {
+		return json.Unmarshal(decoded.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+module github.com/fjah/gotcha/gotchaacme
+
+go 1.26.0
+
+require golang.org/x/crypto v0.57.0
+
+require (
+	golang.org/x/net v0.58.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+)
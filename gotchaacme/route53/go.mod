@@ -0,0 +1,14 @@
+module github.com/fjah/gotcha/gotchaacme/route53
+
+go 1.24
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.47.1
+	github.com/aws/aws-sdk-go-v2/service/route53 v1.70.0
+)
+
+require (
+	github.com/aws/aws-sdk-go-v2/internal/configsources v1.5.3 // indirect
+	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.8.3 // indirect
+	github.com/aws/smithy-go v1.28.1 // indirect
+)
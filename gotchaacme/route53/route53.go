@@ -0,0 +1,95 @@
+// Package route53 publishes DNS-01 challenge records in Amazon Route 53. Set a Provider as
+// gotchaacme.Manager.Provider.
+package route53
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Provider is a gotchaacme.DNSProvider for hosted zones in Route 53.
+type Provider struct {
+	// Client is the Route 53 client to use.
+	Client *route53.Client
+	// HostedZoneID is the zone that records are created in. Defaults to looking up the zone by name.
+	HostedZoneID string
+	// Timeout is how long to wait for changes to reach all of Route 53's name servers. Defaults to 2 minutes.
+	Timeout time.Duration
+}
+
+// New returns a Provider using client.
+func New(client *route53.Client) *Provider {
+	return &Provider{Client: client}
+}
+
+// Present implements gotchaacme.DNSProvider.
+func (provider *Provider) Present(ctx context.Context, fqdn, value string) error {
+	return provider.change(ctx, types.ChangeActionUpsert, fqdn, value)
+}
+
+// CleanUp implements gotchaacme.DNSProvider.
+func (provider *Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return provider.change(ctx, types.ChangeActionDelete, fqdn, value)
+}
+
+func (provider *Provider) change(ctx context.Context, action types.ChangeAction, fqdn, value string) error {
+	zone, err := provider.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	output, err := provider.Client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zone),
+		ChangeBatch: &types.ChangeBatch{Changes: []types.Change{{
+			Action: action,
+			ResourceRecordSet: &types.ResourceRecordSet{
+				Name:            aws.String(fqdn),
+				Type:            types.RRTypeTxt,
+				TTL:             aws.Int64(60),
+				ResourceRecords: []types.ResourceRecord{{Value: aws.String(`"` + value + `"`)}},
+			},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	if action != types.ChangeActionUpsert {
+		return nil
+	}
+	timeout := provider.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	waiter := route53.NewResourceRecordSetsChangedWaiter(provider.Client)
+	return waiter.Wait(ctx, &route53.GetChangeInput{Id: output.ChangeInfo.Id}, timeout)
+}
+
+// zone returns the ID of the hosted zone that fqdn is in: HostedZoneID, or that of the longest suffix of fqdn that's a
+// public hosted zone.
+func (provider *Provider) zone(ctx context.Context, fqdn string) (string, error) {
+	if provider.HostedZoneID != "" {
+		return provider.HostedZoneID, nil
+	}
+	name := strings.TrimSuffix(fqdn, ".") + "."
+	for strings.Count(name, ".") > 1 {
+		output, err := provider.Client.ListHostedZonesByName(ctx, &route53.ListHostedZonesByNameInput{
+			DNSName:  aws.String(name),
+			MaxItems: aws.Int32(1),
+		})
+		if err != nil {
+			return "", err
+		}
+		for _, zone := range output.HostedZones {
+			if aws.ToString(zone.Name) == name && (zone.Config == nil || !zone.Config.PrivateZone) {
+				return strings.TrimPrefix(aws.ToString(zone.Id), "/hostedzone/"), nil
+			}
+		}
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return "", fmt.Errorf("route53: no hosted zone for %s", fqdn)
+}
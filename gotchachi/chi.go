@@ -0,0 +1,20 @@
+// Package gotchachi serves gotcha's routes on a chi router, for applications that use chi rather than gin.
+package gotchachi
+
+import (
+	"strings"
+
+	"github.com/fjah/gotcha"
+	"github.com/go-chi/chi/v5"
+)
+
+// Mount registers server's routes on router under prefix, such as "/gotcha", which can be empty. Serve mustn't be
+// called as well; see gotcha.Server.Handler.
+func Mount(router chi.Router, prefix string, server *gotcha.Server) error {
+	handler, err := server.Handler(prefix)
+	if err != nil {
+		return err
+	}
+	router.Handle(strings.TrimSuffix(prefix, "/")+"/*", handler)
+	return nil
+}
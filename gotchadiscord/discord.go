@@ -0,0 +1,198 @@
+// Package gotchadiscord lets gotcha awaits be approved or denied from Discord.
+package gotchadiscord
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fjah/gotcha"
+)
+
+// Prefixes of the custom IDs of the buttons on approval messages, which are followed by the identifier.
+const (
+	actionApprove = "gotcha_approve:"
+	actionDeny    = "gotcha_deny:"
+)
+
+// Types and limits from the Discord API.
+const (
+	interactionPing         = 1
+	interactionComponent    = 3
+	responsePong            = 1
+	responseUpdateMessage   = 7
+	componentActionRow      = 1
+	componentButton         = 2
+	buttonStyleSuccess      = 3
+	buttonStyleDanger       = 4
+	maxCustomIDLength       = 100
+	maxInteractionBodyBytes = 1 << 20
+)
+
+// Approvals is a gotcha.EventSink that posts a message with approve and deny buttons to a Discord channel when an
+// await is registered, and an http.Handler for the application's interactions endpoint URL that resolves the await
+// when one is pressed. It doesn't work with gotcha.Server.HashKey, since the identifier has to be sent to Discord.
+type Approvals struct {
+	// Server resolves the awaits.
+	Server *gotcha.Server
+	// Token is the bot token that messages are posted with.
+	Token string
+	// Channel is the ID of the channel that approval requests are posted to.
+	Channel string
+	// PublicKey is the application's hex-encoded public key, which interactions are checked against.
+	PublicKey string
+	// Namespace, if set, limits approval requests to awaits in that namespace.
+	Namespace string
+	// Text returns the text of the approval request for event. Defaults to naming the identifier.
+	Text func(event gotcha.Event) string
+	// Client makes requests to Discord. Defaults to http.DefaultClient.
+	Client *http.Client
+	// APIURL is the base URL of the Discord API. Defaults to https://discord.com/api/v10.
+	APIURL string
+}
+
+// Send implements gotcha.EventSink.
+func (approvals *Approvals) Send(ctx context.Context, event gotcha.Event) error {
+	if event.Type != gotcha.EventRegistered || approvals.Namespace != "" && event.Namespace != approvals.Namespace {
+		return nil
+	}
+	if len(actionApprove+event.Identifier) > maxCustomIDLength {
+		return fmt.Errorf("gotchadiscord: identifier %q is too long for a button", event.Identifier)
+	}
+	text := fmt.Sprintf("Approval requested for **%s**.", event.Identifier)
+	if approvals.Text != nil {
+		text = approvals.Text(event)
+	}
+
+	message := map[string]interface{}{
+		"content": text,
+		"components": []interface{}{
+			map[string]interface{}{
+				"type": componentActionRow,
+				"components": []interface{}{
+					button(actionApprove+event.Identifier, "Approve", buttonStyleSuccess),
+					button(actionDeny+event.Identifier, "Deny", buttonStyleDanger),
+				},
+			},
+		},
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	apiURL := approvals.APIURL
+	if apiURL == "" {
+		apiURL = "https://discord.com/api/v10"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/channels/"+approvals.Channel+"/messages",
+		bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bot "+approvals.Token)
+	client := approvals.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("gotchadiscord: %s", resp.Status)
+	}
+	return nil
+}
+
+func button(customID, label string, style int) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      componentButton,
+		"custom_id": customID,
+		"label":     label,
+		"style":     style,
+	}
+}
+
+// ServeHTTP handles interactions from Discord.
+func (approvals *Approvals) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxInteractionBodyBytes))
+	if err != nil || !approvals.signed(r.Header, body) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	var interaction struct {
+		Type int `json:"type"`
+		Data struct {
+			CustomID string `json:"custom_id"`
+		} `json:"data"`
+		// Member is set for interactions in servers, and User for ones in DMs.
+		Member struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"member"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch interaction.Type {
+	case interactionPing:
+		json.NewEncoder(w).Encode(map[string]int{"type": responsePong})
+		return
+	case interactionComponent:
+	default:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user := interaction.Member.User.ID
+	if user == "" {
+		user = interaction.User.ID
+	}
+	customID := interaction.Data.CustomID
+	var text string
+	switch {
+	case strings.HasPrefix(customID, actionApprove):
+		text = fmt.Sprintf("Approved by <@%s>.", user)
+		if !approvals.Server.Approve(strings.TrimPrefix(customID, actionApprove)) {
+			text = "This request has already been resolved or has expired."
+		}
+	case strings.HasPrefix(customID, actionDeny):
+		text = fmt.Sprintf("Denied by <@%s>.", user)
+		if !approvals.Server.Deny(strings.TrimPrefix(customID, actionDeny)) {
+			text = "This request has already been resolved or has expired."
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": responseUpdateMessage,
+		"data": map[string]interface{}{"content": text, "components": []interface{}{}},
+	})
+}
+
+// signed reports whether an interaction was signed with the application's key.
+func (approvals *Approvals) signed(header http.Header, body []byte) bool {
+	publicKey, err1 := hex.DecodeString(approvals.PublicKey)
+	signature, err2 := hex.DecodeString(header.Get("X-Signature-Ed25519"))
+	if err1 != nil || err2 != nil || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	message := append([]byte(header.Get("X-Signature-Timestamp")), body...)
+	return ed25519.Verify(publicKey, message, signature)
+}
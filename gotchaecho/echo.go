@@ -0,0 +1,20 @@
+// Package gotchaecho serves gotcha's routes on an echo router, for applications that use echo rather than gin.
+package gotchaecho
+
+import (
+	"strings"
+
+	"github.com/fjah/gotcha"
+	"github.com/labstack/echo/v4"
+)
+
+// Mount registers server's routes on router under prefix, such as "/gotcha", which can be empty. Serve mustn't be
+// called as well; see gotcha.Server.Handler.
+func Mount(router *echo.Echo, prefix string, server *gotcha.Server) error {
+	handler, err := server.Handler(prefix)
+	if err != nil {
+		return err
+	}
+	router.Any(strings.TrimSuffix(prefix, "/")+"/*", echo.WrapHandler(handler))
+	return nil
+}
@@ -0,0 +1,79 @@
+// Package gotchakafka produces gotcha events to Kafka.
+package gotchakafka
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/fjah/gotcha"
+	"github.com/segmentio/kafka-go"
+)
+
+// KeyByIdentifier keys messages by identifier, so that each await's events stay in order on one partition.
+func KeyByIdentifier(event gotcha.Event) []byte {
+	return []byte(event.Identifier)
+}
+
+// KeyByNamespace keys messages by namespace, so that all of a namespace's events stay in order on one partition.
+func KeyByNamespace(event gotcha.Event) []byte {
+	return []byte(event.Namespace)
+}
+
+// Sink is a gotcha.EventSink that produces each event as a message to a Kafka topic, with its type and traceparent,
+// if it has one, as headers.
+//
+// Delivery guarantees are those of Writer. With RequiredAcks set to kafka.RequireAll and Async left false, Send
+// returns once the message has been committed to every in-sync replica, retrying up to MaxAttempts times, which
+// gives at-least-once delivery. Async writers trade that for throughput.
+type Sink struct {
+	// Writer writes the messages. Its Topic must be set.
+	Writer *kafka.Writer
+	// Key returns the message key for an event. Defaults to KeyByIdentifier.
+	Key func(gotcha.Event) []byte
+	// Protobuf encodes events with gotcha.Event.MarshalProto instead of as JSON.
+	Protobuf bool
+}
+
+// NewSink returns a Sink producing JSON events to topic that waits for every in-sync replica to acknowledge them.
+func NewSink(brokers []string, topic string) *Sink {
+	return &Sink{
+		Writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+// Send implements gotcha.EventSink.
+func (sink *Sink) Send(ctx context.Context, event gotcha.Event) error {
+	key := sink.Key
+	if key == nil {
+		key = KeyByIdentifier
+	}
+
+	var value []byte
+	if sink.Protobuf {
+		value = event.MarshalProto()
+	} else {
+		var err error
+		if value, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+	headers := []kafka.Header{{Key: "type", Value: []byte(event.Type)}}
+	if event.TraceParent != "" {
+		headers = append(headers, kafka.Header{Key: "traceparent", Value: []byte(event.TraceParent)})
+	}
+	return sink.Writer.WriteMessages(ctx, kafka.Message{
+		Key:     key(event),
+		Value:   value,
+		Headers: headers,
+	})
+}
+
+// Close flushes pending messages and closes the writer.
+func (sink *Sink) Close() error {
+	return sink.Writer.Close()
+}
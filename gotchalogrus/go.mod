@@ -0,0 +1,7 @@
+module github.com/fjah/gotcha/gotchalogrus
+
+go 1.26.0
+
+require github.com/sirupsen/logrus v1.10.2
+
+require golang.org/x/sys v0.48.0 // indirect
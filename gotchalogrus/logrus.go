@@ -0,0 +1,74 @@
+// Package gotchalogrus routes gotcha's logs through a logrus logger.
+package gotchalogrus
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// New returns a logger for gotcha.Server.Logger that writes to logger.
+func New(logger logrus.FieldLogger) *slog.Logger {
+	return slog.New(&handler{entry: logger.WithFields(logrus.Fields{})})
+}
+
+// handler is a slog.Handler that writes to a logrus entry. Attributes in groups are prefixed with the group's name.
+type handler struct {
+	entry  *logrus.Entry
+	prefix string
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.entry.Logger.IsLevelEnabled(logrusLevel(level))
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	fields := logrus.Fields{}
+	record.Attrs(func(attr slog.Attr) bool {
+		addField(fields, h.prefix, attr)
+		return true
+	})
+	entry := h.entry.WithContext(ctx).WithTime(record.Time)
+	if len(fields) > 0 {
+		entry = entry.WithFields(fields)
+	}
+	entry.Log(logrusLevel(record.Level), record.Message)
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := logrus.Fields{}
+	for _, attr := range attrs {
+		addField(fields, h.prefix, attr)
+	}
+	return &handler{entry: h.entry.WithFields(fields), prefix: h.prefix}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{entry: h.entry, prefix: h.prefix + name + "."}
+}
+
+// addField adds attr to fields, flattening groups.
+func addField(fields logrus.Fields, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, member := range value.Group() {
+			addField(fields, prefix+attr.Key+".", member)
+		}
+		return
+	}
+	fields[prefix+attr.Key] = value.Any()
+}
+
+func logrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level >= slog.LevelError:
+		return logrus.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logrus.WarnLevel
+	case level >= slog.LevelInfo:
+		return logrus.InfoLevel
+	}
+	return logrus.DebugLevel
+}
@@ -0,0 +1,82 @@
+// Package gotchamqtt publishes gotcha events to an MQTT broker, so that devices waiting on an approval can subscribe
+// to it directly.
+package gotchamqtt
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fjah/gotcha"
+)
+
+// topicEscaper escapes the characters that have a special meaning in topic names.
+var topicEscaper = strings.NewReplacer("%", "%25", "/", "%2F", "+", "%2B", "#", "%23")
+
+// EscapeTopic escapes a topic level so that it can't contain separators or wildcards. Devices should use it to build
+// the topic that they subscribe to.
+func EscapeTopic(level string) string {
+	return topicEscaper.Replace(level)
+}
+
+// Sink is a gotcha.EventSink that publishes events as awaits resolve. Registrations aren't published. By default each
+// event goes to <Prefix>/<identifier>, or <Prefix>/<namespace>/<identifier> for namespaced awaits.
+type Sink struct {
+	// Client is a connected MQTT client.
+	Client mqtt.Client
+	// Prefix is the first level of each topic. Defaults to "gotcha".
+	Prefix string
+	// Topic, if set, returns the topic for an event instead.
+	Topic func(gotcha.Event) string
+	// QoS is the quality of service to publish with. Defaults to 0; 1 is recommended.
+	QoS byte
+	// Retained publishes events as retained messages, so that devices that subscribe late still get the outcome.
+	Retained bool
+	// Protobuf encodes events with gotcha.Event.MarshalProto instead of as JSON.
+	Protobuf bool
+}
+
+// NewSink returns a Sink that publishes retained JSON events on client with QoS 1.
+func NewSink(client mqtt.Client) *Sink {
+	return &Sink{Client: client, QoS: 1, Retained: true}
+}
+
+func (sink *Sink) topic(event gotcha.Event) string {
+	if sink.Topic != nil {
+		return sink.Topic(event)
+	}
+	prefix := sink.Prefix
+	if prefix == "" {
+		prefix = "gotcha"
+	}
+	if event.Namespace != "" {
+		return prefix + "/" + EscapeTopic(event.Namespace) + "/" + EscapeTopic(event.Identifier)
+	}
+	return prefix + "/" + EscapeTopic(event.Identifier)
+}
+
+// Send implements gotcha.EventSink.
+func (sink *Sink) Send(ctx context.Context, event gotcha.Event) error {
+	if event.Type == gotcha.EventRegistered {
+		return nil
+	}
+
+	var payload []byte
+	if sink.Protobuf {
+		payload = event.MarshalProto()
+	} else {
+		var err error
+		if payload, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+
+	token := sink.Client.Publish(sink.topic(event), sink.QoS, sink.Retained, payload)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,49 @@
+// Package gotchanats publishes gotcha events to NATS.
+package gotchanats
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/fjah/gotcha"
+	"github.com/nats-io/nats.go"
+)
+
+// Sink is a gotcha.EventSink that publishes each event to the subject <Prefix>.<event type>, e.g.
+// "gotcha.verified", with the event's traceparent, if it has one, as a header.
+type Sink struct {
+	// Conn is the NATS connection to publish on.
+	Conn *nats.Conn
+	// Prefix is the start of each subject. Defaults to "gotcha".
+	Prefix string
+	// Protobuf encodes events with gotcha.Event.MarshalProto instead of as JSON.
+	Protobuf bool
+}
+
+// NewSink returns a Sink that publishes JSON events on conn with the subject prefix "gotcha".
+func NewSink(conn *nats.Conn) *Sink {
+	return &Sink{Conn: conn}
+}
+
+// Send implements gotcha.EventSink.
+func (sink *Sink) Send(ctx context.Context, event gotcha.Event) error {
+	prefix := sink.Prefix
+	if prefix == "" {
+		prefix = "gotcha"
+	}
+
+	var payload []byte
+	if sink.Protobuf {
+		payload = event.MarshalProto()
+	} else {
+		var err error
+		if payload, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+	msg := &nats.Msg{Subject: prefix + "." + event.Type, Data: payload}
+	if event.TraceParent != "" {
+		msg.Header = nats.Header{"traceparent": {event.TraceParent}}
+	}
+	return sink.Conn.PublishMsg(msg)
+}
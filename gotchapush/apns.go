@@ -0,0 +1,134 @@
+package gotchapush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fjah/gotcha"
+)
+
+// apnsTokenLifetime is how long provider tokens are reused for. Apple rejects tokens older than an hour, and ones that
+// are refreshed more often than every 20 minutes.
+const apnsTokenLifetime = 45 * time.Minute
+
+// APNs is a gotcha.Notifier that sends notifications with the APNs provider API, authenticating with a token
+// signing key.
+type APNs struct {
+	// Key is the ES256 signing key from the Apple developer account.
+	Key *ecdsa.PrivateKey
+	// KeyID is the ID of Key, and TeamID is the ID of the team that it belongs to.
+	KeyID  string
+	TeamID string
+	// Topic is the app's bundle ID.
+	Topic string
+	// Sandbox sends notifications through the development environment.
+	Sandbox bool
+	// Client sends requests to APNs. Defaults to http.DefaultClient, which speaks HTTP/2 as APNs requires.
+	Client *http.Client
+	// APIURL, if set, overrides the APNs server.
+	APIURL string
+
+	mu        sync.Mutex
+	token     string
+	tokenTime time.Time
+}
+
+// ParseAPNsKey parses the contents of a .p8 signing key file.
+func ParseAPNsKey(p8 []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(p8)
+	if block == nil {
+		return nil, errors.New("gotchapush: no PEM data in key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gotchapush: key isn't an ECDSA key")
+	}
+	return ecdsaKey, nil
+}
+
+// Notify implements gotcha.Notifier.
+func (apns *APNs) Notify(ctx context.Context, token string, notification gotcha.Notification) error {
+	message := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": notification.Title, "body": notification.Body},
+		},
+	}
+	for key, value := range notification.Data {
+		if key != "aps" {
+			message[key] = value
+		}
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	providerToken, err := apns.providerToken()
+	if err != nil {
+		return err
+	}
+
+	apiURL := apns.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.push.apple.com"
+		if apns.Sandbox {
+			apiURL = "https://api.sandbox.push.apple.com"
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/3/device/"+token, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("apns-topic", apns.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	return send(apns.Client, req, "APNs")
+}
+
+// providerToken returns a JWT signed with Key, reusing the last one until it's due to be refreshed.
+func (apns *APNs) providerToken() (string, error) {
+	apns.mu.Lock()
+	defer apns.mu.Unlock()
+	if apns.token != "" && time.Since(apns.tokenTime) < apnsTokenLifetime {
+		return apns.token, nil
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": apns.KeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{"iss": apns.TeamID, "iat": now.Unix()})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, apns.Key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants the signature as the fixed-size concatenation of r and s, rather than ASN.1.
+	size := (apns.Key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+
+	apns.token = signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	apns.tokenTime = now
+	return apns.token, nil
+}
@@ -0,0 +1,67 @@
+// Package gotchapush sends gotcha's push notifications through Firebase Cloud Messaging and the Apple Push
+// Notification service.
+package gotchapush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/fjah/gotcha"
+)
+
+// FCM is a gotcha.Notifier that sends notifications with the FCM HTTP v1 API.
+type FCM struct {
+	// ProjectID is the ID of the Firebase project.
+	ProjectID string
+	// Client sends requests to FCM. It must authorise them with the firebase.messaging OAuth scope, e.g. a client from
+	// golang.org/x/oauth2/google.DefaultClient.
+	Client *http.Client
+	// APIURL is the base URL of the FCM API. Defaults to https://fcm.googleapis.com.
+	APIURL string
+}
+
+// Notify implements gotcha.Notifier.
+func (fcm *FCM) Notify(ctx context.Context, token string, notification gotcha.Notification) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": notification.Title, "body": notification.Body},
+			"data":         notification.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	apiURL := fcm.APIURL
+	if apiURL == "" {
+		apiURL = "https://fcm.googleapis.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		apiURL+"/v1/projects/"+fcm.ProjectID+"/messages:send", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return send(fcm.Client, req, "FCM")
+}
+
+// send sends req with client, turning unsuccessful responses into errors.
+func send(client *http.Client, req *http.Request, service string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("gotchapush: %s responded %s: %s", service, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package gotchaquic serves gotcha over HTTP/3 with quic-go, for clients on lossy mobile networks where QUIC cuts tail
+// latency. Set a Listener as gotcha.Server.HTTP3.
+package gotchaquic
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Listener is a gotcha.HTTP3 that listens on UDP.
+type Listener struct {
+	// Address is the UDP address to listen on. Defaults to the server's address.
+	Address string
+	// Port is the port advertised with Alt-Svc, if it differs from the one listened on, e.g. behind a load balancer.
+	Port int
+	// MaxAge is how long clients can remember that HTTP/3 is available. Defaults to 24 hours.
+	MaxAge time.Duration
+	// QUICConfig configures QUIC connections. Defaults to quic-go's defaults.
+	QUICConfig *quic.Config
+	// IdleTimeout is how long idle connections are kept open for. Zero keeps them open until the client closes them.
+	IdleTimeout time.Duration
+}
+
+// ListenAndServe implements gotcha.HTTP3.
+func (listener *Listener) ListenAndServe(address string, handler http.Handler, tlsConfig *tls.Config) error {
+	if listener.Address != "" {
+		address = listener.Address
+	}
+	server := &http3.Server{
+		Addr:        address,
+		Handler:     handler,
+		TLSConfig:   http3.ConfigureTLSConfig(tlsConfig),
+		QUICConfig:  listener.QUICConfig,
+		IdleTimeout: listener.IdleTimeout,
+	}
+	return server.ListenAndServe()
+}
+
+// AltSvc implements gotcha.HTTP3.
+func (listener *Listener) AltSvc(address string) string {
+	if listener.Address != "" {
+		address = listener.Address
+	}
+	port := listener.Port
+	if port == 0 {
+		_, portName, err := net.SplitHostPort(address)
+		if err != nil {
+			return ""
+		}
+		if port, err = net.LookupPort("udp", portName); err != nil {
+			return ""
+		}
+	}
+	maxAge := listener.MaxAge
+	if maxAge <= 0 {
+		maxAge = 24 * time.Hour
+	}
+	return fmt.Sprintf(`%s=":%d"; ma=%d`, http3.NextProtoH3, port, int(maxAge.Seconds()))
+}
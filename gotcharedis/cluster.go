@@ -0,0 +1,126 @@
+package gotcharedis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/fjah/gotcha"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cluster is a gotcha.Cluster that uses Redis pub/sub. Forwarded requests are broadcast to every instance, and each
+// replies on a channel specific to the request: the instance with the await with its response, and the rest with a
+// miss, so that the sender can stop waiting as soon as everyone has answered.
+type Cluster struct {
+	// Client is the Redis client to use.
+	Client redis.UniversalClient
+	// Prefix is prepended to channel names. Defaults to "gotcha:cluster:".
+	Prefix string
+	// Timeout is how long to wait for other instances to reply. Defaults to 2 seconds.
+	Timeout time.Duration
+}
+
+// NewCluster returns a Cluster using client.
+func NewCluster(client redis.UniversalClient) *Cluster {
+	return &Cluster{Client: client}
+}
+
+type envelope struct {
+	Reply   string                   `json:"reply"`
+	Request *gotcha.ForwardedRequest `json:"request"`
+}
+
+type reply struct {
+	// Response is nil if the instance didn't have the await.
+	Response *gotcha.ForwardedResponse `json:"response"`
+}
+
+func (cluster *Cluster) channel(name string) string {
+	prefix := cluster.Prefix
+	if prefix == "" {
+		prefix = "gotcha:cluster:"
+	}
+	return prefix + name
+}
+
+// Forward implements gotcha.Cluster.
+func (cluster *Cluster) Forward(ctx context.Context, req *gotcha.ForwardedRequest) (*gotcha.ForwardedResponse, error) {
+	timeout := cluster.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	replyChannel := cluster.channel("reply:" + hex.EncodeToString(id))
+	sub := cluster.Client.Subscribe(ctx, replyChannel)
+	defer sub.Close()
+	// Wait for the subscription to be confirmed, so that no replies are missed.
+	if _, err := sub.Receive(ctx); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(envelope{Reply: replyChannel, Request: req})
+	if err != nil {
+		return nil, err
+	}
+	receivers, err := cluster.Client.Publish(ctx, cluster.channel("forward"), payload).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := sub.Channel()
+	for ; receivers > 0; receivers-- {
+		select {
+		case message := <-messages:
+			var answer reply
+			if err := json.Unmarshal([]byte(message.Payload), &answer); err != nil {
+				return nil, err
+			}
+			if answer.Response != nil {
+				return answer.Response, nil
+			}
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
+
+// Listen implements gotcha.Cluster.
+func (cluster *Cluster) Listen(ctx context.Context, handle func(*gotcha.ForwardedRequest) *gotcha.ForwardedResponse) error {
+	sub := cluster.Client.Subscribe(ctx, cluster.channel("forward"))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	messages := sub.Channel()
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var forwarded envelope
+			if err := json.Unmarshal([]byte(message.Payload), &forwarded); err != nil || forwarded.Request == nil {
+				continue
+			}
+			go func() {
+				payload, err := json.Marshal(reply{Response: handle(forwarded.Request)})
+				if err == nil {
+					cluster.Client.Publish(ctx, forwarded.Reply, payload)
+				}
+			}()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package gotcharedis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/fjah/gotcha"
+	"github.com/redis/go-redis/v9"
+)
+
+// dequeueScript pops the earliest delivery in the sorted set that's due.
+var dequeueScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if #due == 0 then
+	return false
+end
+redis.call("ZREM", KEYS[1], due[1])
+return due[1]
+`)
+
+// Queue is a gotcha.Queue shared by every instance using the same Redis, kept in a sorted set scored by when each
+// delivery is due. A delivery is removed before it's sent, so one that's in flight when an instance dies is lost.
+type Queue struct {
+	// Client is the Redis client to use.
+	Client redis.UniversalClient
+	// Key is the key of the sorted set. Defaults to "gotcha:deliveries".
+	Key string
+	// PollInterval is how often Dequeue checks for due deliveries when there aren't any. Defaults to 1 second.
+	PollInterval time.Duration
+}
+
+// NewQueue returns a Queue using client.
+func NewQueue(client redis.UniversalClient) *Queue {
+	return &Queue{Client: client}
+}
+
+func (queue *Queue) key() string {
+	if queue.Key == "" {
+		return "gotcha:deliveries"
+	}
+	return queue.Key
+}
+
+// Enqueue implements gotcha.Queue.
+func (queue *Queue) Enqueue(ctx context.Context, delivery gotcha.Delivery, at time.Time) error {
+	member, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return queue.Client.ZAdd(ctx, queue.key(), redis.Z{Score: float64(at.UnixMilli()), Member: member}).Err()
+}
+
+// Dequeue implements gotcha.Queue.
+func (queue *Queue) Dequeue(ctx context.Context) (gotcha.Delivery, error) {
+	interval := queue.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		member, err := dequeueScript.Run(ctx, queue.Client, []string{queue.key()}, now).Text()
+		if err == nil {
+			var delivery gotcha.Delivery
+			err = json.Unmarshal([]byte(member), &delivery)
+			return delivery, err
+		}
+		if err != redis.Nil {
+			return gotcha.Delivery{}, err
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return gotcha.Delivery{}, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package gotcharedis provides Redis-backed implementations of gotcha's extension points, for running several gotcha
+// instances behind a load balancer.
+package gotcharedis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitScript counts a request in the current fixed window, starting the window with the first request.
+var rateLimitScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+// RateLimiter is a gotcha.RateLimiter shared by every instance using the same Redis. It counts requests in fixed
+// windows, like gotcha.NewRateLimiter.
+type RateLimiter struct {
+	// Client is the Redis client to use. *redis.Client, *redis.ClusterClient and *redis.Ring all work.
+	Client redis.Scripter
+	// Limit is the number of requests allowed per key in each Window.
+	Limit int
+	// Window is the length of each window.
+	Window time.Duration
+	// Prefix is prepended to keys. Defaults to "gotcha:ratelimit:".
+	Prefix string
+}
+
+// NewRateLimiter returns a RateLimiter that allows limit requests per key in each window.
+func NewRateLimiter(client redis.Scripter, limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{Client: client, Limit: limit, Window: window}
+}
+
+// Allow implements gotcha.RateLimiter.
+func (limiter *RateLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	prefix := limiter.Prefix
+	if prefix == "" {
+		prefix = "gotcha:ratelimit:"
+	}
+	reply, err := rateLimitScript.Run(ctx, limiter.Client, []string{prefix + key}, limiter.Window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	count, ttl := reply[0], time.Duration(reply[1])*time.Millisecond
+	if count > int64(limiter.Limit) {
+		return false, ttl, nil
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,198 @@
+// Package gotchaslack lets gotcha awaits be approved or denied from Slack.
+package gotchaslack
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fjah/gotcha"
+)
+
+// Action IDs of the buttons on approval messages.
+const (
+	actionApprove = "gotcha_approve"
+	actionDeny    = "gotcha_deny"
+)
+
+// Approvals is a gotcha.EventSink that posts a message with approve and deny buttons to Slack when an await is
+// registered, and an http.Handler for the app's interactivity request URL that resolves the await when one is pressed.
+// It doesn't work with gotcha.Server.HashKey, since the identifier has to be sent to Slack.
+type Approvals struct {
+	// Server resolves the awaits.
+	Server *gotcha.Server
+	// Token is the bot token, xoxb-..., that messages are posted with.
+	Token string
+	// Channel is the ID of the channel that approval requests are posted to.
+	Channel string
+	// SigningSecret is the app's signing secret, which interaction requests are checked against.
+	SigningSecret string
+	// Namespace, if set, limits approval requests to awaits in that namespace.
+	Namespace string
+	// Text returns the text of the approval request for event. Defaults to naming the identifier.
+	Text func(event gotcha.Event) string
+	// Client makes requests to Slack. Defaults to http.DefaultClient.
+	Client *http.Client
+	// APIURL is the base URL of the Slack Web API. Defaults to https://slack.com/api.
+	APIURL string
+}
+
+// Send implements gotcha.EventSink.
+func (approvals *Approvals) Send(ctx context.Context, event gotcha.Event) error {
+	if event.Type != gotcha.EventRegistered || approvals.Namespace != "" && event.Namespace != approvals.Namespace {
+		return nil
+	}
+	text := fmt.Sprintf("Approval requested for *%s*.", event.Identifier)
+	if approvals.Text != nil {
+		text = approvals.Text(event)
+	}
+
+	message := map[string]interface{}{
+		"channel": approvals.Channel,
+		"text":    text,
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": text},
+			},
+			map[string]interface{}{
+				"type": "actions",
+				"elements": []interface{}{
+					button(actionApprove, "Approve", "primary", event.Identifier),
+					button(actionDeny, "Deny", "danger", event.Identifier),
+				},
+			},
+		},
+	}
+	apiURL := approvals.APIURL
+	if apiURL == "" {
+		apiURL = "https://slack.com/api"
+	}
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := approvals.post(ctx, apiURL+"/chat.postMessage", message, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New("gotchaslack: " + resp.Error)
+	}
+	return nil
+}
+
+func button(actionID, text, style, identifier string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "button",
+		"action_id": actionID,
+		"text":      map[string]string{"type": "plain_text", "text": text},
+		"style":     style,
+		"value":     identifier,
+	}
+}
+
+// ServeHTTP handles interaction requests from Slack.
+func (approvals *Approvals) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil || !approvals.signed(r.Header, body) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Type string `json:"type"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Actions []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+		ResponseURL string `json:"response_url"`
+	}
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil || payload.Type != "block_actions" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	for _, action := range payload.Actions {
+		var text string
+		switch action.ActionID {
+		case actionApprove:
+			text = fmt.Sprintf("Approved by <@%s>.", payload.User.ID)
+			if !approvals.Server.Approve(action.Value) {
+				text = "This request has already been resolved or has expired."
+			}
+		case actionDeny:
+			text = fmt.Sprintf("Denied by <@%s>.", payload.User.ID)
+			if !approvals.Server.Deny(action.Value) {
+				text = "This request has already been resolved or has expired."
+			}
+		default:
+			continue
+		}
+		if payload.ResponseURL != "" {
+			update := map[string]interface{}{"replace_original": true, "text": text}
+			approvals.post(r.Context(), payload.ResponseURL, update, nil)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// signed reports whether a request was signed with SigningSecret in the last 5 minutes.
+func (approvals *Approvals) signed(header http.Header, body []byte) bool {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)).Abs() > 5*time.Minute {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(approvals.SigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(header.Get("X-Slack-Signature")), []byte(expected))
+}
+
+// post sends body as JSON to url, decoding the response into resp if it isn't nil.
+func (approvals *Approvals) post(ctx context.Context, url string, body, resp interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+approvals.Token)
+	client := approvals.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("gotchaslack: %s", res.Status)
+	}
+	if resp != nil {
+		return json.NewDecoder(res.Body).Decode(resp)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package gotchatelegram lets gotcha awaits be approved or denied from Telegram.
+package gotchatelegram
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fjah/gotcha"
+)
+
+// Prefixes of the callback data of the buttons on approval messages, which are followed by the identifier.
+const (
+	actionApprove = "approve:"
+	actionDeny    = "deny:"
+)
+
+// maxCallbackData is the most bytes of callback data that Telegram allows on a button.
+const maxCallbackData = 64
+
+// Approvals is a gotcha.EventSink that sends a message with approve and deny buttons to a Telegram chat when an
+// await is registered, and an http.Handler for the bot's webhook that resolves the await when one is pressed.
+// It doesn't work with gotcha.Server.HashKey, since the identifier has to be sent to Telegram.
+type Approvals struct {
+	// Server resolves the awaits.
+	Server *gotcha.Server
+	// Token is the bot's token.
+	Token string
+	// ChatID is the chat that approval requests are sent to.
+	ChatID int64
+	// SecretToken is the secret_token that the webhook was set with, which updates are checked against.
+	SecretToken string
+	// Namespace, if set, limits approval requests to awaits in that namespace.
+	Namespace string
+	// Text returns the text of the approval request for event. Defaults to naming the identifier.
+	Text func(event gotcha.Event) string
+	// Client makes requests to Telegram. Defaults to http.DefaultClient.
+	Client *http.Client
+	// APIURL is the base URL of the Bot API. Defaults to https://api.telegram.org.
+	APIURL string
+}
+
+// Send implements gotcha.EventSink.
+func (approvals *Approvals) Send(ctx context.Context, event gotcha.Event) error {
+	if event.Type != gotcha.EventRegistered || approvals.Namespace != "" && event.Namespace != approvals.Namespace {
+		return nil
+	}
+	if len(actionApprove+event.Identifier) > maxCallbackData {
+		return fmt.Errorf("gotchatelegram: identifier %q is too long for a button", event.Identifier)
+	}
+	text := fmt.Sprintf("Approval requested for %s.", event.Identifier)
+	if approvals.Text != nil {
+		text = approvals.Text(event)
+	}
+	return approvals.call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id": approvals.ChatID,
+		"text":    text,
+		"reply_markup": map[string]interface{}{
+			"inline_keyboard": [][]map[string]string{{
+				{"text": "Approve", "callback_data": actionApprove + event.Identifier},
+				{"text": "Deny", "callback_data": actionDeny + event.Identifier},
+			}},
+		},
+	})
+}
+
+// ServeHTTP handles updates sent to the bot's webhook.
+func (approvals *Approvals) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if approvals.SecretToken == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(approvals.SecretToken)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	var update struct {
+		CallbackQuery *struct {
+			ID   string `json:"id"`
+			Data string `json:"data"`
+			From struct {
+				FirstName string `json:"first_name"`
+				Username  string `json:"username"`
+			} `json:"from"`
+			Message *struct {
+				MessageID int64 `json:"message_id"`
+				Chat      struct {
+					ID int64 `json:"id"`
+				} `json:"chat"`
+			} `json:"message"`
+		} `json:"callback_query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	// Other kinds of update are acknowledged so that Telegram doesn't retry them.
+	query := update.CallbackQuery
+	if query == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	who := query.From.FirstName
+	if query.From.Username != "" {
+		who = "@" + query.From.Username
+	}
+	var text string
+	switch {
+	case strings.HasPrefix(query.Data, actionApprove):
+		text = "Approved by " + who + "."
+		if !approvals.Server.Approve(strings.TrimPrefix(query.Data, actionApprove)) {
+			text = "This request has already been resolved or has expired."
+		}
+	case strings.HasPrefix(query.Data, actionDeny):
+		text = "Denied by " + who + "."
+		if !approvals.Server.Deny(strings.TrimPrefix(query.Data, actionDeny)) {
+			text = "This request has already been resolved or has expired."
+		}
+	default:
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	approvals.call(r.Context(), "answerCallbackQuery", map[string]interface{}{"callback_query_id": query.ID})
+	if query.Message != nil {
+		approvals.call(r.Context(), "editMessageText", map[string]interface{}{
+			"chat_id":    query.Message.Chat.ID,
+			"message_id": query.Message.MessageID,
+			"text":       text,
+		})
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// call calls a Bot API method with params.
+func (approvals *Approvals) call(ctx context.Context, method string, params interface{}) error {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	apiURL := approvals.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/bot"+approvals.Token+"/"+method,
+		bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := approvals.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return errors.New("gotchatelegram: " + result.Description)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package gotchazap routes gotcha's logs through a zap logger.
+package gotchazap
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a logger for gotcha.Server.Logger that writes to logger.
+func New(logger *zap.Logger) *slog.Logger {
+	return slog.New(&handler{logger: logger})
+}
+
+// handler is a slog.Handler that writes to a zap logger.
+type handler struct {
+	logger *zap.Logger
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.logger.Core().Enabled(zapLevel(level))
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	fields := make([]zap.Field, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = append(fields, field(attr))
+		return true
+	})
+	if entry := h.logger.Check(zapLevel(record.Level), record.Message); entry != nil {
+		entry.Time = record.Time
+		if record.PC != 0 {
+			frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+			entry.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+		}
+		entry.Write(fields...)
+	}
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]zap.Field, 0, len(attrs))
+	for _, attr := range attrs {
+		fields = append(fields, field(attr))
+	}
+	return &handler{logger: h.logger.With(fields...)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{logger: h.logger.With(zap.Namespace(name))}
+}
+
+// field converts attr to a zap field.
+func field(attr slog.Attr) zap.Field {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return zap.String(attr.Key, value.String())
+	case slog.KindInt64:
+		return zap.Int64(attr.Key, value.Int64())
+	case slog.KindUint64:
+		return zap.Uint64(attr.Key, value.Uint64())
+	case slog.KindFloat64:
+		return zap.Float64(attr.Key, value.Float64())
+	case slog.KindBool:
+		return zap.Bool(attr.Key, value.Bool())
+	case slog.KindDuration:
+		return zap.Duration(attr.Key, value.Duration())
+	case slog.KindTime:
+		return zap.Time(attr.Key, value.Time())
+	case slog.KindGroup:
+		return zap.Object(attr.Key, group(value.Group()))
+	}
+	if err, ok := value.Any().(error); ok {
+		return zap.NamedError(attr.Key, err)
+	}
+	return zap.Any(attr.Key, value.Any())
+}
+
+// group encodes a slog group as a zap object.
+type group []slog.Attr
+
+func (attrs group) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	for _, attr := range attrs {
+		field(attr).AddTo(encoder)
+	}
+	return nil
+}
+
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zapcore.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zapcore.WarnLevel
+	case level >= slog.LevelInfo:
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
@@ -0,0 +1,18 @@
+package gotcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// HashIdentifier returns the form that identifier is kept in: a keyed hash if HashKey is set, or identifier itself.
+// Pending, events and forwarded requests report identifiers in this form.
+func (server *Server) HashIdentifier(identifier string) string {
+	if server.HashKey == nil {
+		return identifier
+	}
+	mac := hmac.New(sha256.New, server.HashKey)
+	mac.Write([]byte(identifier))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
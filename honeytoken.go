@@ -0,0 +1,69 @@
+package gotcha
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HoneytokenAlert describes a request for a honeytoken.
+type HoneytokenAlert struct {
+	// Identifier is the honeytoken, as returned by HashIdentifier.
+	Identifier string
+	Time       time.Time
+	ClientIP   string
+	Method     string
+	UserAgent  string
+	RequestID  string
+	// Blocked is set if the client was blocked. See Server.HoneytokenBlock.
+	Blocked bool
+}
+
+// AddHoneytoken registers identifier as a decoy that's never sent to anyone. It looks like any other unknown
+// identifier to clients, but requests to verify it call Server.HoneytokenAlert, since they can only come from someone
+// replaying scraped or guessed links. Honeytokens don't expire.
+func (server *Server) AddHoneytoken(identifier string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.honeytokens == nil {
+		server.honeytokens = map[string]struct{}{}
+	}
+	server.honeytokens[server.HashIdentifier(identifier)] = struct{}{}
+}
+
+// RemoveHoneytoken unregisters a honeytoken added with AddHoneytoken.
+func (server *Server) RemoveHoneytoken(identifier string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.honeytokens, server.HashIdentifier(identifier))
+}
+
+// honeytoken reports whether key is a honeytoken, raising the alert and blocking the client if it is. The server's
+// lock must be held.
+func (server *Server) honeytoken(c *gin.Context, key string) bool {
+	if _, ok := server.honeytokens[key]; !ok {
+		return false
+	}
+	alert := HoneytokenAlert{
+		Identifier: key,
+		Time:       time.Now(),
+		ClientIP:   server.clientIP(c),
+		Method:     c.Request.Method,
+		UserAgent:  c.Request.UserAgent(),
+		RequestID:  requestIDOf(c),
+		Blocked:    server.HoneytokenBlock,
+	}
+	if alert.Blocked {
+		ttl := server.HoneypotTTL
+		if ttl == 0 {
+			ttl = 24 * time.Hour
+		}
+		server.blockScanner(alert.ClientIP, ttl)
+	}
+	server.logger().Error("gotcha: honeytoken requested", "identifier", key, "client_ip", alert.ClientIP,
+		"request_id", alert.RequestID)
+	if hook := server.HoneytokenAlert; hook != nil {
+		go hook(alert)
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package gotcha
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// HTTP3 serves the router over HTTP/3 alongside TCP. The gotchaquic package implements it with quic-go.
+type HTTP3 interface {
+	// ListenAndServe serves handler over QUIC on address, which is Server.Address or that of the first of
+	// Server.Listeners with TLS, until it fails.
+	ListenAndServe(address string, handler http.Handler, tlsConfig *tls.Config) error
+	// AltSvc returns the Alt-Svc header that advertises the listener to clients connecting over TCP, or "" not to.
+	AltSvc(address string) string
+}
+
+// serveHTTP3 serves over TCP and HTTP/3 until either fails.
+func (server *Server) serveHTTP3() error {
+	config, err := server.tlsConfig()
+	if err != nil {
+		return err
+	}
+	var handler http.Handler = server.router
+	if value := server.HTTP3.AltSvc(server.tlsAddress()); value != "" {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor < 3 {
+				w.Header().Set("Alt-Svc", value)
+			}
+			server.router.ServeHTTP(w, r)
+		})
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- server.HTTP3.ListenAndServe(server.tlsAddress(), server.router, config)
+	}()
+	go func() {
+		errs <- server.listenAndServe(handler)
+	}()
+	return <-errs
+}
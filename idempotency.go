@@ -0,0 +1,44 @@
+package gotcha
+
+// idempotentRef is the await that was registered with an idempotency key, and the identifier that it was registered
+// with, so that retries can be given the same Handle.
+type idempotentRef struct {
+	key        string
+	entry      *awaited
+	identifier string
+}
+
+// idempotencyKey returns the key that req's IdempotencyKey is kept under, which is scoped to its namespace, or "" if
+// it doesn't have one.
+func idempotencyKey(req AwaitRequest) string {
+	if req.IdempotencyKey == "" {
+		return ""
+	}
+	return req.Namespace + "\x00" + req.IdempotencyKey
+}
+
+// idempotent returns the Handle of the await that was registered with req's IdempotencyKey, if it's still kept. The
+// server's lock must be held.
+func (server *Server) idempotent(req AwaitRequest) (*Handle, bool) {
+	key := idempotencyKey(req)
+	if key == "" {
+		return nil, false
+	}
+	ref, ok := server.idempotencyKeys[key]
+	if entry, _ := server.awaits.get(ref.key); !ok || entry != ref.entry {
+		return nil, false
+	}
+	return &Handle{Identifier: ref.identifier, Duplicate: true, server: server, entry: ref.entry}, true
+}
+
+// rememberIdempotencyKey records that entry was registered under key with req's IdempotencyKey. The server's lock
+// must be held.
+func (server *Server) rememberIdempotencyKey(req AwaitRequest, key string, entry *awaited) {
+	if entry.idempotencyKey == "" {
+		return
+	}
+	if server.idempotencyKeys == nil {
+		server.idempotencyKeys = map[string]idempotentRef{}
+	}
+	server.idempotencyKeys[entry.idempotencyKey] = idempotentRef{key: key, entry: entry, identifier: req.Identifier}
+}
@@ -0,0 +1,43 @@
+package gotcha
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMaxIdentifierLength is how long identifiers can be when MaxIdentifierLength isn't set.
+const defaultMaxIdentifierLength = 512
+
+// validIdentifier reports whether identifier, as decoded from the request, could be one that was registered: it isn't
+// too long, is valid UTF-8, and has no path separators, control characters such as NUL, or percent signs, which only
+// turn up in identifiers that were encoded twice. It's checked before identifiers are looked up, so that crafted URLs
+// can't make routing and lookups disagree about what the identifier is.
+func (server *Server) validIdentifier(identifier string) bool {
+	limit := server.MaxIdentifierLength
+	if limit <= 0 {
+		limit = defaultMaxIdentifierLength
+	}
+	if len(identifier) > limit || !utf8.ValidString(identifier) {
+		return false
+	}
+	return !strings.ContainsFunc(identifier, func(r rune) bool {
+		return r == '/' || r == '\\' || r == '%' || unicode.IsControl(r)
+	})
+}
+
+// checkIdentifier is middleware that answers requests whose identifier isn't valid as if it were unknown, without
+// looking it up.
+func (server *Server) checkIdentifier(c *gin.Context) {
+	identifier := c.Param("identifier")
+	if identifier == "" {
+		identifier = c.Query("token")
+	}
+	if identifier == "" || server.validIdentifier(identifier) {
+		return
+	}
+	server.renderError(c, server.unknownStatus(), "invalid_identifier")
+	c.Abort()
+}
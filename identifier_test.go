@@ -0,0 +1,49 @@
+package gotcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func FuzzValidIdentifier(f *testing.F) {
+	for _, seed := range []string{
+		"abc", "user@example.com", "ünïcödé", "a b", "..", "a%2Fb", "a/b", `a\b`, "a\x00b", "\xff", "%",
+	} {
+		f.Add(seed)
+	}
+	gin.SetMode(gin.ReleaseMode)
+	server := &Server{HashKey: []byte("key"), MaxIdentifierLength: 64}
+	if err := server.parseTemplates(); err != nil {
+		f.Fatal(err)
+	}
+	var reached string
+	router := gin.New()
+	router.GET("/verify/:identifier", server.checkIdentifier, func(c *gin.Context) {
+		reached = c.Param("identifier")
+		c.Status(http.StatusOK)
+	})
+
+	f.Fuzz(func(t *testing.T, identifier string) {
+		reached = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL = &url.URL{Path: "/verify/" + identifier, RawPath: "/verify/" + url.PathEscape(identifier)}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		valid := server.validIdentifier(identifier)
+		if !valid && reached != "" {
+			t.Fatalf("rejected identifier %q reached the handler as %q", identifier, reached)
+		}
+		if valid && identifier != "" {
+			if w.Code != http.StatusOK {
+				t.Fatalf("valid identifier %q was answered with %d", identifier, w.Code)
+			}
+			if server.HashIdentifier(reached) != server.HashIdentifier(identifier) {
+				t.Fatalf("identifier %q reached the handler as %q", identifier, reached)
+			}
+		}
+	})
+}
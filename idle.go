@@ -0,0 +1,53 @@
+package gotcha
+
+import (
+	"context"
+	"time"
+)
+
+// touchNamespace records activity in namespace, for NamespaceIdle. The server's lock must be held, and resolveMu too
+// if it's only held shared.
+func (server *Server) touchNamespace(namespace string, now time.Time) {
+	if server.NamespaceIdle <= 0 {
+		return
+	}
+	if server.namespaceActive == nil {
+		server.namespaceActive = map[string]time.Time{}
+	}
+	server.namespaceActive[namespace] = now
+}
+
+// forgetIdleNamespaces forgets the state of namespaces that have no pending awaits and have been idle for
+// NamespaceIdle, returning how many it forgot.
+func (server *Server) forgetIdleNamespaces(now time.Time) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	forgotten := 0
+	for namespace, active := range server.namespaceActive {
+		if now.Sub(active) < server.NamespaceIdle || server.namespacePending[namespace] > 0 {
+			continue
+		}
+		delete(server.namespaceActive, namespace)
+		delete(server.namespaceRegistrations, namespace)
+		server.metrics.forgetNamespace(namespace)
+		server.Accounting.forget(namespace)
+		forgotten++
+	}
+	return forgotten
+}
+
+// forgetNamespaces calls forgetIdleNamespaces every half of NamespaceIdle until ctx is done.
+func (server *Server) forgetNamespaces(ctx context.Context) {
+	ticker := time.NewTicker(max(server.NamespaceIdle/2, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if forgotten := server.forgetIdleNamespaces(now); forgotten > 0 {
+				server.logger().Debug("gotcha: forgot idle namespaces", "namespaces", forgotten)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package gotcha
+
+import "net/netip"
+
+// clientKey returns what a client with ip is tracked as for blocking, rate limiting and the tarpit: ip itself for
+// IPv4, or its IPv6Prefix for IPv6, since a client can rotate through the addresses in its prefix at will. Anything
+// that isn't an address, such as a prefix that's already been worked out, is returned as is.
+func (server *Server) clientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() || addr.Is4In6() {
+		return ip
+	}
+	bits := server.IPv6Prefix
+	if bits <= 0 || bits > 128 {
+		bits = 64
+	}
+	prefix, err := addr.WithZone("").Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+// lookupClient looks up a client with ip in list, first by its address and then by its clientKey.
+func (server *Server) lookupClient(list map[string]string, ip string) (string, bool) {
+	if reason, ok := list[ip]; ok {
+		return reason, true
+	}
+	if key := server.clientKey(ip); key != ip {
+		reason, ok := list[key]
+		return reason, ok
+	}
+	return "", false
+}
@@ -0,0 +1,110 @@
+package gotcha
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// ErrUnknownKey is returned by Keyring.Open when data was sealed with a key that isn't in the keyring.
+var ErrUnknownKey = errors.New("gotcha: data was sealed with an unknown key")
+
+// Keyring encrypts data that gotcha writes to disk or to a store, since await metadata often contains email
+// addresses. Each piece of data is sealed with its own data key, which is in turn sealed with the primary key. Keys
+// can be rotated by adding a new key and making it primary; older keys are only used to open data sealed with them.
+type Keyring struct {
+	// Keys maps key IDs to 16, 24 or 32 byte AES keys.
+	Keys map[string][]byte
+	// Primary is the ID of the key that new data is sealed with.
+	Primary string
+}
+
+// keyringVersion is the first byte of sealed data, in case the format ever has to change.
+const keyringVersion = 1
+
+// Seal encrypts plaintext with a new data key, sealed with the primary key.
+func (keyring *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	primary, err := keyring.aead(keyring.Primary)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyring.Primary) > 255 {
+		return nil, errors.New("gotcha: key ID is too long")
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The format is version, key ID length, key ID, sealed data key, then the sealed plaintext.
+	sealed := append([]byte{keyringVersion, byte(len(keyring.Primary))}, keyring.Primary...)
+	header := sealed
+	if sealed, err = seal(primary, sealed, dataKey, header); err != nil {
+		return nil, err
+	}
+	return seal(data, sealed, plaintext, header)
+}
+
+// Open decrypts data returned by Seal.
+func (keyring *Keyring) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < 2 || sealed[0] != keyringVersion || len(sealed) < 2+int(sealed[1]) {
+		return nil, errors.New("gotcha: malformed sealed data")
+	}
+	header, rest := sealed[:2+int(sealed[1])], sealed[2+int(sealed[1]):]
+	primary, err := keyring.aead(string(header[2:]))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, rest, err := open(primary, rest, 32, header)
+	if err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, _, err := open(data, rest, len(rest)-data.NonceSize()-data.Overhead(), header)
+	return plaintext, err
+}
+
+func (keyring *Keyring) aead(id string) (cipher.AEAD, error) {
+	key, ok := keyring.Keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return newAEAD(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal appends a random nonce and plaintext sealed with aead to dst.
+func seal(aead cipher.AEAD, dst, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additional), nil
+}
+
+// open opens the n bytes of plaintext sealed at the start of sealed, returning what comes after them.
+func open(aead cipher.AEAD, sealed []byte, n int, additional []byte) (plaintext, rest []byte, err error) {
+	size := aead.NonceSize() + n + aead.Overhead()
+	if n < 0 || len(sealed) < size {
+		return nil, nil, errors.New("gotcha: malformed sealed data")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():size]
+	plaintext, err = aead.Open(nil, nonce, ciphertext, additional)
+	return plaintext, sealed[size:], err
+}
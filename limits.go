@@ -0,0 +1,212 @@
+package gotcha
+
+import (
+	"crypto/tls"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits caps how much load the server takes on at once, so that a traffic spike or attack can't exhaust file
+// descriptors or memory.
+type Limits struct {
+	// MaxConnections is the most connections that are open at once. Further connections wait in the listen backlog
+	// until one closes. Zero doesn't limit them. It only applies when Serve listens.
+	MaxConnections int
+	// MaxInFlight is the most verification requests that are handled at once. Requests beyond it are turned away
+	// with 503 Service Unavailable, unless they're for an await with PriorityCritical and there's room in
+	// CriticalReserve. Zero doesn't limit them.
+	MaxInFlight int
+	// CriticalReserve is how many requests for awaits with PriorityCritical can be handled beyond MaxInFlight, so that
+	// they get through while it's full. Defaults to a tenth of MaxInFlight, and at least 1.
+	CriticalReserve int
+	// MaxInFlightPerIP is the most verification requests from one IP address, or IPv6Prefix, that are handled at once,
+	// so that guesses at a code can't be made in parallel. Requests beyond it are turned away with 429 Too Many
+	// Requests, whatever the await's priority. Zero doesn't limit them.
+	MaxInFlightPerIP int
+	// RetryAfter is sent with the 503 or 429, telling clients when to try again. Defaults to 1 second.
+	RetryAfter time.Duration
+
+	once     sync.Once
+	inFlight chan struct{}
+	reserved chan struct{}
+	mu       sync.Mutex
+	perIP    map[string]int
+}
+
+// retryAfter returns the Retry-After header for requests that are turned away.
+func (limits *Limits) retryAfter() string {
+	retryAfter := limits.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
+// acquireIP counts a request from client against MaxInFlightPerIP, returning false if it's over. Requests that were
+// let in must call releaseIP once they're done.
+func (limits *Limits) acquireIP(client string) bool {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	if limits.perIP[client] >= limits.MaxInFlightPerIP {
+		return false
+	}
+	if limits.perIP == nil {
+		limits.perIP = map[string]int{}
+	}
+	limits.perIP[client]++
+	return true
+}
+
+// releaseIP uncounts a request that acquireIP let in.
+func (limits *Limits) releaseIP(client string) {
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	if limits.perIP[client]--; limits.perIP[client] <= 0 {
+		delete(limits.perIP, client)
+	}
+}
+
+// limitInFlight is middleware that turns verification requests away while Limits.MaxInFlight are being handled, or
+// Limits.MaxInFlightPerIP from the client.
+func (server *Server) limitInFlight(c *gin.Context) {
+	limits := server.Limits
+	if limits == nil {
+		return
+	}
+	// Forwarded requests were already counted against the client by the instance that received them.
+	if limits.MaxInFlightPerIP > 0 && !isForwarded(c) {
+		client := server.clientKey(server.clientIP(c))
+		if !limits.acquireIP(client) {
+			server.metrics.observeOverloaded()
+			c.Header("Retry-After", limits.retryAfter())
+			server.renderError(c, http.StatusTooManyRequests, "too_many_in_flight")
+			c.Abort()
+			return
+		}
+		defer limits.releaseIP(client)
+	}
+	if limits.MaxInFlight <= 0 {
+		c.Next()
+		return
+	}
+	limits.once.Do(func() {
+		limits.inFlight = make(chan struct{}, limits.MaxInFlight)
+		reserve := limits.CriticalReserve
+		if reserve <= 0 {
+			reserve = max(limits.MaxInFlight/10, 1)
+		}
+		limits.reserved = make(chan struct{}, reserve)
+	})
+	select {
+	case limits.inFlight <- struct{}{}:
+		defer func() { <-limits.inFlight }()
+		c.Next()
+		return
+	default:
+	}
+	if server.critical(c) {
+		select {
+		case limits.reserved <- struct{}{}:
+			defer func() { <-limits.reserved }()
+			c.Next()
+			return
+		default:
+		}
+	}
+	server.metrics.observeOverloaded()
+	c.Header("Retry-After", limits.retryAfter())
+	server.renderError(c, http.StatusServiceUnavailable, "overloaded")
+	c.Abort()
+}
+
+// listenAndServe serves handler on Address, or on the socket passed by Upgrade or by systemd socket activation,
+// limiting connections to Limits.MaxConnections. It serves on Listeners instead if they're set.
+func (server *Server) listenAndServe(handler http.Handler) error {
+	if len(server.Listeners) > 0 {
+		return server.serveListeners(handler)
+	}
+	var tlsConfig *tls.Config
+	if server.usesTLS() {
+		var err error
+		if tlsConfig, err = server.tlsConfig(); err != nil {
+			return err
+		}
+	}
+	address := server.Address
+	if address == "" {
+		// Match gin's default.
+		address = ":8080"
+		if port := os.Getenv("PORT"); port != "" {
+			address = ":" + port
+		}
+	}
+	listener, err := server.upgradeListener()
+	if err == nil && listener == nil {
+		listener, err = systemdListener()
+	}
+	if err == nil && listener == nil {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	transport := server.Transport
+	if transport == nil {
+		transport = netHTTP{server: &http.Server{Addr: address}}
+	}
+	server.mu.Lock()
+	server.listener, server.transport = listener, transport
+	server.mu.Unlock()
+	if server.Limits != nil && server.Limits.MaxConnections > 0 {
+		listener = &limitListener{Listener: listener, slots: make(chan struct{}, server.Limits.MaxConnections),
+			closed: make(chan struct{})}
+	}
+	return transport.Serve(listener, handler, tlsConfig)
+}
+
+// limitListener is a net.Listener that only has as many connections open at once as slots holds. Accept waits for a
+// slot until the listener's closed, so that shutting down isn't held up by a full server.
+type limitListener struct {
+	net.Listener
+	slots  chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (listener *limitListener) Accept() (net.Conn, error) {
+	select {
+	case listener.slots <- struct{}{}:
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		<-listener.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: func() { <-listener.slots }}, nil
+}
+
+func (listener *limitListener) Close() error {
+	listener.once.Do(func() { close(listener.closed) })
+	return listener.Listener.Close()
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (conn *limitConn) Close() error {
+	err := conn.Conn.Close()
+	conn.once.Do(conn.release)
+	return err
+}
@@ -0,0 +1,43 @@
+package gotcha
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestLimitListenerClose checks that closing a limitListener returns from an Accept that's waiting for a slot.
+func TestLimitListenerClose(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &limitListener{Listener: inner, slots: make(chan struct{}, 1), closed: make(chan struct{})}
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		accepted <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	listener.Close()
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept returned %v, want %v", err, net.ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept didn't return once the listener was closed")
+	}
+}
@@ -0,0 +1,135 @@
+package gotcha
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Listener is an address that the server listens on. See Server.Listeners.
+type Listener struct {
+	// Address is the address to listen on, such as "0.0.0.0:80" or "[::]:443". An IPv6 address only listens on IPv6,
+	// so that it can share its port with an IPv4 one.
+	Address string
+	// TLS serves HTTPS on the address, with the server's TLS configuration. It's served over plain HTTP otherwise.
+	TLS bool
+	// RedirectHTTPS, without TLS, redirects every request to the same URL over HTTPS instead of serving it, on the port
+	// of the first Listener with TLS.
+	RedirectHTTPS bool
+}
+
+// serveListeners serves handler on each of Listeners until one of them fails, then closes the others.
+func (server *Server) serveListeners(handler http.Handler) error {
+	if server.Transport != nil {
+		return errors.New("gotcha: Transport can't be used with Listeners")
+	}
+	var tlsConfig *tls.Config
+	for _, l := range server.Listeners {
+		if l.TLS {
+			var err error
+			if tlsConfig, err = server.tlsConfig(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	listeners := make([]net.Listener, 0, len(server.Listeners))
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+	for _, l := range server.Listeners {
+		listener, err := net.Listen(listenNetwork(l.Address), l.Address)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	// MaxConnections is shared between the listeners.
+	var slots chan struct{}
+	if server.Limits != nil && server.Limits.MaxConnections > 0 {
+		slots = make(chan struct{}, server.Limits.MaxConnections)
+	}
+	errs := make(chan error, len(listeners))
+	for i, l := range server.Listeners {
+		listener, serve, config := listeners[i], handler, (*tls.Config)(nil)
+		if slots != nil {
+			listener = &limitListener{Listener: listener, slots: slots}
+		}
+		if l.TLS {
+			config = tlsConfig
+		} else if l.RedirectHTTPS {
+			serve = server.redirectHTTPS()
+		}
+		transport := netHTTP{server: &http.Server{Addr: l.Address}}
+		go func() {
+			errs <- transport.Serve(listener, serve, config)
+		}()
+	}
+	err := <-errs
+	closeAll()
+	return err
+}
+
+// listenNetwork returns the network to listen on address with: tcp6 for IPv6 addresses, so that they don't also take
+// the port on IPv4, tcp4 for IPv4 ones, and tcp otherwise.
+func listenNetwork(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() == nil:
+		return "tcp6"
+	default:
+		return "tcp4"
+	}
+}
+
+// tlsAddress returns the address that HTTPS is served on: that of the first of Listeners with TLS, or Address.
+func (server *Server) tlsAddress() string {
+	for _, l := range server.Listeners {
+		if l.TLS {
+			return l.Address
+		}
+	}
+	return server.Address
+}
+
+// redirectHTTPS returns a handler that redirects requests to the same URL over HTTPS.
+func (server *Server) redirectHTTPS() http.Handler {
+	port := ""
+	if _, p, err := net.SplitHostPort(server.tlsAddress()); err == nil && p != "443" {
+		port = p
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if host == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			// Keep the method and body, which a 301 doesn't.
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,112 @@
+package gotcha
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// liveBuffer is how many events can be waiting for a slow WebSocket client before it's disconnected.
+const liveBuffer = 256
+
+var liveUpgrader = websocket.Upgrader{}
+
+// watch subscribes to every event from now on, returning a channel of them and the pending awaits as of subscribing,
+// so that nothing is missed in between. The channel is closed if the subscriber falls behind. The server's lock must
+// be held.
+func (server *Server) watch() (chan Event, []Event) {
+	events := make(chan Event, liveBuffer)
+	server.watchMu.Lock()
+	if server.watchers == nil {
+		server.watchers = map[chan Event]struct{}{}
+	}
+	server.watchers[events] = struct{}{}
+	server.watchMu.Unlock()
+
+	pending := []Event{}
+	server.awaits.each(func(key string, entry *awaited) bool {
+		if entry.pending() {
+			pending = append(pending, Event{Type: EventRegistered, Identifier: key, Namespace: entry.namespace, Time: entry.start})
+		}
+		return true
+	})
+	return events, pending
+}
+
+// unwatch unsubscribes events.
+func (server *Server) unwatch(events chan Event) {
+	server.watchMu.Lock()
+	defer server.watchMu.Unlock()
+	if _, ok := server.watchers[events]; ok {
+		delete(server.watchers, events)
+		close(events)
+	}
+}
+
+// broadcast passes event to the watchers, disconnecting any that have fallen behind.
+func (server *Server) broadcast(event Event) {
+	server.watchMu.Lock()
+	defer server.watchMu.Unlock()
+	for events := range server.watchers {
+		select {
+		case events <- event:
+		default:
+			delete(server.watchers, events)
+			close(events)
+		}
+	}
+}
+
+// live handles GET /admin/live, streaming events to a WebSocket client as JSON, starting with a registered event for
+// each await that's already pending.
+func (server *Server) live(c *gin.Context) {
+	conn, err := liveUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	server.mu.Lock()
+	events, pending := server.watch()
+	server.mu.Unlock()
+	defer server.unwatch(events)
+
+	// The client isn't expected to send anything, but reading notices when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, event := range pending {
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+	}
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gotcha
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logger returns Logger, or a logger that discards everything if it isn't set.
+func (server *Server) logger() *slog.Logger {
+	if server.Logger == nil {
+		return slog.New(discardHandler{})
+	}
+	return server.Logger
+}
+
+// discardHandler is a slog.Handler that discards everything, like slog.DiscardHandler, which needs a later Go.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool   { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error  { return nil }
+func (handler discardHandler) WithAttrs([]slog.Attr) slog.Handler { return handler }
+func (handler discardHandler) WithGroup(string) slog.Handler      { return handler }
+
+// handleError passes err to ErrorHandler, if there is one, and reports whether it responded to c. c is nil for failures
+// in the background.
+func (server *Server) handleError(c *gin.Context, err error) bool {
+	if server.ErrorHandler == nil {
+		return false
+	}
+	server.ErrorHandler(c, err)
+	return c != nil && c.Writer.Written()
+}
+
+// LogSampling thins out the logs of verification attempts, so that a busy server doesn't flood its log pipeline. Each
+// kind of attempt is logged one time in N, chosen at random.
+type LogSampling struct {
+	// Successes is N for attempts that succeeded. Defaults to 1, logging all of them.
+	Successes int
+	// Failures is N for attempts that failed, such as with a wrong code or from a blocked client. Defaults to 1.
+	Failures int
+}
+
+// sampled reports whether an attempt that succeeded or not should be logged.
+func (sampling *LogSampling) sampled(success bool) bool {
+	if sampling == nil {
+		return true
+	}
+	n := sampling.Failures
+	if success {
+		n = sampling.Successes
+	}
+	return n <= 1 || rand.IntN(n) == 0
+}
+
+// logAttempt logs the outcome of a verification attempt for key. Failures are logged as warnings and errors as
+// errors, so that successes can be filtered out by level.
+func (server *Server) logAttempt(c *gin.Context, key string, result outcome) {
+	level := slog.LevelInfo
+	switch {
+	case result.status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case result.status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+	code := result.code
+	if result.shadowBanned {
+		level, code = slog.LevelWarn, "shadow_banned"
+	}
+	if !server.LogSampling.sampled(level == slog.LevelInfo) {
+		return
+	}
+	server.logger().LogAttrs(context.Background(), level, "gotcha: verify",
+		slog.String("identifier", key),
+		slog.String("outcome", code),
+		slog.Int("status", result.status),
+		slog.String("method", c.Request.Method),
+		slog.String("client_ip", server.clientIP(c)),
+		slog.String("request_id", requestIDOf(c)),
+	)
+}
@@ -0,0 +1,190 @@
+// Package login implements passwordless login with magic links on top of gotcha. Users enter their email address,
+// are sent a link, and land in a session once they follow it.
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/fjah/gotcha"
+)
+
+// csrfCookie is the name of the cookie that holds the form's CSRF token.
+const csrfCookie = "gotcha_csrf"
+
+// Login serves the login form at GET and sends magic links at POST. Mount it on the application's own server; the
+// links point at Server, which sets the session cookie and redirects back.
+type Login struct {
+	// Server verifies the links. New sets up its Session if it doesn't have one.
+	Server *gotcha.Server
+	// Send delivers link to email, usually by email. The request waits for it.
+	Send func(ctx context.Context, email, link string) error
+	// Namespace is set on the awaits that Login registers. Defaults to "login".
+	Namespace string
+	// Insecure lets the CSRF cookie be sent over plain HTTP, for local development.
+	Insecure bool
+}
+
+// New returns a Login for server. If server doesn't have a Session, it gets one with a random key, so sessions don't
+// survive restarts, that redirects to "/" after logging in.
+func New(server *gotcha.Server, send func(ctx context.Context, email, link string) error) (*Login, error) {
+	if server.Session == nil {
+		key, err := random()
+		if err != nil {
+			return nil, err
+		}
+		server.Session = &gotcha.SessionCookie{Key: []byte(key), Redirect: "/"}
+	}
+	return &Login{Server: server, Send: send}, nil
+}
+
+// Email returns the email address of the user logged in with r, if there is one.
+func (login *Login) Email(r *http.Request) (string, bool) {
+	if login.Server.Session == nil {
+		return "", false
+	}
+	session, err := login.Server.Session.Read(r)
+	if err != nil || session.Namespace != login.namespace() {
+		return "", false
+	}
+	email, ok := session.Metadata[gotcha.MetadataEmail]
+	return email, ok
+}
+
+func (login *Login) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		token, err := random()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookie,
+			Value:    token,
+			Path:     r.URL.Path,
+			Secure:   !login.Insecure,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		login.render(w, http.StatusOK, page{Theme: login.Server.Theme, CSRF: token})
+	case http.MethodPost:
+		login.request(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// request handles a submitted login form.
+func (login *Login) request(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue("csrf"))) != 1 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	if !strings.Contains(email, "@") {
+		login.render(w, http.StatusBadRequest, page{Theme: login.Server.Theme, CSRF: cookie.Value,
+			Error: "Enter a valid email address."})
+		return
+	}
+
+	identifier, err := random()
+	if err == nil {
+		_, err = login.Server.Register(gotcha.AwaitRequest{
+			Identifier: identifier,
+			Namespace:  login.namespace(),
+			Metadata:   map[string]string{gotcha.MetadataEmail: email},
+		})
+	}
+	if err == nil {
+		err = login.Send(r.Context(), email, login.Server.VerifyURL(identifier))
+	}
+	if errors.Is(err, gotcha.ErrAtCapacity) || errors.Is(err, gotcha.ErrUnavailable) {
+		w.Header().Set("Retry-After", "30")
+		login.render(w, http.StatusServiceUnavailable, page{Theme: login.Server.Theme, CSRF: cookie.Value,
+			Error: "Sign-in is busy right now. Try again in a moment."})
+		return
+	}
+	if err != nil {
+		login.Server.Cancel(identifier)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	login.render(w, http.StatusOK, page{Theme: login.Server.Theme, Sent: email})
+}
+
+func (login *Login) namespace() string {
+	if login.Namespace == "" {
+		return "login"
+	}
+	return login.Namespace
+}
+
+func (login *Login) render(w http.ResponseWriter, status int, data page) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	form.Execute(w, data)
+}
+
+// random returns a random, URL-safe token.
+func random() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", errors.New("login: couldn't generate a token")
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+type page struct {
+	Theme gotcha.Theme
+	CSRF  string
+	Error string
+	// Sent is the address that a link was sent to.
+	Sent string
+}
+
+var form = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in{{with .Theme.ProductName}} · {{.}}{{end}}</title>
+<style>
+body{margin:0;min-height:100vh;display:flex;align-items:center;justify-content:center;background:#f5f5f7;color:#1d1d1f;font:16px/1.5 -apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif}
+main{box-sizing:border-box;width:100%;max-width:26rem;margin:1rem;padding:2.5rem 2rem;background:#fff;border-radius:12px;box-shadow:0 1px 3px rgba(0,0,0,.08),0 8px 24px rgba(0,0,0,.06);text-align:center}
+img{max-height:48px;margin-bottom:1.5rem}
+h1{margin:0 0 .5rem;font-size:1.4rem;color:{{.Theme.Accent}}}
+p{margin:0;color:#515154}
+form{margin-top:1.5rem}
+input{box-sizing:border-box;width:100%;padding:.7rem;font:inherit;border:1px solid #d2d2d7;border-radius:8px}
+button{margin-top:1rem;padding:.7rem 1.5rem;font:inherit;color:#fff;background:{{.Theme.Accent}};border:0;border-radius:8px;cursor:pointer}
+</style>
+</head>
+<body>
+<main>
+{{with .Theme.LogoURL}}<img src="{{.}}" alt="">{{end}}
+{{if .Sent}}
+<h1>Check your email</h1>
+<p>We've sent a link to {{.Sent}}. Follow it to log in.</p>
+{{else}}
+<h1>Log in</h1>
+<p>{{with .Error}}{{.}}{{else}}Enter your email address and we'll send you a link.{{end}}</p>
+<form method="post">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input type="email" name="email" aria-label="Email address" autocomplete="email" autofocus required>
+<button type="submit">Send link</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mailgun is a Sender that uses the Mailgun messages API.
+type Mailgun struct {
+	// Domain is the sending domain configured in Mailgun.
+	Domain string
+	// APIKey is a Mailgun API key.
+	APIKey string
+	// From is the sender's address, e.g. "Example <noreply@example.com>".
+	From string
+	// Client sends requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// APIURL is the base URL of the API. Defaults to https://api.mailgun.net; EU domains use https://api.eu.mailgun.net.
+	APIURL string
+}
+
+// Send implements Sender. The ID is Mailgun's message ID.
+func (sender *Mailgun) Send(ctx context.Context, message Message) (string, error) {
+	form := url.Values{
+		"from":    {sender.From},
+		"to":      {message.To},
+		"subject": {message.Subject},
+		"html":    {message.HTML},
+	}
+	if message.Text != "" {
+		form.Set("text", message.Text)
+	}
+
+	apiURL := sender.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.mailgun.net"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/v3/"+url.PathEscape(sender.Domain)+"/messages",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("api", sender.APIKey)
+	resp, err := do(sender.Client, req, "Mailgun")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
@@ -0,0 +1,126 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/fjah/gotcha"
+)
+
+// Sender sends emails.
+type Sender interface {
+	// Send sends message, returning the ID that the provider gave it, if there is one.
+	Send(ctx context.Context, message Message) (id string, err error)
+}
+
+// Dispatch returns a function for gotcha.Dispatcher.Send that renders email deliveries with template and sends them
+// with sender, passing on the provider's message ID for gotcha.Dispatcher.Sent. Deliveries for other channels are
+// ignored.
+func Dispatch(template *Template, sender Sender) func(ctx context.Context, delivery gotcha.Delivery) (string, error) {
+	return func(ctx context.Context, delivery gotcha.Delivery) (string, error) {
+		if delivery.Channel != "" && delivery.Channel != "email" {
+			return "", nil
+		}
+		message, err := template.RenderDelivery(delivery)
+		if err != nil {
+			return "", err
+		}
+		return sender.Send(ctx, message)
+	}
+}
+
+// SMTP is a Sender that sends through an SMTP server.
+type SMTP struct {
+	// Addr is the address of the server, e.g. "smtp.example.com:587". STARTTLS is used if the server supports it.
+	Addr string
+	// Auth, if set, authenticates with the server, e.g. smtp.PlainAuth.
+	Auth smtp.Auth
+	// From is the sender's address, e.g. "Example <noreply@example.com>".
+	From string
+}
+
+// Send implements Sender. The ID is the Message-ID header that was generated for the message.
+func (sender *SMTP) Send(ctx context.Context, message Message) (string, error) {
+	from, err := mail.ParseAddress(sender.From)
+	if err != nil {
+		return "", err
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return "", err
+	}
+	id, body, err := sender.encode(from, to, message)
+	if err != nil {
+		return "", err
+	}
+	// net/smtp doesn't take a context, so ctx is only checked before sending.
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return id, smtp.SendMail(sender.Addr, sender.Auth, from.Address, []string{to.Address}, body)
+}
+
+// encode builds the MIME message, with HTML and plain text alternatives if there's text.
+func (sender *SMTP) encode(from, to *mail.Address, message Message) (string, []byte, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	id := "<" + hex.EncodeToString(random) + "@" + domain + ">"
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", id)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if message.Text == "" {
+		buf.WriteString("Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err := writeQuotedPrintable(&buf, message.HTML)
+		return id, buf.Bytes(), err
+	}
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return "", nil, err
+	}
+	return id, buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
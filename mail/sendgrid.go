@@ -0,0 +1,89 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+)
+
+// SendGrid is a Sender that uses the SendGrid v3 Mail Send API.
+type SendGrid struct {
+	// APIKey is a SendGrid API key with the Mail Send permission.
+	APIKey string
+	// From is the sender's address, e.g. "Example <noreply@example.com>".
+	From string
+	// Client sends requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// APIURL is the base URL of the API. Defaults to https://api.sendgrid.com.
+	APIURL string
+}
+
+// Send implements Sender. The ID is SendGrid's X-Message-Id.
+func (sender *SendGrid) Send(ctx context.Context, message Message) (string, error) {
+	from, err := mail.ParseAddress(sender.From)
+	if err != nil {
+		return "", err
+	}
+	content := []map[string]string{}
+	if message.Text != "" {
+		content = append(content, map[string]string{"type": "text/plain", "value": message.Text})
+	}
+	content = append(content, map[string]string{"type": "text/html", "value": message.HTML})
+	body := map[string]interface{}{
+		"personalizations": []interface{}{map[string]interface{}{"to": []map[string]string{{"email": message.To}}}},
+		"from":             map[string]string{"email": from.Address, "name": from.Name},
+		"subject":          message.Subject,
+		"content":          content,
+	}
+
+	apiURL := sender.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.sendgrid.com"
+	}
+	req, err := newJSONRequest(ctx, apiURL+"/v3/mail/send", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+sender.APIKey)
+	resp, err := do(sender.Client, req, "SendGrid")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("X-Message-Id"), nil
+}
+
+// newJSONRequest returns a POST request to url with body encoded as JSON.
+func newJSONRequest(ctx context.Context, url string, body interface{}) (*http.Request, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// do sends req with client, turning unsuccessful responses into errors. The caller must close the response's body.
+func do(client *http.Client, req *http.Request, provider string) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("mail: %s responded %s: %s", provider, resp.Status, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
@@ -0,0 +1,142 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SES is a Sender that uses the Amazon SES v2 API, authenticating with static credentials.
+type SES struct {
+	// Region is the AWS region, e.g. "eu-west-1".
+	Region string
+	// AccessKeyID and SecretAccessKey are the credentials to sign requests with. SessionToken is needed as well for
+	// temporary credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// From is the sender's address, e.g. "Example <noreply@example.com>".
+	From string
+	// ConfigurationSet, if set, is the SES configuration set to send with, e.g. one that publishes bounces.
+	ConfigurationSet string
+	// Client sends requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// APIURL overrides the regional endpoint.
+	APIURL string
+}
+
+// Send implements Sender. The ID is SES's MessageId.
+func (sender *SES) Send(ctx context.Context, message Message) (string, error) {
+	body := map[string]interface{}{
+		"FromEmailAddress": sender.From,
+		"Destination":      map[string]interface{}{"ToAddresses": []string{message.To}},
+		"Content": map[string]interface{}{
+			"Simple": map[string]interface{}{
+				"Subject": map[string]string{"Data": message.Subject, "Charset": "UTF-8"},
+				"Body":    sesBody(message),
+			},
+		},
+	}
+	if sender.ConfigurationSet != "" {
+		body["ConfigurationSetName"] = sender.ConfigurationSet
+	}
+
+	apiURL := sender.APIURL
+	if apiURL == "" {
+		apiURL = "https://email." + sender.Region + ".amazonaws.com"
+	}
+	req, err := newJSONRequest(ctx, apiURL+"/v2/email/outbound-emails", body)
+	if err != nil {
+		return "", err
+	}
+	if err := sender.sign(req, time.Now().UTC()); err != nil {
+		return "", err
+	}
+	resp, err := do(sender.Client, req, "SES")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		MessageID string `json:"MessageId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.MessageID, nil
+}
+
+func sesBody(message Message) map[string]interface{} {
+	body := map[string]interface{}{"Html": map[string]string{"Data": message.HTML, "Charset": "UTF-8"}}
+	if message.Text != "" {
+		body["Text"] = map[string]string{"Data": message.Text, "Charset": "UTF-8"}
+	}
+	return body
+}
+
+// sign signs req with AWS Signature Version 4.
+func (sender *SES) sign(req *http.Request, now time.Time) error {
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+
+	date, timestamp := now.Format("20060102"), now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sender.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sender.SessionToken)
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if sender.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + sender.Region + "/ses/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := []byte("AWS4" + sender.SecretAccessKey)
+	for _, part := range []string{date, sender.Region, "ses", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+sender.AccessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
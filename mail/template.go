@@ -0,0 +1,89 @@
+// Package mail renders and sends verification emails.
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/fjah/gotcha"
+)
+
+// Template renders verification emails. Each part is executed with Data.
+type Template struct {
+	Subject *texttemplate.Template
+	HTML    *htmltemplate.Template
+	// Text is the plain text alternative. It's optional, but spam filters like it.
+	Text *texttemplate.Template
+}
+
+// Data is what templates are executed with.
+type Data struct {
+	// To is the recipient's address.
+	To string
+	// URL is the verification link.
+	URL string
+	// Expires is when the link stops working.
+	Expires time.Time
+	// Metadata is Delivery.Data.
+	Metadata map[string]string
+}
+
+// Message is a rendered email.
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Parse parses the parts of a Template. text can be empty.
+func Parse(subject, html, text string) (*Template, error) {
+	t := &Template{}
+	var err error
+	if t.Subject, err = texttemplate.New("subject").Parse(subject); err != nil {
+		return nil, err
+	}
+	if t.HTML, err = htmltemplate.New("html").Parse(html); err != nil {
+		return nil, err
+	}
+	if text != "" {
+		if t.Text, err = texttemplate.New("text").Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Render renders the email for data.
+func (t *Template) Render(data Data) (Message, error) {
+	message := Message{To: data.To}
+	var buf bytes.Buffer
+	if err := t.Subject.Execute(&buf, data); err != nil {
+		return Message{}, err
+	}
+	// Newlines in a subject would let it inject headers.
+	message.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	if err := t.HTML.Execute(&buf, data); err != nil {
+		return Message{}, err
+	}
+	message.HTML = buf.String()
+
+	if t.Text != nil {
+		buf.Reset()
+		if err := t.Text.Execute(&buf, data); err != nil {
+			return Message{}, err
+		}
+		message.Text = buf.String()
+	}
+	return message, nil
+}
+
+// RenderDelivery renders the email for delivery.
+func (t *Template) RenderDelivery(delivery gotcha.Delivery) (Message, error) {
+	return t.Render(Data{To: delivery.To, URL: delivery.URL, Expires: delivery.Expires, Metadata: delivery.Data})
+}
@@ -1,98 +1,566 @@
 package gotcha
 
 import (
+	"context"
+	"crypto/tls"
+	"html/template"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Statuses returned by Await.
+const (
+	// StatusVerified means the request was fulfilled.
+	StatusVerified = iota
+	// StatusExpired means Timeout elapsed before the request was fulfilled.
+	StatusExpired
+	// StatusBlocked means the request came from a blocked client.
+	StatusBlocked
+	// StatusCancelled means the await was cancelled with Cancel.
+	StatusCancelled
+	// StatusDenied means the request was denied with Deny, e.g. by an approver in chat.
+	StatusDenied
+	// StatusUndeliverable means the link couldn't be delivered, e.g. because the email bounced.
+	StatusUndeliverable
+	// StatusLocked means there were too many failed attempts to verify the await. See AwaitRequest.MaxAttempts.
+	StatusLocked
+	// StatusUnavailable means the await couldn't be registered, because MaxPending awaits were pending, Health
+	// failed or the server was draining.
+	StatusUnavailable
+	// StatusEvicted means the await was shed to make room for one with a higher Priority while MaxPending awaits were
+	// pending.
+	StatusEvicted
+)
+
+// Decision is what a Verifier decides to do with a request. The zero value lets it through.
+type Decision struct {
+	// Reject rejects the request. The await stays pending, so the client can try again.
+	Reject bool
+	// Block rejects the request and resolves the await with StatusBlocked.
+	Block bool
+	// Status is the HTTP status to reject the request with. Defaults to 403.
+	Status int
+	// Reason is shown to the client if the request is rejected.
+	Reason string
+}
+
 // Server is a Gotcha instance.
 type Server struct {
-	// Address is the address to listen on.
+	// Address is the address to listen on. If the process was started by systemd socket activation, the socket that
+	// systemd passed is used instead.
 	Address string
+	// Listeners, if set, are listened on in place of Address, such as a plain HTTP listener on port 80 that redirects
+	// to HTTPS on 443, or the same port on IPv4 and IPv6. They're served with net/http, rather than Transport, and
+	// sockets from systemd or Upgrade aren't used with them.
+	Listeners []Listener
 	// Timeout is the maximum time that a client has to send a request.
 	Timeout time.Duration
-	// Render is called when a response is about to be returned. It can be used to return styled HTML responses.
-	Render func(c *gin.Context, status int, body map[string]string)
-	// BlockList is a map of IP addresses to reasons. Clients can see these reasons if they're blocked.
+	// ErrorHandler, if set, is called when something fails that isn't the client's fault: the Verifier, issuing a token
+	// or exchange code, an event sink, saving a snapshot, or rendering a response. c is the request that was being
+	// handled, or nil if the failure was in the background. It can respond to c in place of the default response, such
+	// as with a friendly 500 page or a 503 to retry, or alert someone. Errors are logged either way.
+	ErrorHandler func(c *gin.Context, err error)
+	// Renderer renders responses to verification requests. It can be used to return styled HTML responses. Defaults to
+	// DefaultRenderer, which shows browsers pages styled with Theme and gives other clients JSON.
+	Renderer Renderer
+	// Serializers are offered to API clients by content negotiation, alongside JSON, which remains the default. See
+	// MessagePack and Protobuf.
+	Serializers []Serializer
+	// Theme customises the default HTML pages.
+	Theme Theme
+	// TemplateDir, if set, is a directory of templates, such as result.html, that replace the built-in ones with the
+	// same names. See templates/ for what they're executed with.
+	TemplateDir string
+	// BlockList is a map of IP addresses to reasons. Clients can see these reasons if they're blocked. IPv6 clients
+	// are also looked up by their IPv6Prefix, e.g. "2001:db8::/64". Reasons, here and everywhere else, can be
+	// templates of a BlockReason.
 	BlockList map[string]string
+	// Resend, if set, enables /resend/:identifier, which asks the application to send an await again, and offers it
+	// on the page shown for an expired link.
+	Resend *Resend
+	// Reissue, if set, replaces awaits whose links are opened after they've expired, sending the user a new link.
+	Reissue *Reissue
+	// Appeals, if set, enables /appeal, where blocked clients can ask to be unblocked.
+	Appeals *Appeals
+	// Support is how blocked clients can get in touch, such as an email address, for block reasons to include as
+	// {{.Support}}.
+	Support string
+	// IPv6Prefix is the length of the prefix that IPv6 clients are blocked, rate limited and tarpitted by, since a
+	// client can usually use any address in its prefix. Defaults to 64; 128 treats each address separately.
+	IPv6Prefix int
+	// TrustedProxies are the addresses, or prefixes such as "10.0.0.0/8", of the proxies in front of the server. A
+	// request's client is the address that it came from, unless that's one of these, in which case it's taken from
+	// ClientIPHeader. By default no proxy is trusted, so that clients can't claim another address to get around
+	// allow lists, blocklists, rate limits and the tarpit, or to have someone else blocked.
+	TrustedProxies []string
+	// ClientIPHeader is the header that TrustedProxies pass the client's address in. Defaults to X-Forwarded-For,
+	// whose last address that isn't one of TrustedProxies is taken.
+	ClientIPHeader string
+	// RemoteBlockLists are fetched and refreshed by Serve, and checked alongside BlockList. See NewTorBlockList.
+	RemoteBlockLists []*RemoteBlockList
+	// DNSBL, if set, checks clients against DNS-based blocklists.
+	DNSBL *DNSBL
+	// ASN, if set, blocks or flags clients by the autonomous system, such as a hosting provider, that they're in.
+	ASN *ASNFilter
+	// ShadowBan, if set, hides blocks from blocked clients. See ShadowBanMode.
+	ShadowBan ShadowBanMode
+	// Tarpit, if set, delays responses to clients and identifiers that keep failing to verify.
+	Tarpit *Tarpit
+	// RateLimiter, if set, limits how often each client can make verification requests. See NewRateLimiter.
+	RateLimiter RateLimiter
+	// Limits, if set, caps concurrent connections and verification requests.
+	Limits *Limits
+	// MaxPending, if set, is the most awaits that can be pending at once. Registering more fails with ErrAtCapacity,
+	// unless there are pending awaits with a lower Priority, the oldest of which are evicted to make room.
+	MaxPending int
+	// Quotas caps the awaits that each namespace can have pending and register per hour, so that one tenant can't
+	// starve the others. Registering beyond a quota fails with ErrOverQuota. DefaultQuota applies to namespaces that
+	// aren't in Quotas. See Usage.
+	Quotas       map[string]Quota
+	DefaultQuota Quota
+	// RegistrationLimiter, if set, limits how fast awaits can be registered, keyed by their Namespace. Registering
+	// faster fails with a *RegistrationLimitError, so that a misbehaving or compromised caller can't flood the server
+	// with awaits. Registration goes ahead if the limiter fails.
+	RegistrationLimiter RateLimiter
+	// NamespaceIdle, if set, is how long a namespace can go without awaits being registered or resolved in it before
+	// its state is forgotten, so that servers with short-lived namespaces, such as one per tenant, don't keep growing:
+	// its hourly registrations, quota rejection metrics and Accounting totals. Namespaces with pending awaits aren't
+	// forgotten, and nor are the usage since the last Accounting export and Analytics rollups, which expire by Days.
+	NamespaceIdle time.Duration
+	// Health, if set, is called before an await is registered. If it returns an error, e.g. because a backend that the
+	// await depends on is down, registration fails with ErrUnavailable rather than accepting work that can't be served.
+	Health func() error
+	// Cluster, if set, lets instances resolve each other's awaits. See gotcharedis.Cluster.
+	Cluster Cluster
+	// Retention is how long resolved awaits are kept for, so that Peek, /wait and repeated requests can still see how
+	// they resolved. Defaults to Timeout. See PurgeIdentifier and PurgeBefore for deleting them sooner.
+	Retention time.Duration
+	// ReplayWindow, if set, is how long after an await is verified that requests for it are answered with 409 Conflict
+	// and "already_used", and emit EventReplayed, rather than being treated as unknown, such as when users click the
+	// link in an email twice. Only the hashed identifiers are kept for it, whatever Retention is.
+	ReplayWindow time.Duration
+	// Confirm makes GET /verify/:identifier show a page with a button that confirms the request, rather than verifying
+	// straight away, so that link scanners and prefetchers can't consume awaits. Signed links aren't affected. The
+	// page's form carries a single-use nonce, which custom confirm.html templates must post as "nonce", so that a
+	// captured confirmation can't be replayed.
+	Confirm bool
+	// Prefetch, if set, shows the confirmation page only to requests that look like they're from link scanners and
+	// prefetchers, verifying the rest straight away.
+	Prefetch *Prefetch
+	// CSRF protects the built-in confirmation, device and appeal forms with a double-submit token: the pages set
+	// CSRFCookie, and posts whose "csrf_token" field doesn't match it are rejected with 403 Forbidden. Custom templates
+	// must post {{.CSRF}} as "csrf_token". JSON requests aren't checked.
+	CSRF bool
+	// WebAuthn, if set, lets awaits require a passkey on the confirmation page. See MetadataWebAuthnCredentials.
+	WebAuthn *WebAuthn
+	// TOTP enables POST /verify/:identifier/totp, where awaits can be verified with a code from an authenticator app
+	// instead. See MetadataTOTPSecret.
+	TOTP bool
+	// Fallback, if set, is called when a client tries to verify an identifier that isn't registered, such as a token
+	// minted by a legacy system that gotcha is replacing. If it returns an AwaitRequest, that's registered for the
+	// identifier, in the namespace of the request's Tenant if it has one, and the request is verified against it. It's
+	// called again once the await is no longer retained, so it should stop returning tokens once they've been used.
+	// With a Cluster, it's called before the request is forwarded, so it should only return awaits that no instance
+	// has.
+	Fallback func(c *gin.Context, identifier string) (*AwaitRequest, error)
+	// Verifier, if set, is called before an await is fulfilled, once the client has passed every other check. It can
+	// enforce extra conditions, such as a session cookie being present or the client having the IP that asked for the
+	// await, and reject the request. If it returns an error, the client gets a 500 and the await stays pending.
+	Verifier func(ctx *gin.Context, pending PendingAwait) (Decision, error)
+	// Precondition, if set, is checked after Verifier, with a timeout, to make sure that the await is still wanted.
+	Precondition *Precondition
+	// MaxIdentifierLength is the longest identifier that's looked up. Requests with longer ones, or with path
+	// separators, control characters or percent signs in them once they're decoded, are answered as if the identifier
+	// were unknown. Defaults to 512 bytes.
+	MaxIdentifierLength int
+	// DryRun, if set, lets load tests verify synthetic identifiers without consuming real awaits.
+	DryRun *DryRun
+	// Fingerprint, if set, returns a fingerprint of the verifying device, e.g. from a header that the app's client sets
+	// or from FingerprintCookie. It's passed on in PendingAwait and Result, so that it can be compared with the device
+	// that asked for the await. See CookieFingerprint.
+	Fingerprint func(c *gin.Context) string
+	// Token, if set, makes successful verifications return a signed JWT in the "token" field of JSON responses.
+	Token *TokenIssuer
+	// Exchange, if set, makes successful verifications return a single-use "exchange_code" instead of trusting the
+	// browser with the result. The application's backend swaps it for the result at POST /exchange, presenting these
+	// credentials. Codes last for Timeout and can only be exchanged on the instance that issued them.
+	Exchange *Credentials
+	// Session, if set, makes successful verifications set a signed session cookie.
+	Session *SessionCookie
+	// CaptureAttribution copies the Referer header and utm_* query parameters of the request that verifies an await into
+	// Result.Attribution, so that it can be seen which email or channel drove the verification.
+	CaptureAttribution bool
+	// RequestIDHeader is the header that verification requests' IDs are read from, if a client or proxy sent one, and
+	// returned in. The ID is carried through to Result, events and logs, so that a verification can be traced across
+	// systems. Defaults to X-Request-ID.
+	RequestIDHeader string
+	// CaptureHeaders are request headers, such as User-Agent or CF-IPCountry, to copy from the request that resolves an
+	// await into its Result and events, e.g. for fraud scoring.
+	CaptureHeaders []string
+	// Notifier, if set, sends a push notification when an await with MetadataPushToken is registered, so that the user
+	// can approve it from their phone.
+	Notifier Notifier
+	// Notification returns the notification to send for pending. Defaults to a generic approval request.
+	Notification func(pending PendingAwait) Notification
+	// Bounces, if set, enables POST /webhooks/bounces for email providers to report bounces and complaints, which resolve
+	// the awaits that they're about with StatusUndeliverable.
+	Bounces *BounceWebhook
+	// Dispatcher, if set, sends deliveries queued with Deliver in the background. Serve runs it.
+	Dispatcher *Dispatcher
+	// AfterVerify, if set, runs actions in the background once awaits are verified.
+	AfterVerify *AfterVerify
+	// Logger, if set, receives logs about verification attempts and about errors that have nowhere else to go, such as
+	// failed push notifications or event sinks. See gotchazap and gotchalogrus for other logging libraries.
+	Logger *slog.Logger
+	// LogSampling, if set, logs only a sample of verification attempts, e.g. every failure but 1% of successes.
+	LogSampling *LogSampling
+	// EventSinks receive events about awaits as they're registered and resolved. Events wait in an outbox, which
+	// Snapshot saves along with the awaits, until every sink has taken them; a sink that fails is retried.
+	EventSinks []EventSink
+	// EventBackoff is how long to wait before retrying a sink that failed, doubled for each retry after it up to a
+	// minute. Defaults to 1 second.
+	EventBackoff time.Duration
+	// Honeypots are decoy paths, such as /wp-login.php, that no real client would request. Clients that request them
+	// are blocked for HoneypotTTL. They mustn't overlap with gotcha's own routes.
+	Honeypots []string
+	// HoneypotTTL is how long clients that hit a honeypot, or a honeytoken with HoneytokenBlock, are blocked for. Defaults to 24 hours.
+	HoneypotTTL time.Duration
+	// HoneytokenAlert, if set, is called in the background when a client tries to verify a honeytoken. See
+	// AddHoneytoken.
+	HoneytokenAlert func(alert HoneytokenAlert)
+	// HoneytokenBlock blocks clients that try to verify a honeytoken for HoneypotTTL.
+	HoneytokenBlock bool
 	// UseTLS decides on whether or not the server will be served under HTTPS.
 	UseTLS bool
 	// TLSCert is the filepath to an SSL/TLS certificate.
 	TLSCert string
 	// TLSKey is the filepath to an SSL/TLS key.
 	TLSKey string
+	// TLSConfig, if set, serves HTTPS with this configuration, e.g. to set cipher suites, require client certificates or
+	// load certificates from a secrets manager with GetCertificate. TLSCert and TLSKey are optional with it, as is
+	// UseTLS.
+	TLSConfig *tls.Config
+	// TLSCertificates, if set, serves HTTPS with whichever of them is for the hostname that the client asks for, so
+	// that one instance can serve several domains. TLSCert and TLSKey, if set, are served to clients that ask for
+	// another hostname, or don't say. UseTLS is optional with it.
+	TLSCertificates []TLSCertificate
+	// HTTP3, if set, also serves over HTTP/3 on the same port, advertising it with Alt-Svc. It needs TLS, and is only
+	// started when Serve creates the router.
+	HTTP3 HTTP3
+	// Transport, if set, serves the router in place of net/http's server. It's only used when Serve creates the router.
+	Transport Transport
+	// UnknownIdentifiers, if set, changes how requests for identifiers that aren't known are answered: with 404 rather
+	// than 401, a page of their own, or a redirect.
+	UnknownIdentifiers *UnknownIdentifiers
+	// NotFound handles requests for paths that don't exist, such as truncated links; it could render a custom 404 page
+	// or redirect to the main site. It's only used when Serve creates the router. Defaults to gin's empty 404.
+	NotFound gin.HandlerFunc
+	// Methods are the HTTP methods that verification requests can use. Defaults to GET, which email links need;
+	// REST-style clients might prefer POST or PUT. AwaitRequest.Methods can narrow them down per await. HEAD and OPTIONS
+	// are always answered without consuming anything, since link previewers and mail scanners send them.
+	Methods []string
+	// BaseURL is the external URL that the server is reachable at, used by VerifyURL.
+	BaseURL string
+	// APIVersion is the version that the routes are served under, as /<version>/verify/:identifier and so on, which
+	// VerifyURL's links and the pages' forms use, so that later versions can change routes and responses without
+	// breaking links that have already been sent. The routes are served at their unversioned paths too, for links sent
+	// before they were versioned. Defaults to "v1".
+	APIVersion string
+	// AppLinks, if set, serves the files that let mobile apps open verification links.
+	AppLinks *AppLinks
+	// RobotsTxt is served as /robots.txt. Defaults to disallowing every crawler, so that live verification links aren't
+	// crawled or indexed. Verification responses have X-Robots-Tag: noindex either way.
+	RobotsTxt string
+	// WellKnown are served under /.well-known/, keyed by their path below it, such as "security.txt", so that a
+	// domain that gotcha has to itself can follow those conventions. A key ending in "/", such as "acme-challenge/",
+	// serves everything below it. See WellKnownFile.
+	WellKnown map[string]http.Handler
+	// Shortener, if set, shortens the links that VerifyURL returns, such as with HTTPShortener.
+	Shortener Shortener
+	// Tenants, keyed by namespace, let namespaces have their own verification paths, base URLs, hosts, themes and
+	// blocklists.
+	Tenants map[string]Tenant
+	// HashKey, if set, is used to hash identifiers and user codes before they're stored, so that a dump of the server's
+	// memory or its events can't be replayed to verify pending awaits. See HashIdentifier.
+	HashKey []byte
+	// Keyring, if set, seals the state that Export and Snapshot write, and opens it in Import.
+	Keyring *Keyring
+	// Snapshot, if set, saves pending awaits, the runtime blocklist and statistics to disk, restoring them when Serve
+	// starts.
+	Snapshot *Snapshot
+	// SigningKey enables signed links of the form /verify?token=...&expires=...&sig=..., which VerifyURL generates.
+	// Their signature and expiry are checked before anything else.
+	SigningKey []byte
+	// VerifyAuth, if set, must be presented by clients of /verify. It's meant for machine-to-machine approvals, where the
+	// client is another service rather than someone's browser.
+	VerifyAuth *Credentials
+	// AdminToken enables the admin endpoints under /admin, such as /admin/metrics for Prometheus and /admin/live, a
+	// WebSocket that streams events as they happen. Requests to them must carry "Authorization: Bearer <AdminToken>".
+	AdminToken string
+	// Dashboard serves a web dashboard at /admin/dashboard, behind AdminToken, showing pending awaits, recent outcomes
+	// and the blocklist. Browsers can log in to it as "admin" with AdminToken as the password.
+	Dashboard bool
+	// Analytics, if set, keeps daily verification funnels per namespace, served at /admin/analytics behind AdminToken.
+	Analytics *Analytics
+	// Accounting, if set, counts awaits per namespace for chargeback, served at /admin/accounting behind AdminToken
+	// and exported periodically with Accounting.Export.
+	Accounting *Accounting
+	// StatsD, if set, exports the same metrics as /admin/metrics to a statsd agent.
+	StatsD *StatsD
+	// Pprof mounts the net/http/pprof handlers at /admin/debug/pprof/, behind AdminToken.
+	Pprof bool
+	// DebugDumps lets DumpRequests, and POST /admin/dumps behind AdminToken, keep Dumps of the requests for chosen
+	// identifiers, such as for a user whose link doesn't work. It's off by default, since dumps hold client details.
+	DebugDumps bool
+	// CacheControl is the Cache-Control header set on verification responses, so that CDNs and proxies never cache a
+	// result or replay a request. Defaults to "no-store", in which case Pragma and Expires are set for old caches too.
+	CacheControl string
+	// Compression, if set, compresses responses with gzip or deflate for clients that accept it.
+	Compression *Compression
+	// WaitTimeout is the longest that /wait will hold a connection open for. Defaults to 30 seconds.
+	WaitTimeout time.Duration
 
 	router  *gin.Engine
-	awaited map[string]awaited
-}
-
-type awaited struct {
-	start    time.Time
-	statChan chan int
+	metrics metrics
+	// mu guards the awaits and the rest of the server's state. Most requests hold it exclusively; verifying, probing
+	// and waiting on an await hold it shared, along with the lock of the await's shard, and resolveMu to change what
+	// resolving an await changes besides the await itself. Locks are taken in that order: mu, then one shard's lock,
+	// then resolveMu, then any of the others, such as blockMu.
+	mu sync.RWMutex
+	// awaits holds the registered awaits. See awaitStore.
+	awaits awaitStore
+	// outbox holds the events that haven't been taken by every EventSink yet, in order, and outboxSeq numbers them.
+	// outboxReady wakes up dispatch, which eventsOnce starts.
+	outboxMu    sync.Mutex
+	outbox      []queuedEvent
+	outboxSeq   uint64
+	outboxReady chan struct{}
+	eventsOnce  sync.Once
+	blockMu     sync.Mutex
+	// blocked is the runtime blocklist, added to with Block.
+	blocked      map[string]blocked
+	blockSweepAt int
+	// devices maps normalised user codes to device codes, both as returned by HashIdentifier.
+	devices map[string]string
+	// exchanges maps exchange codes to the results that they can be swapped for.
+	exchanges map[string]exchange
+	watchMu   sync.Mutex
+	// watchers receive events for /admin/live.
+	watchers map[chan Event]struct{}
+	// honeytokens holds decoy identifiers, as returned by HashIdentifier.
+	honeytokens map[string]struct{}
+	// listener and transport are what Serve is serving with, for Upgrade.
+	listener  net.Listener
+	transport Transport
+	// templates are the built-in templates with those in TemplateDir parsed over them, and tenantTemplates those with
+	// each Tenant's TemplateDir parsed over them in turn.
+	templates       *template.Template
+	tenantTemplates map[string]*template.Template
+	// tenantHosts maps the Hosts of Tenants to their namespaces.
+	tenantHosts map[string]string
+	// trustedProxies is TrustedProxies, parsed.
+	trustedProxies []netip.Prefix
+	// pendingCount is how many awaits are pending, and pendingPriorities how many of them have each Priority.
+	pendingCount      int
+	pendingPriorities map[Priority]int
+	// namespacePending is how many awaits are pending in each namespace, and namespaceRegistrations how many each has
+	// registered this hour, for Quotas.
+	namespacePending       map[string]int
+	namespaceRegistrations map[string]*rateWindow
+	// namespaceActive is when awaits were last registered or resolved in each namespace, for NamespaceIdle.
+	namespaceActive map[string]time.Time
+	// reasonTemplates caches block reasons parsed as templates, by their text.
+	reasonTemplates sync.Map
+	// steps maps step identifiers to the awaits that they belong to, and aliases does the same for aliases.
+	steps   map[string]stepRef
+	aliases map[string]aliasRef
+	// used holds the identifiers of awaits verified within ReplayWindow, and usedSweepAt how big it can get before
+	// those that are too old are swept out.
+	used        map[string]usedLink
+	usedSweepAt int
+	// dumpWatches holds the identifiers, as returned by HashIdentifier, whose requests are being dumped.
+	dumpMu      sync.Mutex
+	dumpWatches map[string]*dumpWatch
+	// idempotencyKeys maps AwaitRequest.IdempotencyKey, scoped to the namespace, to the awaits registered with them.
+	idempotencyKeys map[string]idempotentRef
+	// drained is closed once nothing's pending, if the server is draining. It's nil otherwise.
+	drained chan struct{}
+	// resolveMu guards pendingCount, pendingPriorities, namespacePending, namespaceActive, used, usedSweepAt, exchanges
+	// and drained when mu is only held shared. With mu held exclusively, it isn't needed to read or change them.
+	resolveMu sync.Mutex
+	// maintenance is the current Maintenance, if there is one, and maintenanceStart when it started.
+	maintenance      *Maintenance
+	maintenanceStart time.Time
+	// prefix is the path that Mount mounted the routes under, which links and forms on pages are relative to.
+	prefix string
 }
 
 // Serve starts the HTTP server. Uses gin-gonic.
 func (server *Server) Serve() error {
-	attached := true
-	if server.router == nil {
-		attached = false
-		server.router = gin.New()
-		gin.SetMode(gin.ReleaseMode)
+	attached := server.router != nil
+	if err := server.start(); err != nil {
+		return err
 	}
-	if server.Render == nil {
-		server.Render = func(c *gin.Context, status int, body map[string]string) {
-			c.JSON(status, body)
+	if !attached {
+		if server.HTTP3 != nil {
+			return server.serveHTTP3()
 		}
+		return server.listenAndServe(server.router)
 	}
+	return nil
+}
 
-	server.router.GET("/verify/:identifier", func(c *gin.Context) {
-		identifier := c.Param("identifier")
-		body := map[string]string{}
-		// Possibly use 404?
-		status := http.StatusUnauthorized
-
-		if found, ok := server.awaited[c.Param("identifier")]; ok {
-			if time.Now().Sub(found.start) >= server.Timeout {
-				found.statChan <- 1
-				status = http.StatusGone
-			} else if reason, ok := server.BlockList[c.ClientIP()]; ok {
-				found.statChan <- 2
-				body["reason"] = reason
-				status = http.StatusForbidden
-			} else {
-				found.statChan <- 0
-				status = http.StatusOK
+// verifyRoutes registers the handlers of path, which must have an :identifier parameter, for each of the methods.
+func (server *Server) verifyRoutes(group *gin.RouterGroup, path string) {
+	for _, method := range server.methods() {
+		if server.Confirm && method == http.MethodGet {
+			group.GET(path, server.confirmPage)
+			continue
+		}
+		group.Handle(method, path, func(c *gin.Context) {
+			server.markDryRun(c, c.Param("identifier"))
+			if server.prefetched(c, server.HashIdentifier(c.Param("identifier"))) {
+				server.confirmPage(c)
+				return
 			}
+			server.fallback(c, c.Param("identifier"))
+			server.verify(c, server.HashIdentifier(c.Param("identifier")))
+		})
+	}
+}
 
-			close(found.statChan)
-			delete(server.awaited, identifier)
+// routes registers the API's routes under root, which is either the versioned path or, for the unversioned
+// compatibility alias, the router's root.
+func (server *Server) routes(root *gin.RouterGroup) error {
+	verification := root.Group("", server.requestID, server.dumpRequest, server.cacheControl, server.hostTenant,
+		server.checkIdentifier)
+	protected := verification.Group("", server.inMaintenance, server.limitInFlight, server.rateLimit, server.verifyAuth)
+	server.verifyRoutes(protected, "/verify/:identifier")
+	for namespace, tenant := range server.Tenants {
+		if tenant.Path == "" {
+			continue
 		}
-
-		body["message"] = http.StatusText(status)
-		server.Render(c, status, body)
-	})
-
-	if !attached {
-		if server.UseTLS {
-			return server.router.RunTLS(server.Address, server.TLSCert, server.TLSKey)
+		path, err := tenant.tenantPath()
+		if err != nil {
+			return err
 		}
-		return server.router.Run(server.Address)
+		server.verifyRoutes(protected.Group("", server.inNamespace(namespace)), path)
+	}
+	for _, method := range server.methods() {
+		if server.SigningKey != nil && method != http.MethodPost {
+			protected.Handle(method, "/verify", server.verifySigned)
+		}
+	}
+	if server.confirms() {
+		protected.POST("/confirm/:identifier", server.checkCSRF, server.confirm)
+	}
+	if server.TOTP {
+		protected.POST("/verify/:identifier/totp", server.verifyTOTP)
+	}
+	verification.HEAD("/verify/:identifier", server.verifyAuth, server.probe)
+	verification.OPTIONS("/verify/:identifier", server.options)
+	protected.POST("/verify", server.verifyJSON)
+	verification.GET("/wait/:identifier", server.wait)
+	if server.Exchange != nil {
+		verification.POST("/exchange", server.exchangeCode)
+	}
+	if server.Bounces != nil {
+		root.POST("/webhooks/bounces", server.bounceWebhook)
+	}
+	root.GET("/openapi.json", server.openAPI)
+	verification.GET("/device", server.deviceForm)
+	verification.POST("/device", server.inMaintenance, server.rateLimit, server.checkCSRF, server.deviceSubmit)
+	if server.Resend != nil {
+		verification.POST("/resend/:identifier", server.inMaintenance, server.rateLimit, server.checkCSRF, server.resend)
+	}
+	if server.Appeals != nil {
+		verification.GET("/appeal", server.appealForm)
+		verification.POST("/appeal", server.rateLimit, server.checkCSRF, server.appeal)
+	}
+	if server.AdminToken != "" {
+		server.registerAdmin(root)
 	}
 	return nil
 }
 
-// Await waits for a GET request to /verify/:identifier.
-// It'll return 0 if the request was fulfilled, 1 if Timeout elapsed, or 2 if it was blocked.
-// This function blocks.
-func (server *Server) Await(identifier string) int {
-	if server.awaited == nil {
-		server.awaited = map[string]awaited{}
-	}
-	server.awaited[identifier] = awaited{
-		start:    time.Now(),
-		statChan: make(chan int, 1),
-	}
-	return <-server.awaited[identifier].statChan
+// start registers the routes, creating the router if there isn't one, and starts everything that runs in the
+// background.
+func (server *Server) start() error {
+	if server.router == nil {
+		server.router = gin.New()
+		gin.SetMode(gin.ReleaseMode)
+		if server.NotFound != nil {
+			server.router.NoRoute(server.NotFound)
+		}
+	}
+	server.mu.Lock()
+	err := server.parseTemplates()
+	server.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	server.router.Use(server.compress)
+
+	server.tenantHosts = tenantHosts(server.Tenants)
+	if server.trustedProxies, err = parseProxies(server.TrustedProxies); err != nil {
+		return err
+	}
+	for _, base := range []string{server.versionPath(), ""} {
+		if err := server.routes(server.router.Group(base)); err != nil {
+			return err
+		}
+	}
+	if server.servesRobots() {
+		server.router.GET("/robots.txt", server.robots)
+	}
+	if handlers := server.wellKnownHandlers(); len(handlers) > 0 {
+		server.router.GET("/.well-known/*name", server.serveWellKnown(handlers))
+		server.router.HEAD("/.well-known/*name", server.serveWellKnown(handlers))
+	}
+	for _, path := range server.Honeypots {
+		server.router.Any(path, server.honeypot)
+	}
+
+	if server.Snapshot != nil {
+		restored, err := server.Snapshot.Restore(server)
+		if err != nil {
+			return err
+		}
+		server.logger().Info("gotcha: restored snapshot", "path", server.Snapshot.Path, "awaits", restored)
+		go server.Snapshot.run(context.Background(), server, server.logger())
+	}
+	if server.Cluster != nil {
+		go server.listenCluster(context.Background())
+	}
+	if server.Dispatcher != nil {
+		go server.Dispatcher.Run(context.Background())
+	}
+	if server.Accounting != nil && server.Accounting.Export != nil {
+		go server.Accounting.run(context.Background(), server, server.logger())
+	}
+	if server.NamespaceIdle > 0 {
+		go server.forgetNamespaces(context.Background())
+	}
+	for _, list := range server.RemoteBlockLists {
+		go list.run(context.Background(), server.logger())
+	}
+	if server.StatsD != nil {
+		server.StatsD.init()
+		server.metrics.mu.Lock()
+		server.metrics.statsd = server.StatsD
+		server.metrics.mu.Unlock()
+		go func() {
+			pending := func() int { return len(server.Pending()) }
+			if err := server.StatsD.run(context.Background(), pending); err != nil {
+				server.logger().Error("gotcha: statsd exporter stopped", "error", err)
+			}
+		}()
+	}
+	return nil
 }
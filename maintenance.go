@@ -0,0 +1,91 @@
+package gotcha
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maintenance describes planned downtime, such as of the stores behind the Verifier, during which verification
+// requests are turned away with 503 Service Unavailable. Pending awaits are kept. See StartMaintenance.
+type Maintenance struct {
+	// Message is shown to clients. Defaults to saying that the service will be back shortly.
+	Message string
+	// RetryAfter is sent with the 503, telling clients when to try again. Defaults to 1 minute.
+	RetryAfter time.Duration
+	// PauseExpiry stops pending awaits from expiring during the downtime: once it ends, their expiry is pushed back by
+	// however long it lasted.
+	PauseExpiry bool
+}
+
+// StartMaintenance turns verification requests away until EndMaintenance is called. Calling it again replaces the
+// Maintenance, but the downtime is still counted from the first call.
+func (server *Server) StartMaintenance(maintenance Maintenance) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.maintenance == nil {
+		server.maintenanceStart = time.Now()
+	}
+	server.maintenance = &maintenance
+	server.logger().Info("gotcha: maintenance started", "pause_expiry", maintenance.PauseExpiry)
+}
+
+// EndMaintenance serves verification requests again, pushing back the expiry of pending awaits if the Maintenance
+// paused it. It returns false if the server wasn't in maintenance.
+func (server *Server) EndMaintenance() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.maintenance == nil {
+		return false
+	}
+	lasted := time.Since(server.maintenanceStart)
+	if server.maintenance.PauseExpiry {
+		server.awaits.each(func(key string, entry *awaited) bool {
+			if !entry.pending() {
+				return true
+			}
+			entry.expires = entry.expires.Add(lasted)
+			if entry.expiryParked {
+				entry.expiryParked = false
+				server.scheduleExpiry(key, entry)
+			}
+			return true
+		})
+	}
+	server.maintenance = nil
+	server.logger().Info("gotcha: maintenance ended", "lasted", lasted)
+	return true
+}
+
+// pausesExpiry reports whether awaits mustn't expire because of Maintenance. The server's lock must be held.
+func (server *Server) pausesExpiry() bool {
+	return server.maintenance != nil && server.maintenance.PauseExpiry
+}
+
+// inMaintenance is middleware that turns requests away during Maintenance.
+func (server *Server) inMaintenance(c *gin.Context) {
+	server.mu.Lock()
+	maintenance := server.maintenance
+	server.mu.Unlock()
+	if maintenance == nil {
+		return
+	}
+	retryAfter := maintenance.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Minute
+	}
+	message := maintenance.Message
+	if message == "" {
+		message = "We're down for maintenance, and will be back shortly."
+	}
+	status := http.StatusServiceUnavailable
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	server.render(c, RenderContext{Status: status, Code: "maintenance", Body: map[string]string{
+		"message": http.StatusText(status),
+		"reason":  message,
+	}})
+	c.Abort()
+}
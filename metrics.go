@@ -0,0 +1,345 @@
+package gotcha
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifyBuckets are the upper bounds of the time-to-verify histogram.
+var verifyBuckets = []time.Duration{
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// metrics holds a server's counters and timings.
+type metrics struct {
+	mu         sync.Mutex
+	registered uint64
+	// resolved counts resolved awaits by status.
+	resolved map[int]uint64
+	// unrequested counts awaits that expired without their link ever being requested.
+	unrequested uint64
+	// verifyCounts counts verified awaits by how long they took, indexed like verifyBuckets with a final +Inf bucket.
+	verifyCounts [11]uint64
+	verifySum    time.Duration
+	// eventSinkFailures counts failed attempts to send events to EventSinks, which are retried.
+	eventSinkFailures uint64
+	// blockHits counts requests rejected by a blocklist, by reason, and allowListDenials those rejected by an
+	// AwaitRequest.AllowList.
+	blockHits        map[string]uint64
+	allowListDenials uint64
+	// overloaded counts requests turned away because Limits.MaxInFlight, or MaxInFlightPerIP, were being handled.
+	overloaded uint64
+	// replays counts confirmations that were posted without the nonce that the confirmation page was last served with.
+	replays uint64
+	// csrfFailures counts form posts rejected because their CSRF token didn't match CSRFCookie.
+	csrfFailures uint64
+	// resends counts fresh links issued in place of expired ones.
+	resends uint64
+	// reissues counts awaits that were replaced because their links were opened after they expired.
+	reissues uint64
+	// rejectedRegistrations counts awaits that couldn't be registered because of MaxPending, Health, Quotas,
+	// RegistrationLimiter or Drain.
+	rejectedRegistrations uint64
+	// overQuota counts awaits that couldn't be registered because their namespace was over its Quota, by namespace.
+	overQuota map[string]uint64
+	// statsd, if set, is sent everything that's observed as well.
+	statsd *StatsD
+}
+
+func (m *metrics) observeRegistered() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registered++
+	if m.statsd != nil {
+		m.statsd.send("awaits.registered", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeEventSinkFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.eventSinkFailures++
+	if m.statsd != nil {
+		m.statsd.send("events.sink_failures", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeBlockHit(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.blockHits == nil {
+		m.blockHits = map[string]uint64{}
+	}
+	m.blockHits[reason]++
+	if m.statsd != nil {
+		m.statsd.sendTagged("blocklist.hits", "reason", reason, 1, "c")
+	}
+}
+
+func (m *metrics) observeAllowListDenial() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.allowListDenials++
+	if m.statsd != nil {
+		m.statsd.send("allowlist.denials", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeRejectedRegistration() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejectedRegistrations++
+	if m.statsd != nil {
+		m.statsd.send("awaits.rejected", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeOverQuota(namespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.overQuota == nil {
+		m.overQuota = map[string]uint64{}
+	}
+	m.overQuota[namespace]++
+	if m.statsd != nil {
+		m.statsd.sendTagged("quota.rejected", "namespace", namespace, 1, "c")
+	}
+}
+
+// forgetNamespace deletes the metrics for namespace. See Server.NamespaceIdle.
+func (m *metrics) forgetNamespace(namespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.overQuota, namespace)
+}
+
+func (m *metrics) observeOverloaded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overloaded++
+	if m.statsd != nil {
+		m.statsd.send("requests.overloaded", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeReplay() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replays++
+	if m.statsd != nil {
+		m.statsd.send("confirm.replays", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeResend() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resends++
+	if m.statsd != nil {
+		m.statsd.send("resends", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeReissue() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reissues++
+	if m.statsd != nil {
+		m.statsd.send("reissues", "", 1, "c")
+	}
+}
+
+func (m *metrics) observeCSRFFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.csrfFailures++
+	if m.statsd != nil {
+		m.statsd.send("csrf.failures", "", 1, "c")
+	}
+}
+
+// outcomes returns the counts behind the dashboard's outcomes and time to verify, for a snapshot.
+func (m *metrics) outcomes() *exportedOutcomes {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	outcomes := &exportedOutcomes{Resolved: map[int]uint64{}, TimeToVerify: append([]uint64(nil), m.verifyCounts[:]...),
+		TimeToVerifySum: m.verifySum}
+	for status, count := range m.resolved {
+		outcomes.Resolved[status] = count
+	}
+	return outcomes
+}
+
+// restoreOutcomes replaces the counts that outcomes returns.
+func (m *metrics) restoreOutcomes(outcomes *exportedOutcomes) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resolved = map[int]uint64{}
+	for status, count := range outcomes.Resolved {
+		m.resolved[status] = count
+	}
+	m.verifyCounts = [len(m.verifyCounts)]uint64{}
+	copy(m.verifyCounts[:], outcomes.TimeToVerify)
+	m.verifySum = outcomes.TimeToVerifySum
+}
+
+func (m *metrics) observeResolved(entry *awaited) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resolved == nil {
+		m.resolved = map[int]uint64{}
+	}
+	m.resolved[entry.status]++
+	if m.statsd != nil {
+		m.statsd.send("awaits.resolved", stateOf(entry.status).String(), 1, "c")
+	}
+
+	switch entry.status {
+	case StatusExpired:
+		if !entry.requested {
+			m.unrequested++
+			if m.statsd != nil {
+				m.statsd.send("awaits.unrequested", "", 1, "c")
+			}
+		}
+	case StatusVerified:
+		took := entry.resolved.Sub(entry.validFrom())
+		m.verifySum += took
+		bucket := sort.Search(len(verifyBuckets), func(i int) bool { return took <= verifyBuckets[i] })
+		m.verifyCounts[bucket]++
+		if m.statsd != nil {
+			m.statsd.send("time_to_verify", "", float64(took)/float64(time.Millisecond), "ms")
+		}
+	}
+}
+
+// writePrometheus writes the metrics in the Prometheus text exposition format.
+func (m *metrics) writePrometheus(w io.Writer, pending int, namespaces map[string]Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP gotcha_awaits_pending Awaits that are waiting to be verified.")
+	fmt.Fprintln(w, "# TYPE gotcha_awaits_pending gauge")
+	fmt.Fprintf(w, "gotcha_awaits_pending %d\n", pending)
+
+	fmt.Fprintln(w, "# HELP gotcha_awaits_registered_total Awaits that have been registered.")
+	fmt.Fprintln(w, "# TYPE gotcha_awaits_registered_total counter")
+	fmt.Fprintf(w, "gotcha_awaits_registered_total %d\n", m.registered)
+
+	fmt.Fprintln(w, "# HELP gotcha_awaits_resolved_total Awaits that have been resolved, by outcome.")
+	fmt.Fprintln(w, "# TYPE gotcha_awaits_resolved_total counter")
+	statuses := make([]int, 0, len(m.resolved))
+	for status := range m.resolved {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "gotcha_awaits_resolved_total{outcome=%q} %d\n", stateOf(status), m.resolved[status])
+	}
+
+	fmt.Fprintln(w, "# HELP gotcha_awaits_unrequested_total Awaits that expired without their link ever being requested.")
+	fmt.Fprintln(w, "# TYPE gotcha_awaits_unrequested_total counter")
+	fmt.Fprintf(w, "gotcha_awaits_unrequested_total %d\n", m.unrequested)
+
+	fmt.Fprintln(w, "# HELP gotcha_event_sink_failures_total Failed attempts to send events to sinks, which are retried.")
+	fmt.Fprintln(w, "# TYPE gotcha_event_sink_failures_total counter")
+	fmt.Fprintf(w, "gotcha_event_sink_failures_total %d\n", m.eventSinkFailures)
+
+	fmt.Fprintln(w, "# HELP gotcha_blocklist_hits_total Requests rejected by a blocklist, by the reason given.")
+	fmt.Fprintln(w, "# TYPE gotcha_blocklist_hits_total counter")
+	reasons := make([]string, 0, len(m.blockHits))
+	for reason := range m.blockHits {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "gotcha_blocklist_hits_total{reason=%q} %d\n", reason, m.blockHits[reason])
+	}
+
+	fmt.Fprintln(w, "# HELP gotcha_allowlist_denials_total Requests rejected because the client wasn't on the await's allowlist.")
+	fmt.Fprintln(w, "# TYPE gotcha_allowlist_denials_total counter")
+	fmt.Fprintf(w, "gotcha_allowlist_denials_total %d\n", m.allowListDenials)
+
+	fmt.Fprintln(w, "# HELP gotcha_registrations_rejected_total Awaits that couldn't be registered because the server was at capacity or unhealthy, or the caller was rate limited.")
+	fmt.Fprintln(w, "# TYPE gotcha_registrations_rejected_total counter")
+	fmt.Fprintf(w, "gotcha_registrations_rejected_total %d\n", m.rejectedRegistrations)
+
+	names := make([]string, 0, len(namespaces))
+	for namespace := range namespaces {
+		names = append(names, namespace)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "# HELP gotcha_namespace_pending Awaits that are waiting to be verified, by namespace.")
+	fmt.Fprintln(w, "# TYPE gotcha_namespace_pending gauge")
+	for _, namespace := range names {
+		fmt.Fprintf(w, "gotcha_namespace_pending{namespace=%q} %d\n", namespace, namespaces[namespace].Pending)
+	}
+	fmt.Fprintln(w, "# HELP gotcha_namespace_registered_this_hour Awaits registered in the current quota hour, by namespace.")
+	fmt.Fprintln(w, "# TYPE gotcha_namespace_registered_this_hour gauge")
+	for _, namespace := range names {
+		fmt.Fprintf(w, "gotcha_namespace_registered_this_hour{namespace=%q} %d\n", namespace, namespaces[namespace].Registered)
+	}
+
+	fmt.Fprintln(w, "# HELP gotcha_quota_rejections_total Awaits that couldn't be registered because their namespace was over its quota.")
+	fmt.Fprintln(w, "# TYPE gotcha_quota_rejections_total counter")
+	overQuota := make([]string, 0, len(m.overQuota))
+	for namespace := range m.overQuota {
+		overQuota = append(overQuota, namespace)
+	}
+	sort.Strings(overQuota)
+	for _, namespace := range overQuota {
+		fmt.Fprintf(w, "gotcha_quota_rejections_total{namespace=%q} %d\n", namespace, m.overQuota[namespace])
+	}
+
+	fmt.Fprintln(w, "# HELP gotcha_overloaded_total Requests turned away because too many were in flight.")
+	fmt.Fprintln(w, "# TYPE gotcha_overloaded_total counter")
+	fmt.Fprintf(w, "gotcha_overloaded_total %d\n", m.overloaded)
+
+	fmt.Fprintln(w, "# HELP gotcha_confirm_replays_total Confirmations rejected for a missing, used or stale nonce.")
+	fmt.Fprintln(w, "# TYPE gotcha_confirm_replays_total counter")
+	fmt.Fprintf(w, "gotcha_confirm_replays_total %d\n", m.replays)
+
+	fmt.Fprintln(w, "# HELP gotcha_csrf_failures_total Form posts rejected because their CSRF token didn't match.")
+	fmt.Fprintln(w, "# TYPE gotcha_csrf_failures_total counter")
+	fmt.Fprintf(w, "gotcha_csrf_failures_total %d\n", m.csrfFailures)
+
+	fmt.Fprintln(w, "# HELP gotcha_resends_total Fresh links issued in place of expired ones.")
+	fmt.Fprintln(w, "# TYPE gotcha_resends_total counter")
+	fmt.Fprintf(w, "gotcha_resends_total %d\n", m.resends)
+
+	fmt.Fprintln(w, "# HELP gotcha_reissues_total Awaits replaced because their links were opened after they expired.")
+	fmt.Fprintln(w, "# TYPE gotcha_reissues_total counter")
+	fmt.Fprintf(w, "gotcha_reissues_total %d\n", m.reissues)
+
+	fmt.Fprintln(w, "# HELP gotcha_time_to_verify_seconds Time between an await being registered and verified.")
+	fmt.Fprintln(w, "# TYPE gotcha_time_to_verify_seconds histogram")
+	var cumulative uint64
+	for i, bound := range verifyBuckets {
+		cumulative += m.verifyCounts[i]
+		fmt.Fprintf(w, "gotcha_time_to_verify_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), cumulative)
+	}
+	cumulative += m.verifyCounts[len(verifyBuckets)]
+	fmt.Fprintf(w, "gotcha_time_to_verify_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "gotcha_time_to_verify_seconds_sum %g\n", m.verifySum.Seconds())
+	fmt.Fprintf(w, "gotcha_time_to_verify_seconds_count %d\n", cumulative)
+}
+
+// serveMetrics handles GET /admin/metrics.
+func (server *Server) serveMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	server.metrics.writePrometheus(c.Writer, len(server.Pending()), server.namespaceUsage())
+}
@@ -0,0 +1,320 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// ErrInvalidASNDatabase is returned by OpenASNDatabase for files that aren't MaxMind DB files it can read.
+var ErrInvalidASNDatabase = errors.New("gotcha: invalid ASN database")
+
+// mmdbMetadataMarker starts the metadata at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMaxDepth bounds how deeply values nest, so that a corrupt file can't recurse forever.
+const mmdbMaxDepth = 32
+
+// ASNDatabase looks up autonomous systems in a MaxMind DB file with autonomous_system_number and
+// autonomous_system_organization fields, such as GeoLite2-ASN. The file is read into memory when it's opened, so
+// updates need it opening again. It's safe for concurrent use.
+type ASNDatabase struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint32
+	recordSize int
+	// ipv4Start is the node that IPv4 addresses are looked up from in an IPv6 tree.
+	ipv4Start uint32
+	ipv6      bool
+}
+
+// OpenASNDatabase reads the MaxMind DB file at path.
+func OpenASNDatabase(path string) (*ASNDatabase, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewASNDatabase(file)
+}
+
+// NewASNDatabase reads a MaxMind DB file's contents.
+func NewASNDatabase(file []byte) (*ASNDatabase, error) {
+	at := bytes.LastIndex(file, mmdbMetadataMarker)
+	if at < 0 {
+		return nil, ErrInvalidASNDatabase
+	}
+	decoder := mmdbDecoder{data: file[at+len(mmdbMetadataMarker):]}
+	value, _, err := decoder.decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidASNDatabase
+	}
+	nodeCount, _ := metadata["node_count"].(uint64)
+	recordSize, _ := metadata["record_size"].(uint64)
+	ipVersion, _ := metadata["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 || nodeCount == 0 || nodeCount > math.MaxUint32 {
+		return nil, ErrInvalidASNDatabase
+	}
+	treeSize := nodeCount * recordSize / 4
+	// The tree is followed by 16 zero bytes, then the data section.
+	if treeSize+16 > uint64(at) {
+		return nil, ErrInvalidASNDatabase
+	}
+	db := &ASNDatabase{
+		tree:       file[:treeSize],
+		data:       file[treeSize+16 : at],
+		nodeCount:  uint32(nodeCount),
+		recordSize: int(recordSize),
+		ipv6:       ipVersion == 6,
+	}
+	if db.ipv6 {
+		// IPv4 addresses are stored under ::/96, so skip the 96 zero bits that lead there.
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// Lookup returns the autonomous system that ip belongs to, or an ASN with Number 0 if the database doesn't have it.
+// It can be used as ASNFilter.Lookup.
+func (db *ASNDatabase) Lookup(ctx context.Context, ip netip.Addr) (ASN, error) {
+	ip = ip.Unmap()
+	node := uint32(0)
+	if ip.Is4() && db.ipv6 {
+		node = db.ipv4Start
+	} else if ip.Is6() && !db.ipv6 {
+		return ASN{}, nil
+	}
+	bits := ip.AsSlice()
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, int(bits[i/8]>>(7-i%8))&1)
+	}
+	if node <= db.nodeCount {
+		// Either the address isn't in the database, or the tree is corrupt and never reached a record.
+		return ASN{}, nil
+	}
+	decoder := mmdbDecoder{data: db.data}
+	value, _, err := decoder.decode(int(node-db.nodeCount-16), 0)
+	if err != nil {
+		return ASN{}, err
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return ASN{}, ErrInvalidASNDatabase
+	}
+	number, _ := fields["autonomous_system_number"].(uint64)
+	organization, _ := fields["autonomous_system_organization"].(string)
+	return ASN{Number: uint32(number), Organization: organization}, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *ASNDatabase) record(node uint32, bit int) uint32 {
+	size := db.recordSize / 4
+	offset := int(node) * size
+	if offset+size > len(db.tree) {
+		return db.nodeCount
+	}
+	b := db.tree[offset : offset+size]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(b[bit*4:])
+	}
+}
+
+// mmdbDecoder decodes the values in a MaxMind DB data section, or its metadata. Maps decode to
+// map[string]interface{}, arrays to []interface{}, and unsigned and signed integers to uint64 and int64.
+type mmdbDecoder struct {
+	data []byte
+}
+
+// MaxMind DB data types.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// decode decodes the value at offset, returning it and the offset after it.
+func (decoder *mmdbDecoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, ErrInvalidASNDatabase
+	}
+	control, offset, err := decoder.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	kind := int(control[0] >> 5)
+	if kind == mmdbPointer {
+		pointer, next, err := decoder.pointer(control[0], offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := decoder.decode(pointer, depth+1)
+		return value, next, err
+	}
+	if kind == mmdbExtended {
+		extended, next, err := decoder.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind, offset = 7+int(extended[0]), next
+	}
+	size, offset, err := decoder.size(control[0], offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch kind {
+	case mmdbMap:
+		fields := make(map[string]interface{}, min(size, 64))
+		for i := 0; i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = decoder.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if value, offset, err = decoder.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidASNDatabase
+			}
+			fields[name] = value
+		}
+		return fields, offset, nil
+	case mmdbArray:
+		items := make([]interface{}, 0, min(size, 64))
+		for i := 0; i < size; i++ {
+			var item interface{}
+			if item, offset, err = decoder.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+		}
+		return items, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbEndMarker, mmdbContainer:
+		return nil, 0, ErrInvalidASNDatabase
+	}
+
+	b, next, err := decoder.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch kind {
+	case mmdbString:
+		return string(b), next, nil
+	case mmdbBytes, mmdbUint128:
+		return append([]byte(nil), b...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidASNDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidASNDatabase
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, ErrInvalidASNDatabase
+		}
+		var value uint64
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		return value, next, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, ErrInvalidASNDatabase
+		}
+		var value uint32
+		for _, c := range b {
+			value = value<<8 | uint32(c)
+		}
+		return int64(int32(value)), next, nil
+	}
+	return nil, 0, ErrInvalidASNDatabase
+}
+
+// size reads the size of a value with the control byte control, which is followed by offset.
+func (decoder *mmdbDecoder) size(control byte, offset int) (int, int, error) {
+	size := int(control & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	b, next, err := decoder.bytes(offset, size-28)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch size {
+	case 29:
+		return 29 + int(b[0]), next, nil
+	case 30:
+		return 285 + (int(b[0])<<8 | int(b[1])), next, nil
+	default:
+		return 65821 + (int(b[0])<<16 | int(b[1])<<8 | int(b[2])), next, nil
+	}
+}
+
+// pointer reads a pointer with the control byte control, which is followed by offset.
+func (decoder *mmdbDecoder) pointer(control byte, offset int) (int, int, error) {
+	size := int(control>>3&0x3) + 1
+	b, next, err := decoder.bytes(offset, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	value := int(control & 0x7)
+	if size == 4 {
+		value = 0
+	}
+	for _, c := range b {
+		value = value<<8 | int(c)
+	}
+	switch size {
+	case 2:
+		value += 2048
+	case 3:
+		value += 526336
+	}
+	return value, next, nil
+}
+
+// bytes returns the n bytes at offset, and the offset after them.
+func (decoder *mmdbDecoder) bytes(offset, n int) ([]byte, int, error) {
+	if offset < 0 || n < 0 || offset+n > len(decoder.data) {
+		return nil, 0, ErrInvalidASNDatabase
+	}
+	return decoder.data[offset : offset+n], offset + n, nil
+}
@@ -0,0 +1,44 @@
+package gotcha
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Mount registers the routes on mux under prefix, such as "/gotcha", so that gotcha can be served alongside other
+// handlers by a plain net/http server. It starts everything that Serve does besides listening, so Serve mustn't be
+// called as well. BaseURL should include the prefix, so that VerifyURL's links point under it.
+func (server *Server) Mount(mux *http.ServeMux, prefix string) error {
+	handler, err := server.Handler(prefix)
+	if err != nil {
+		return err
+	}
+	mux.Handle(strings.TrimSuffix(prefix, "/")+"/", handler)
+	return nil
+}
+
+// Handler returns a handler that serves the routes for requests under prefix, for routers other than ServeMux; the
+// gotchachi and gotchaecho packages use it. Like Mount, it starts everything that Serve does besides listening.
+func (server *Server) Handler(prefix string) (http.Handler, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	server.prefix = prefix
+	if err := server.start(); err != nil {
+		return nil, err
+	}
+	return http.StripPrefix(prefix, server.router), nil
+}
+
+// versionPath returns the path that the versioned routes are served under, such as "/v1".
+func (server *Server) versionPath() string {
+	version := strings.Trim(server.APIVersion, "/")
+	if version == "" {
+		version = "v1"
+	}
+	return "/" + version
+}
+
+// basePath returns the path that links and forms on pages are relative to: the versioned routes, under the prefix that
+// they're mounted at.
+func (server *Server) basePath() string {
+	return server.prefix + server.versionPath()
+}
@@ -0,0 +1,80 @@
+package gotcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidOpaqueID is returned by OpaqueIDs.Decode for identifiers that it didn't encode.
+var ErrInvalidOpaqueID = errors.New("gotcha: invalid opaque ID")
+
+// opaqueTagSize is how many bytes of the MAC an opaque ID carries.
+const opaqueTagSize = 12
+
+// OpaqueIDs turns internal IDs, such as database keys, into identifiers for awaits that neither reveal nor can be
+// guessed from them: the IDs are encrypted, and the identifier is signed, so that it can't be made without Key or
+// changed to point at another ID. Random identifiers are still better. The same IDs always encode to the same
+// identifier, so pass something that changes with each await too, such as a counter of the links sent, or an old link
+// will verify new awaits for them.
+type OpaqueIDs struct {
+	// Key encrypts and signs the identifiers. It's required, and should be at least 32 random bytes.
+	Key []byte
+}
+
+// Encode returns the identifier for ids.
+func (opaque *OpaqueIDs) Encode(ids ...uint64) string {
+	plaintext := make([]byte, 0, binary.MaxVarintLen64*len(ids))
+	for _, id := range ids {
+		plaintext = binary.AppendUvarint(plaintext, id)
+	}
+	tag := opaque.mac("mac", plaintext)[:opaqueTagSize]
+	return base64.RawURLEncoding.EncodeToString(append(tag, opaque.xor(tag, plaintext)...))
+}
+
+// Decode returns the IDs that identifier was encoded from, or ErrInvalidOpaqueID if it wasn't encoded with Key.
+func (opaque *OpaqueIDs) Decode(identifier string) ([]uint64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(identifier)
+	if err != nil || len(data) < opaqueTagSize {
+		return nil, ErrInvalidOpaqueID
+	}
+	tag := data[:opaqueTagSize]
+	plaintext := opaque.xor(tag, data[opaqueTagSize:])
+	if !hmac.Equal(tag, opaque.mac("mac", plaintext)[:opaqueTagSize]) {
+		return nil, ErrInvalidOpaqueID
+	}
+	ids := []uint64{}
+	for len(plaintext) > 0 {
+		id, n := binary.Uvarint(plaintext)
+		if n <= 0 {
+			return nil, ErrInvalidOpaqueID
+		}
+		ids = append(ids, id)
+		plaintext = plaintext[n:]
+	}
+	return ids, nil
+}
+
+// mac returns the HMAC of data with a key derived from Key for purpose.
+func (opaque *OpaqueIDs) mac(purpose string, data []byte) []byte {
+	derived := hmac.New(sha256.New, opaque.Key)
+	derived.Write([]byte("gotcha opaque id " + purpose))
+	mac := hmac.New(sha256.New, derived.Sum(nil))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// xor returns data XORed with a keystream derived from Key and tag, which encrypts and decrypts it.
+func (opaque *OpaqueIDs) xor(tag, data []byte) []byte {
+	out := make([]byte, len(data))
+	var stream []byte
+	for i := range data {
+		if i%sha256.Size == 0 {
+			stream = opaque.mac("stream", binary.BigEndian.AppendUint32(append([]byte{}, tag...), uint32(i/sha256.Size)))
+		}
+		out[i] = data[i] ^ stream[i%sha256.Size]
+	}
+	return out
+}
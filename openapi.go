@@ -0,0 +1,334 @@
+package gotcha
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPI handles GET /openapi.json, describing the routes that the server has been configured with as an OpenAPI 3
+// document.
+func (server *Server) openAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, server.openAPIDocument())
+}
+
+// openAPIDocument builds the OpenAPI document for the server's current configuration.
+func (server *Server) openAPIDocument() gin.H {
+	paths := gin.H{}
+	identifier := pathParameter("identifier", "The await's identifier.")
+	verifyAuth := credentialsSecurity(server.VerifyAuth)
+
+	verifyPath := gin.H{"parameters": []gin.H{identifier}}
+	for _, method := range server.methods() {
+		operation := gin.H{
+			"summary":    "Verify an await",
+			"parameters": []gin.H{queryParameter("code", "The await's code, if it has one.")},
+			"responses":  verifyResponses(),
+		}
+		if server.Confirm && method == http.MethodGet {
+			operation = gin.H{
+				"summary":    "Show a page that confirms the await when submitted",
+				"parameters": []gin.H{queryParameter("code", "The await's code, if it has one.")},
+				"responses":  gin.H{"200": gin.H{"description": "The confirmation page.", "content": htmlContent()}},
+			}
+		}
+		if verifyAuth != nil {
+			operation["security"] = verifyAuth
+		}
+		verifyPath[strings.ToLower(method)] = operation
+	}
+	verifyPath["head"] = gin.H{
+		"summary":   "Check whether an await can be verified, without consuming it",
+		"responses": gin.H{"200": response("It's pending."), "401": response("It isn't known."), "410": response("It expired.")},
+	}
+	verifyPath["options"] = gin.H{
+		"summary":   "List the methods that can verify awaits",
+		"responses": gin.H{"204": response("The methods are in the Allow header.")},
+	}
+	paths["/verify/{identifier}"] = verifyPath
+
+	verifyJSON := gin.H{
+		"summary": "Verify an await from an API client",
+		"requestBody": gin.H{"required": true, "content": jsonContent(gin.H{
+			"type":     "object",
+			"required": []string{"identifier"},
+			"properties": gin.H{
+				"identifier": gin.H{"type": "string"},
+				"code":       gin.H{"type": "string"},
+			},
+		})},
+		"responses": verifyResponses(),
+	}
+	if verifyAuth != nil {
+		verifyJSON["security"] = verifyAuth
+	}
+	signed := gin.H{"post": verifyJSON}
+	if server.SigningKey != nil {
+		for _, method := range server.methods() {
+			if method == http.MethodPost {
+				continue
+			}
+			operation := gin.H{
+				"summary": "Verify a signed link",
+				"parameters": []gin.H{
+					queryParameter("token", "The identifier that was signed."),
+					queryParameter("expires", "When the link expires, in Unix seconds."),
+					queryParameter("sig", "The link's signature."),
+				},
+				"responses": verifyResponses(),
+			}
+			if verifyAuth != nil {
+				operation["security"] = verifyAuth
+			}
+			signed[strings.ToLower(method)] = operation
+		}
+	}
+	paths["/verify"] = signed
+
+	if server.confirms() {
+		paths["/confirm/{identifier}"] = gin.H{"parameters": []gin.H{identifier}, "post": gin.H{
+			"summary":   "Confirm an await from its confirmation page",
+			"responses": verifyResponses(),
+		}}
+	}
+	if server.TOTP {
+		paths["/verify/{identifier}/totp"] = gin.H{"parameters": []gin.H{identifier}, "post": gin.H{
+			"summary":   "Verify an await with a code from an authenticator app",
+			"responses": verifyResponses(),
+		}}
+	}
+	paths["/wait/{identifier}"] = gin.H{"parameters": []gin.H{identifier}, "get": gin.H{
+		"summary":    "Wait for an await to resolve",
+		"parameters": []gin.H{queryParameter("timeout", "The longest to wait for, as a Go duration such as 10s.")},
+		"responses": gin.H{
+			"200": gin.H{"description": "The await's state.", "content": jsonContent(gin.H{
+				"type": "object",
+				"properties": gin.H{
+					"state":         gin.H{"type": "string"},
+					"opened":        gin.H{"type": "boolean"},
+					"failures":      gin.H{"type": "integer", "description": "Once it's resolved, how many failed attempts there were."},
+					"failure_codes": failureCodesSchema(),
+					"steps":         gin.H{"type": "object", "additionalProperties": gin.H{"type": "string"}},
+				},
+			})},
+			"400": response("The timeout is invalid."),
+			"404": response("The await isn't known."),
+		},
+	}}
+	if server.Exchange != nil {
+		paths["/exchange"] = gin.H{"post": gin.H{
+			"summary":  "Swap an exchange code for the result of a verification",
+			"security": credentialsSecurity(server.Exchange),
+			"requestBody": gin.H{"required": true, "content": jsonContent(gin.H{
+				"type":       "object",
+				"required":   []string{"code"},
+				"properties": gin.H{"code": gin.H{"type": "string"}},
+			})},
+			"responses": gin.H{
+				"200": gin.H{"description": "The result.", "content": jsonContent(gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"identifier":    gin.H{"type": "string"},
+						"namespace":     gin.H{"type": "string"},
+						"state":         gin.H{"type": "string"},
+						"resolved":      gin.H{"type": "string", "format": "date-time"},
+						"client_ip":     gin.H{"type": "string"},
+						"request_id":    gin.H{"type": "string"},
+						"flags":         gin.H{"type": "array", "items": gin.H{"type": "string"}},
+						"failures":      gin.H{"type": "integer", "description": "How many failed attempts there were."},
+						"failure_codes": failureCodesSchema(),
+					},
+				})},
+				"400": response("The request is invalid."),
+				"401": response("The credentials or the code are invalid."),
+			},
+		}}
+	}
+	if server.Bounces != nil {
+		operation := gin.H{
+			"summary":   "Report bounced emails",
+			"responses": gin.H{"200": response("How many awaits were resolved."), "400": response("The report is invalid.")},
+		}
+		if security := credentialsSecurity(server.Bounces.Credentials); security != nil {
+			operation["security"] = security
+		}
+		paths["/webhooks/bounces"] = gin.H{"post": operation}
+	}
+	paths["/device"] = gin.H{
+		"get": gin.H{
+			"summary":    "Show the form for entering a device's user code",
+			"parameters": []gin.H{queryParameter("user_code", "Pre-fills the form.")},
+			"responses":  gin.H{"200": gin.H{"description": "The form.", "content": htmlContent()}},
+		},
+		"post": gin.H{
+			"summary": "Verify the device that a user code belongs to",
+			"requestBody": gin.H{"required": true, "content": gin.H{"application/x-www-form-urlencoded": gin.H{
+				"schema": gin.H{"type": "object", "properties": gin.H{"user_code": gin.H{"type": "string"}}},
+			}}},
+			"responses": verifyResponses(),
+		},
+	}
+	if server.Resend != nil {
+		paths["/resend/{identifier}"] = gin.H{"parameters": []gin.H{identifier}, "post": gin.H{
+			"summary": "Send a pending or expired await again",
+			"responses": gin.H{
+				"202": response("It was sent again."),
+				"401": response("The identifier isn't known."),
+				"409": response("The await has already been resolved."),
+				"429": response("The client, or the await, has been resent too recently; retry after Retry-After."),
+				"503": response("The link couldn't be sent. Try again later."),
+			},
+		}}
+	}
+	if server.Appeals != nil {
+		paths["/appeal"] = gin.H{
+			"get": gin.H{
+				"summary": "Show the form for appealing a block",
+				"responses": gin.H{
+					"200": gin.H{"description": "The form.", "content": htmlContent()},
+					"400": response("The client isn't blocked."),
+				},
+			},
+			"post": gin.H{
+				"summary": "Appeal the block on the client",
+				"requestBody": gin.H{"required": true, "content": gin.H{"application/x-www-form-urlencoded": gin.H{
+					"schema": gin.H{"type": "object", "properties": gin.H{"message": gin.H{"type": "string"}}},
+				}}},
+				"responses": gin.H{
+					"202": response("The appeal was sent."),
+					"400": response("The message is missing or too long, or the client isn't blocked."),
+					"429": response("The client has made too many requests."),
+					"503": response("The appeal couldn't be sent. Try again later."),
+				},
+			},
+		}
+	}
+	if server.AdminToken != "" {
+		admin := []gin.H{{"admin": []string{}}}
+		paths["/admin/metrics"] = gin.H{"get": gin.H{
+			"summary":   "Prometheus metrics",
+			"security":  admin,
+			"responses": gin.H{"200": response("The metrics, in the Prometheus text format."), "401": response("Unauthorised.")},
+		}}
+		paths["/admin/vars"] = gin.H{"get": gin.H{
+			"summary":   "Counters and gauges as JSON",
+			"security":  admin,
+			"responses": gin.H{"200": response("The variables."), "401": response("Unauthorised.")},
+		}}
+		if server.Analytics != nil {
+			paths["/admin/analytics"] = gin.H{"get": gin.H{
+				"summary": "Daily verification funnels per namespace",
+				"parameters": []gin.H{
+					queryParameter("namespace", "Only include this namespace."),
+					queryParameter("days", "Only include this many of the most recent days."),
+				},
+				"security":  admin,
+				"responses": gin.H{"200": response("The rollups and their totals."), "400": response("days is invalid."), "401": response("Unauthorised.")},
+			}}
+		}
+		paths["/admin/live"] = gin.H{"get": gin.H{
+			"summary":   "Stream events over a WebSocket, starting with the awaits that are already pending",
+			"security":  admin,
+			"responses": gin.H{"101": response("Switching to the WebSocket."), "401": response("Unauthorised.")},
+		}}
+		paths["/admin/blocklist"] = gin.H{"post": gin.H{
+			"summary": "Import a blocklist into the runtime blocklist",
+			"parameters": []gin.H{
+				queryParameter("reason", "The reason for entries that don't give one."),
+				queryParameter("ttl", "How long the blocks last, e.g. 24h. They're permanent if omitted."),
+			},
+			"security":  admin,
+			"responses": gin.H{"200": response("How many addresses were imported, and how many entries were skipped."), "400": response("The blocklist or ttl is invalid."), "401": response("Unauthorised.")},
+		}}
+		if server.Dashboard {
+			admin = append(admin, gin.H{"basic": []string{}})
+			paths["/admin/dashboard"] = gin.H{"get": gin.H{
+				"summary":   "The dashboard",
+				"security":  admin,
+				"responses": gin.H{"200": gin.H{"description": "The dashboard.", "content": htmlContent()}, "401": response("Unauthorised.")},
+			}}
+			paths["/admin/dashboard/data"] = gin.H{"get": gin.H{
+				"summary":   "What the dashboard shows",
+				"security":  admin,
+				"responses": gin.H{"200": response("Pending awaits, recent outcomes and the blocklist."), "401": response("Unauthorised.")},
+			}}
+		}
+	}
+
+	document := gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "gotcha", "version": "1"},
+		"paths":   paths,
+		"components": gin.H{"securitySchemes": gin.H{
+			"bearer": gin.H{"type": "http", "scheme": "bearer"},
+			"basic":  gin.H{"type": "http", "scheme": "basic"},
+			"admin":  gin.H{"type": "http", "scheme": "bearer", "description": "Server.AdminToken."},
+		}},
+	}
+	// The paths are relative to the versioned routes; they're served unversioned too, for older links.
+	base := strings.TrimSuffix(server.BaseURL, "/")
+	if base == "" {
+		base = server.prefix
+	}
+	document["servers"] = []gin.H{{"url": base + server.versionPath()}}
+	return document
+}
+
+// failureCodesSchema describes Result.FailureCodes.
+func failureCodesSchema() gin.H {
+	return gin.H{"type": "object", "additionalProperties": gin.H{"type": "integer"},
+		"description": "The failed attempts, by the code they were answered with."}
+}
+
+// credentialsSecurity returns the security requirements for an endpoint protected by credentials, or nil if it
+// isn't protected.
+func credentialsSecurity(credentials *Credentials) []gin.H {
+	if credentials == nil {
+		return nil
+	}
+	security := []gin.H{}
+	if len(credentials.Tokens) > 0 {
+		security = append(security, gin.H{"bearer": []string{}})
+	}
+	if len(credentials.Accounts) > 0 {
+		security = append(security, gin.H{"basic": []string{}})
+	}
+	return security
+}
+
+// verifyResponses describes the responses to a verification request. Browsers get HTML instead of JSON.
+func verifyResponses() gin.H {
+	return gin.H{
+		"200": response("The await was verified, or one of its steps was."),
+		"202": response("The await expired, and a new link was sent in its place. See Server.Reissue."),
+		"303": response("The await was verified and a session cookie was set."),
+		"401": response("The identifier or code is invalid."),
+		"403": response("The client is blocked or was rejected, or the await isn't active yet or is outside its windows."),
+		"405": response("The await can't be verified with this method."),
+		"409": response("The await was already verified, within Server.ReplayWindow."),
+		"410": response("The await expired, or failed its Precondition and was cancelled."),
+		"429": response("The client is being rate limited, or has too many requests in flight."),
+		"503": response("Too many requests are being handled, the server is down for maintenance, or a Precondition couldn't be checked; retry after Retry-After."),
+	}
+}
+
+func response(description string) gin.H {
+	return gin.H{"description": description}
+}
+
+func pathParameter(name, description string) gin.H {
+	return gin.H{"name": name, "in": "path", "required": true, "description": description, "schema": gin.H{"type": "string"}}
+}
+
+func queryParameter(name, description string) gin.H {
+	return gin.H{"name": name, "in": "query", "description": description, "schema": gin.H{"type": "string"}}
+}
+
+func jsonContent(schema gin.H) gin.H {
+	return gin.H{"application/json": gin.H{"schema": schema}}
+}
+
+func htmlContent() gin.H {
+	return gin.H{"text/html": gin.H{"schema": gin.H{"type": "string"}}}
+}
@@ -0,0 +1,165 @@
+package gotcha
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+// Theme customises the default HTML pages.
+type Theme struct {
+	// ProductName is added to page titles.
+	ProductName string
+	// LogoURL is the URL of a logo to show at the top of each page.
+	LogoURL string
+	// AccentColor is the CSS color of headings and buttons. Defaults to a neutral blue.
+	AccentColor string
+
+	// templates, if set, are used instead of the built-in ones. See Server.TemplateDir.
+	templates *template.Template
+	// handleError, if set, is told when a template fails. See Server.ErrorHandler.
+	handleError func(c *gin.Context, err error) bool
+	// appeal is the path of /appeal, if Server.Appeals is set.
+	appeal string
+	// resend is the path that /resend/:identifier is under, if Server.Resend is set, and csrfToken returns the token
+	// for its form.
+	resend    string
+	csrfToken func(c *gin.Context) string
+	// unknownPage is UnknownIdentifiers.Page.
+	unknownPage string
+}
+
+// Accent returns AccentColor, or the default.
+func (theme Theme) Accent() string {
+	if theme.AccentColor == "" {
+		return "#0a66c2"
+	}
+	return theme.AccentColor
+}
+
+// page is the data that templates are executed with.
+type page struct {
+	Theme    Theme
+	Heading  string
+	Text     string
+	UserCode string
+	// Action is where the form on the confirmation or device page is posted.
+	Action string
+	// Nonce is posted by the confirmation page's form, so that it can only be submitted once.
+	Nonce string
+	// CSRF is the token that forms post as "csrf_token" when Server.CSRF is enabled.
+	CSRF string
+	// Challenge, RPID and Credentials are for the passkey assertion on the confirmation page.
+	Challenge   string
+	RPID        string
+	Credentials []string
+	// Fingerprint makes the confirmation page set FingerprintCookie.
+	Fingerprint bool
+	// AppLink and Fallback, on the page that opens an app, are its link and where to go if it doesn't open.
+	AppLink  template.URL
+	Fallback string
+	// Appeal, on the block page, is where the client can appeal the block. MaxLength is the longest appeal allowed.
+	Appeal    string
+	MaxLength int
+	// Context describes the response, or, on the confirmation page, the await that's being confirmed. See
+	// RenderContext.
+	Context RenderContext
+}
+
+// Render renders ctx as one of the default HTML pages, chosen by its status, for custom Renderers to fall back to.
+func (theme Theme) Render(c *gin.Context, ctx RenderContext) {
+	status, body := ctx.Status, ctx.Body
+	data := page{Theme: theme, Heading: http.StatusText(status), Text: body["message"], Context: ctx}
+	switch status {
+	case http.StatusOK:
+		data.Heading, data.Text = "You're verified", "Thanks! You can close this page now."
+		if ctx.Code == "step_verified" {
+			// The other steps are still to do, so the user mustn't think that they're done.
+			data.Heading, data.Text = "Step complete", "Thanks! Continue with the next step to finish verifying."
+		}
+	case http.StatusBadRequest:
+		if ctx.Code == "not_blocked" {
+			data.Heading, data.Text = "You're not blocked", "There's nothing to appeal."
+		}
+	case http.StatusAccepted:
+		data.Heading, data.Text = "Thanks", "We'll look into it, and unblock you if it was a mistake."
+		if ctx.Code == "resent" {
+			data.Heading, data.Text = "Check your inbox", "We've sent you a new link."
+		}
+		if ctx.Code == "reissued" {
+			data.Heading, data.Text = "This link has expired", "We've sent you a new one. Check your inbox."
+		}
+	case http.StatusConflict:
+		if ctx.Code == "already_used" {
+			data.Heading, data.Text = "This link has already been used", "You're verified. You can close this page now."
+		}
+	case http.StatusServiceUnavailable:
+		if ctx.Code == "maintenance" {
+			data.Heading, data.Text = "Down for maintenance", body["reason"]
+		}
+	case http.StatusGone:
+		data.Heading, data.Text = "This link has expired", "Request a new link and try again."
+		if ctx.Code == "precondition_failed" {
+			data.Heading, data.Text = "This link is no longer valid", "There's nothing left to verify."
+			break
+		}
+		if identifier := c.Param("identifier"); theme.resend != "" && ctx.Await != nil && identifier != "" {
+			data.Text = "We can send you a new one."
+			data.Action, data.CSRF = theme.resend+url.PathEscape(identifier), theme.csrfToken(c)
+		}
+	case http.StatusForbidden:
+		data.Heading, data.Text = "You've been blocked", body["reason"]
+		if ctx.Code == "blocked" {
+			data.Appeal = theme.appeal
+		}
+		if body["activates"] != "" {
+			data.Heading, data.Text = "This link isn't active yet", "Try again once it's active."
+		}
+		if ctx.Code == "outside_window" {
+			data.Heading, data.Text = "This link can't be used right now", "Try again during the hours that it's open."
+		}
+		if ctx.Code == "invalid_csrf_token" {
+			data.Heading = "This form has expired"
+		}
+	case http.StatusUnauthorized:
+		data.Heading, data.Text = "This link isn't valid", "Make sure that you opened the whole link, or request a new one."
+		if reason := body["reason"]; reason != "" {
+			data.Heading, data.Text = "We couldn't verify you", reason
+		}
+	}
+	name := "result.html"
+	if ctx.Await != nil && ctx.Await.template != "" {
+		name = ctx.Await.template
+	}
+	if ctx.Code == "invalid_identifier" {
+		// Whatever status it's answered with.
+		data.Heading, data.Text = "This link isn't valid", "Make sure that you opened the whole link, or request a new one."
+		if theme.unknownPage != "" {
+			name = theme.unknownPage
+		}
+	}
+	theme.execute(c, status, name, data)
+}
+
+func (theme Theme) execute(c *gin.Context, status int, name string, data page) {
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	parsed := theme.templates
+	if parsed == nil {
+		parsed = templates
+	}
+	if err := parsed.ExecuteTemplate(c.Writer, name, data); err != nil {
+		c.Error(err)
+		if theme.handleError != nil {
+			theme.handleError(c, err)
+		}
+	}
+}
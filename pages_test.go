@@ -0,0 +1,28 @@
+package gotcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRenderStepVerified(t *testing.T) {
+	for _, test := range []struct {
+		code, want, notWant string
+	}{
+		{"step_verified", "Continue with the next step", "You can close this page"},
+		{"verified", "You can close this page", "Continue with the next step"},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/verify/a", nil)
+		Theme{}.Render(c, RenderContext{Status: http.StatusOK, Code: test.code, Body: map[string]string{"step": "email"}})
+		body := w.Body.String()
+		if !strings.Contains(body, test.want) || strings.Contains(body, test.notWant) {
+			t.Errorf("%s page doesn't say %q, or says %q:\n%s", test.code, test.want, test.notWant, body)
+		}
+	}
+}
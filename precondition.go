@@ -0,0 +1,72 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Precondition asks another system whether an await can still be verified, right before it's fulfilled, such as
+// whether the user that it confirms still exists or an invitation is still open, so that stale links can't confirm
+// accounts that were deleted in the meantime.
+type Precondition struct {
+	// Check reports whether the await can still be verified. If it can't, the await is cancelled and the client is
+	// told that their link is no longer valid.
+	Check func(ctx context.Context, pending PendingAwait) (bool, error)
+	// Timeout is the longest that Check can take. Defaults to 2 seconds.
+	Timeout time.Duration
+	// FailOpen lets requests through if Check fails or times out. Otherwise they get a 503 Service Unavailable and
+	// the await stays pending, so that they can try again.
+	FailOpen bool
+}
+
+// stalePreconditionReason is shown to clients whose await failed its Precondition.
+const stalePreconditionReason = "This link is no longer valid."
+
+// checkPrecondition runs Precondition for the await for key, reporting whether it can be fulfilled and, if it can't,
+// what to respond with. The server's lock must be held shared and shard's lock held; they're released while Check
+// runs.
+func (server *Server) checkPrecondition(c *gin.Context, shard *awaitShard, key string, entry *awaited, current *step,
+	body map[string]string) (int, string, bool) {
+	precondition := server.Precondition
+	timeout := precondition.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	pending := entry.pendingAwait(key, current)
+	server.unlockAwait(shard)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	valid, err := precondition.Check(ctx, pending)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	cancel()
+	server.relockAwait(shard)
+
+	if !entry.pending() {
+		// Another request resolved it while Check was running.
+		return server.unknownStatus(), "invalid_identifier", false
+	}
+	if err != nil {
+		server.logger().Error("gotcha: precondition failed", "identifier", key, "fail_open", precondition.FailOpen,
+			"error", err)
+		if !errors.Is(err, context.Canceled) {
+			server.handleError(nil, fmt.Errorf("gotcha: precondition: %w", err))
+		}
+		if precondition.FailOpen {
+			return 0, "", true
+		}
+		c.Header("Retry-After", "5")
+		return http.StatusServiceUnavailable, "precondition_unavailable", false
+	}
+	if !valid {
+		server.resolve(key, entry, StatusCancelled)
+		body["reason"] = stalePreconditionReason
+		return http.StatusGone, "precondition_failed", false
+	}
+	return 0, "", true
+}
@@ -0,0 +1,104 @@
+package gotcha
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultPrefetchUserAgents are the User-Agent substrings that Prefetch looks for by default: mail security scanners,
+// link previewers and HTTP libraries.
+var DefaultPrefetchUserAgents = []string{
+	"barracuda", "mimecast", "proofpoint", "safelinks", "microsoft office", "bingpreview", "googleimageproxy",
+	"facebookexternalhit", "slackbot", "twitterbot", "linkedinbot", "whatsapp", "telegrambot", "discordbot",
+	"skypeuripreview", "headlesschrome", "python-requests", "go-http-client", "curl/", "wget/", "bot", "crawler",
+	"spider", "scanner",
+}
+
+// Prefetch spots verification requests that were probably made by a mail scanner or link previewer, rather than the
+// user, and shows them the confirmation page as if Server.Confirm were set, so that the await isn't consumed. Every
+// other request is verified straight away. Probable prefetches are flagged with "prefetch:timing",
+// "prefetch:network" and "prefetch:user_agent" for the checks that matched, and reported with EventPrefetched.
+type Prefetch struct {
+	// Within is how soon after an await becomes active that a request for it is suspect, since mail scanners fetch
+	// links as soon as they're delivered. Defaults to 5 seconds; a negative duration turns the check off.
+	Within time.Duration
+	// Networks are address ranges, such as cloud providers', that requests from are suspect.
+	Networks []netip.Prefix
+	// UserAgents are case-insensitive substrings of the User-Agent headers that are suspect. Defaults to
+	// DefaultPrefetchUserAgents.
+	UserAgents []string
+	// Signals is how many of the checks must match for a request to be treated as a prefetch. Defaults to 1.
+	Signals int
+}
+
+// classify returns the flags for the checks that the request for entry, from clientIP, matches, or nil if it isn't a
+// probable prefetch. The server's lock must be held.
+func (prefetch *Prefetch) classify(c *gin.Context, clientIP string, entry *awaited) []string {
+	var flags []string
+	within := prefetch.Within
+	if within == 0 {
+		within = 5 * time.Second
+	}
+	if within > 0 && time.Since(entry.validFrom()) < within {
+		flags = append(flags, "prefetch:timing")
+	}
+	if addr, err := netip.ParseAddr(clientIP); err == nil {
+		for _, network := range prefetch.Networks {
+			if network.Contains(addr.Unmap()) {
+				flags = append(flags, "prefetch:network")
+				break
+			}
+		}
+	}
+	userAgents := prefetch.UserAgents
+	if userAgents == nil {
+		userAgents = DefaultPrefetchUserAgents
+	}
+	userAgent := strings.ToLower(c.Request.UserAgent())
+	for _, substring := range userAgents {
+		if substring != "" && strings.Contains(userAgent, strings.ToLower(substring)) {
+			flags = append(flags, "prefetch:user_agent")
+			break
+		}
+	}
+	signals := prefetch.Signals
+	if signals <= 0 {
+		signals = 1
+	}
+	if len(flags) < signals {
+		return nil
+	}
+	return flags
+}
+
+// prefetched reports whether the GET request for key is a probable prefetch of a pending await, flagging it and
+// emitting EventPrefetched if it is.
+func (server *Server) prefetched(c *gin.Context, key string) bool {
+	if server.Prefetch == nil || c.Request.Method != "GET" || isDryRun(c) {
+		return false
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	awaitKey, entry, _, ok := server.lookupIn(c, key)
+	if !ok || !entry.pending() || entry.scheduled() || !entry.open(time.Now()) {
+		return false
+	}
+	flags := server.Prefetch.classify(c, server.clientIP(c), entry)
+	if flags == nil {
+		return false
+	}
+	for _, flag := range flags {
+		addFlag(c, flag)
+	}
+	server.emit(Event{Type: EventPrefetched, Identifier: awaitKey, Namespace: entry.namespace, Time: time.Now(),
+		ClientIP: server.clientIP(c), RequestID: requestIDOf(c), TraceParent: traceParent(c), Flags: flags})
+	return true
+}
+
+// confirms reports whether the confirmation page, and POST /confirm/:identifier, are served.
+func (server *Server) confirms() bool {
+	return server.Confirm || server.Prefetch != nil
+}
@@ -0,0 +1,105 @@
+package gotcha
+
+import "net/netip"
+
+// prefixTrie matches addresses against CIDR prefixes, such as those of threat feeds, in time proportional to the
+// length of an address rather than the number of prefixes. It's a path-compressed binary trie, with one root for IPv4
+// and one for IPv6. The most specific prefix that contains an address wins. It isn't safe for concurrent writes.
+type prefixTrie struct {
+	v4, v6 *trieNode
+}
+
+type trieNode struct {
+	prefix   netip.Prefix
+	reason   string
+	set      bool
+	children [2]*trieNode
+}
+
+// insert adds prefix, which must be masked, with reason, replacing it if it's already there.
+func (trie *prefixTrie) insert(prefix netip.Prefix, reason string) {
+	root := &trie.v6
+	if prefix.Addr().Is4() {
+		root = &trie.v4
+	}
+	if *root == nil {
+		*root = &trieNode{prefix: netip.PrefixFrom(prefix.Addr(), 0).Masked()}
+	}
+	node := *root
+	for {
+		if node.prefix == prefix {
+			node.reason, node.set = reason, true
+			return
+		}
+		// node's prefix contains prefix, and is shorter.
+		slot := &node.children[bitAt(prefix.Addr(), node.prefix.Bits())]
+		child := *slot
+		if child == nil {
+			*slot = &trieNode{prefix: prefix, reason: reason, set: true}
+			return
+		}
+		if child.prefix.Bits() <= prefix.Bits() && child.prefix.Contains(prefix.Addr()) {
+			node = child
+			continue
+		}
+		common := commonBits(child.prefix.Addr(), prefix.Addr(), min(child.prefix.Bits(), prefix.Bits()))
+		if common == prefix.Bits() {
+			// The new prefix contains the child, so it goes between them.
+			inserted := &trieNode{prefix: prefix, reason: reason, set: true}
+			inserted.children[bitAt(child.prefix.Addr(), common)] = child
+			*slot = inserted
+			return
+		}
+		// They diverge, so they hang off a new node for what they have in common.
+		split := &trieNode{prefix: netip.PrefixFrom(prefix.Addr(), common).Masked()}
+		split.children[bitAt(child.prefix.Addr(), common)] = child
+		split.children[bitAt(prefix.Addr(), common)] = &trieNode{prefix: prefix, reason: reason, set: true}
+		*slot = split
+		return
+	}
+}
+
+// lookup returns the reason of the most specific prefix that contains addr, if there is one.
+func (trie *prefixTrie) lookup(addr netip.Addr) (string, bool) {
+	if trie == nil {
+		return "", false
+	}
+	node := trie.v6
+	if addr.Is4() {
+		node = trie.v4
+	}
+	var match *trieNode
+	for node != nil && node.prefix.Contains(addr) {
+		if node.set {
+			match = node
+		}
+		if node.prefix.Bits() == addr.BitLen() {
+			break
+		}
+		node = node.children[bitAt(addr, node.prefix.Bits())]
+	}
+	if match == nil {
+		return "", false
+	}
+	return match.reason, true
+}
+
+// bitAt returns the bit of addr at index i, counting from the most significant.
+func bitAt(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		bytes := addr.As4()
+		return int(bytes[i/8]>>(7-i%8)) & 1
+	}
+	bytes := addr.As16()
+	return int(bytes[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits returns how many of the leading bits of a and b, up to limit, are the same.
+func commonBits(a, b netip.Addr, limit int) int {
+	for i := 0; i < limit; i++ {
+		if bitAt(a, i) != bitAt(b, i) {
+			return i
+		}
+	}
+	return limit
+}
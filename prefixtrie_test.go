@@ -0,0 +1,178 @@
+package gotcha
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// scanPrefixes is the linear scan that prefixTrie replaced, picking the most specific match as the trie does.
+type scanPrefixes []scanPrefix
+
+type scanPrefix struct {
+	network *net.IPNet
+	bits    int
+	reason  string
+}
+
+func (prefixes scanPrefixes) lookup(addr netip.Addr) (string, bool) {
+	ip := net.IP(addr.AsSlice())
+	best := -1
+	for i, prefix := range prefixes {
+		if prefix.network.Contains(ip) && (best < 0 || prefix.bits > prefixes[best].bits) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return prefixes[best].reason, true
+}
+
+// buildPrefixes returns a trie and a scan holding the same prefixes, which are given as CIDRs.
+func buildPrefixes(t testing.TB, cidrs map[string]string) (*prefixTrie, scanPrefixes) {
+	trie := &prefixTrie{}
+	var scan scanPrefixes
+	for cidr, reason := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix = prefix.Masked()
+		trie.insert(prefix, reason)
+		_, network, err := net.ParseCIDR(prefix.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		scan = append(scan, scanPrefix{network: network, bits: prefix.Bits(), reason: reason})
+	}
+	return trie, scan
+}
+
+func TestPrefixTrie(t *testing.T) {
+	trie, scan := buildPrefixes(t, map[string]string{
+		"10.0.0.0/8":        "10/8",
+		"10.1.0.0/16":       "10.1/16",
+		"10.1.2.0/24":       "10.1.2/24",
+		"10.1.2.3/32":       "10.1.2.3",
+		"10.128.0.0/9":      "10.128/9",
+		"192.168.0.0/24":    "192.168/24",
+		"192.168.1.0/24":    "192.168.1/24",
+		"0.0.0.0/0":         "any v4",
+		"2001:db8::/32":     "doc",
+		"2001:db8:1::/48":   "doc:1",
+		"2001:db8:1::1/128": "doc:1::1",
+		"fe80::/10":         "link-local",
+	})
+	for _, test := range []struct {
+		addr   string
+		reason string
+		ok     bool
+	}{
+		{"10.0.0.1", "10/8", true},
+		{"10.1.0.1", "10.1/16", true},
+		{"10.1.2.1", "10.1.2/24", true},
+		{"10.1.2.3", "10.1.2.3", true},
+		{"10.200.0.1", "10.128/9", true},
+		{"192.168.0.255", "192.168/24", true},
+		{"192.168.1.0", "192.168.1/24", true},
+		{"192.168.2.1", "any v4", true},
+		{"8.8.8.8", "any v4", true},
+		{"2001:db8::1", "doc", true},
+		{"2001:db8:1::2", "doc:1", true},
+		{"2001:db8:1::1", "doc:1::1", true},
+		{"fe80::1", "link-local", true},
+		{"2001:db9::1", "", false},
+		{"::1", "", false},
+	} {
+		addr := netip.MustParseAddr(test.addr)
+		reason, ok := trie.lookup(addr)
+		if reason != test.reason || ok != test.ok {
+			t.Errorf("lookup(%s) = %q, %v, want %q, %v", test.addr, reason, ok, test.reason, test.ok)
+		}
+		if scanReason, scanOK := scan.lookup(addr); scanReason != reason || scanOK != ok {
+			t.Errorf("lookup(%s) = %q, %v, but the scan gives %q, %v", test.addr, reason, ok, scanReason, scanOK)
+		}
+	}
+}
+
+func TestPrefixTrieMatchesScan(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	trie, scan := buildPrefixes(t, randomPrefixes(random, 2000))
+	for i := 0; i < 20000; i++ {
+		addr := randomAddr(random)
+		if i%2 == 0 {
+			// Look up addresses inside the prefixes too, which random ones mostly aren't.
+			network := scan[random.Intn(len(scan))].network
+			addr = addrIn(random, network)
+		}
+		reason, ok := trie.lookup(addr)
+		if scanReason, scanOK := scan.lookup(addr); scanReason != reason || scanOK != ok {
+			t.Fatalf("lookup(%s) = %q, %v, but the scan gives %q, %v", addr, reason, ok, scanReason, scanOK)
+		}
+	}
+}
+
+func randomPrefixes(random *rand.Rand, n int) map[string]string {
+	cidrs := map[string]string{}
+	for len(cidrs) < n {
+		addr := randomAddr(random)
+		bits := random.Intn(addr.BitLen() + 1)
+		prefix := netip.PrefixFrom(addr, bits).Masked()
+		cidrs[prefix.String()] = prefix.String()
+	}
+	return cidrs
+}
+
+// randomAddr returns an IPv4 address three times out of four, or an IPv6 one in 2001:db8::/32, so that prefixes
+// overlap often enough to exercise the trie.
+func randomAddr(random *rand.Rand) netip.Addr {
+	if random.Intn(4) > 0 {
+		var b [4]byte
+		random.Read(b[:])
+		b[0] &= 0x0f
+		return netip.AddrFrom4(b)
+	}
+	b := [16]byte{0x20, 0x01, 0x0d, 0xb8}
+	random.Read(b[4:])
+	return netip.AddrFrom16(b)
+}
+
+// addrIn returns a random address in network.
+func addrIn(random *rand.Rand, network *net.IPNet) netip.Addr {
+	ip := make(net.IP, len(network.IP))
+	random.Read(ip)
+	for i := range ip {
+		ip[i] = network.IP[i] | ip[i]&^network.Mask[i]
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr
+}
+
+func benchmarkPrefixes(b *testing.B) (*prefixTrie, scanPrefixes, []netip.Addr) {
+	random := rand.New(rand.NewSource(1))
+	trie, scan := buildPrefixes(b, randomPrefixes(random, 10000))
+	addrs := make([]netip.Addr, 1024)
+	for i := range addrs {
+		addrs[i] = randomAddr(random)
+	}
+	return trie, scan, addrs
+}
+
+func BenchmarkPrefixTrie(b *testing.B) {
+	trie, _, addrs := benchmarkPrefixes(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.lookup(addrs[i%len(addrs)])
+	}
+}
+
+// BenchmarkPrefixScan is the baseline: the linear scan that RemoteBlockList used before the trie.
+func BenchmarkPrefixScan(b *testing.B) {
+	_, scan, addrs := benchmarkPrefixes(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scan.lookup(addrs[i%len(addrs)])
+	}
+}
@@ -0,0 +1,78 @@
+package gotcha
+
+import (
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Priority decides which awaits give way when the server is under load. See AwaitRequest.Priority.
+type Priority int
+
+// Priorities, from lowest to highest.
+const (
+	// PriorityLow is for bulk flows, such as marketing double opt-ins, which are the first to be evicted when
+	// MaxPending awaits are pending.
+	PriorityLow Priority = -1
+	// PriorityNormal is the default.
+	PriorityNormal Priority = 0
+	// PriorityCritical is for security-critical flows, such as password resets. Requests to verify them can use
+	// Limits.CriticalReserve once Limits.MaxInFlight are being handled. They're rate limited like any other, so that
+	// their codes can't be guessed any faster.
+	PriorityCritical Priority = 1
+)
+
+// victim is a pending await to be evicted.
+type victim struct {
+	key   string
+	entry *awaited
+}
+
+// evictable returns the n pending awaits to evict to make room for awaits with priority: those with a lower one, lowest
+// and then oldest first, other than those in keep. It returns false if there aren't enough. The server's lock must be
+// held.
+func (server *Server) evictable(n int, priority Priority, keep map[string]bool) ([]victim, bool) {
+	available := 0
+	for pending, count := range server.pendingPriorities {
+		if pending < priority {
+			available += count
+		}
+	}
+	if available < n {
+		return nil, false
+	}
+	var victims []victim
+	server.awaits.each(func(key string, entry *awaited) bool {
+		if entry.pending() && entry.priority < priority && !keep[key] {
+			victims = append(victims, victim{key, entry})
+		}
+		return true
+	})
+	if len(victims) < n {
+		return nil, false
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		if victims[i].entry.priority != victims[j].entry.priority {
+			return victims[i].entry.priority < victims[j].entry.priority
+		}
+		return victims[i].entry.start.Before(victims[j].entry.start)
+	})
+	return victims[:n], true
+}
+
+// critical reports whether c is a request to verify a pending await with PriorityCritical.
+func (server *Server) critical(c *gin.Context) bool {
+	identifier := c.Param("identifier")
+	if identifier == "" {
+		return false
+	}
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	key, entry, _, ok := server.lookup(server.HashIdentifier(identifier))
+	if !ok {
+		return false
+	}
+	shard := server.lockAwait(key)
+	defer shard.mu.Unlock()
+	return entry.pending() && entry.priority >= PriorityCritical
+}
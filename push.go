@@ -0,0 +1,48 @@
+package gotcha
+
+import "context"
+
+// MetadataPushToken is the AwaitRequest.Metadata key of the push token of the device that should be asked to
+// approve the await. See Server.Notifier.
+const MetadataPushToken = "push_token"
+
+// Notification is a push notification asking a user to approve an await.
+type Notification struct {
+	Title string
+	Body  string
+	// Data is delivered to the app along with the notification. It holds the identifier and namespace of the await,
+	// so that the app can approve it.
+	Data map[string]string
+}
+
+// Notifier sends push notifications, such as through FCM or APNs. See gotchapush.
+type Notifier interface {
+	// Notify sends notification to the device with token.
+	Notify(ctx context.Context, token string, notification Notification) error
+}
+
+// notify sends a push notification in the background for an await that's just been registered, if it has a push
+// token.
+func (server *Server) notify(identifier string, pending PendingAwait) {
+	token, notifier, message, logger := pending.Metadata[MetadataPushToken], server.Notifier, server.Notification,
+		server.logger()
+	if notifier == nil || token == "" {
+		return
+	}
+	go func() {
+		notification := Notification{Title: "Approval requested", Body: "Tap to review this request."}
+		if message != nil {
+			notification = message(pending)
+		}
+		if notification.Data == nil {
+			notification.Data = map[string]string{}
+		}
+		notification.Data["identifier"] = identifier
+		if pending.Namespace != "" {
+			notification.Data["namespace"] = pending.Namespace
+		}
+		if err := notifier.Notify(context.Background(), token, notification); err != nil {
+			logger.Warn("gotcha: push notification failed", "identifier", pending.Identifier, "error", err)
+		}
+	}()
+}
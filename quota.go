@@ -0,0 +1,105 @@
+package gotcha
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOverQuota is returned by Register when an await's Namespace has used up its Quota.
+var ErrOverQuota = errors.New("gotcha: namespace is over its quota")
+
+// Quota caps how much of a shared server one namespace can use, so that a busy tenant can't starve the others.
+type Quota struct {
+	// MaxPending is the most awaits that the namespace can have pending at once. Zero doesn't limit them.
+	MaxPending int
+	// PerHour is the most awaits that the namespace can register in an hour. Zero doesn't limit them.
+	PerHour int
+}
+
+// Usage is how much of its Quota a namespace is using.
+type Usage struct {
+	Quota Quota
+	// Pending is how many of the namespace's awaits are pending.
+	Pending int
+	// Registered is how many awaits the namespace has registered in the current hour.
+	Registered int
+}
+
+// quota returns the Quota for namespace: its entry in Quotas, or DefaultQuota.
+func (server *Server) quota(namespace string) Quota {
+	if quota, ok := server.Quotas[namespace]; ok {
+		return quota
+	}
+	return server.DefaultQuota
+}
+
+// Usage returns how much of its Quota namespace is using.
+func (server *Server) Usage(namespace string) Usage {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.usage(namespace, time.Now())
+}
+
+// usage is Usage for when the server's lock is held.
+func (server *Server) usage(namespace string, now time.Time) Usage {
+	usage := Usage{Quota: server.quota(namespace), Pending: server.namespacePending[namespace]}
+	if window, ok := server.namespaceRegistrations[namespace]; ok && now.Sub(window.start) < time.Hour {
+		usage.Registered = window.count
+	}
+	return usage
+}
+
+// checkQuotas returns ErrOverQuota if registering added awaits in each namespace, of which replaced are replacing
+// pending ones, would take it over its Quota. The server's lock must be held.
+func (server *Server) checkQuotas(added, replaced map[string]int) error {
+	if server.Quotas == nil && server.DefaultQuota == (Quota{}) {
+		return nil
+	}
+	now := time.Now()
+	for namespace, n := range added {
+		usage := server.usage(namespace, now)
+		if limit := usage.Quota.MaxPending; limit > 0 && usage.Pending-replaced[namespace]+n > limit {
+			server.metrics.observeOverQuota(namespace)
+			return fmt.Errorf("%w: %q can't have more than %d awaits pending", ErrOverQuota, namespace, limit)
+		}
+		if limit := usage.Quota.PerHour; limit > 0 && usage.Registered+n > limit {
+			server.metrics.observeOverQuota(namespace)
+			return fmt.Errorf("%w: %q can't register more than %d awaits an hour", ErrOverQuota, namespace, limit)
+		}
+	}
+	return nil
+}
+
+// countRegistration counts an await registered in namespace towards its hourly quota. The server's lock must be held.
+func (server *Server) countRegistration(namespace string, now time.Time) {
+	if server.namespaceRegistrations == nil {
+		server.namespaceRegistrations = map[string]*rateWindow{}
+	}
+	window, ok := server.namespaceRegistrations[namespace]
+	if !ok || now.Sub(window.start) >= time.Hour {
+		for namespace, window := range server.namespaceRegistrations {
+			if now.Sub(window.start) >= time.Hour {
+				delete(server.namespaceRegistrations, namespace)
+			}
+		}
+		window = &rateWindow{start: now}
+		server.namespaceRegistrations[namespace] = window
+	}
+	window.count++
+}
+
+// namespaceUsage returns the Usage of every namespace with pending awaits or registrations this hour.
+func (server *Server) namespaceUsage() map[string]Usage {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	now := time.Now()
+	usage := map[string]Usage{}
+	for namespace := range server.namespacePending {
+		usage[namespace] = server.usage(namespace, now)
+	}
+	for namespace := range server.namespaceRegistrations {
+		usage[namespace] = server.usage(namespace, now)
+	}
+	return usage
+}
@@ -0,0 +1,114 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter limits how often clients can make verification requests.
+type RateLimiter interface {
+	// Allow counts a request against key, reporting whether it's within the limit and, if it isn't, how long until
+	// the next one would be.
+	Allow(ctx context.Context, key string) (ok bool, retryAfter time.Duration, err error)
+}
+
+// RegistrationLimitError is returned by Register when Server.RegistrationLimiter won't let an await's Namespace
+// register any more yet.
+type RegistrationLimitError struct {
+	Namespace string
+	// RetryAfter is how long until the namespace can register again.
+	RetryAfter time.Duration
+}
+
+func (err *RegistrationLimitError) Error() string {
+	return fmt.Sprintf("gotcha: namespace %q is registering awaits too quickly; retry after %s", err.Namespace, err.RetryAfter)
+}
+
+// limitRegistrations counts the reqs that don't have a Handle yet against RegistrationLimiter, returning a
+// *RegistrationLimitError for the first namespace that's over the limit.
+func (server *Server) limitRegistrations(reqs []AwaitRequest, handles []*Handle) error {
+	if server.RegistrationLimiter == nil {
+		return nil
+	}
+	for i, req := range reqs {
+		if handles[i] != nil {
+			// Already registered with its idempotency key.
+			continue
+		}
+		ok, retryAfter, err := server.RegistrationLimiter.Allow(context.Background(), req.Namespace)
+		if err == nil && !ok {
+			return &RegistrationLimitError{Namespace: req.Namespace, RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
+
+// NewRateLimiter returns a RateLimiter that allows limit requests per key in each window. It only counts requests made
+// to this process, so deployments behind a load balancer should use a shared implementation such as the one in
+// gotcharedis.
+func NewRateLimiter(limit int, window time.Duration) RateLimiter {
+	return &memoryRateLimiter{limit: limit, window: window, windows: map[string]*rateWindow{}}
+}
+
+type memoryRateLimiter struct {
+	limit   int
+	window  time.Duration
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+	sweepAt int
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func (limiter *memoryRateLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	now := time.Now()
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if len(limiter.windows) >= limiter.sweepAt {
+		for key, window := range limiter.windows {
+			if now.Sub(window.start) >= limiter.window {
+				delete(limiter.windows, key)
+			}
+		}
+		limiter.sweepAt = 2*len(limiter.windows) + 64
+	}
+
+	window, ok := limiter.windows[key]
+	if !ok || now.Sub(window.start) >= limiter.window {
+		window = &rateWindow{start: now}
+		limiter.windows[key] = window
+	}
+	window.count++
+	if window.count > limiter.limit {
+		return false, window.start.Add(limiter.window).Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// rateLimit is middleware that applies RateLimiter to verification requests, keyed by client IP, or by IPv6Prefix. Requests are let
+// through if the limiter fails, so that an outage in a shared backend doesn't take verification down with it.
+func (server *Server) rateLimit(c *gin.Context) {
+	limiter := server.rateLimiter()
+	if limiter == nil || isForwarded(c) {
+		// Forwarded requests were already counted by the instance that received them.
+		return
+	}
+	ok, retryAfter, err := limiter.Allow(c.Request.Context(), server.clientKey(server.clientIP(c)))
+	if err != nil || ok {
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	server.renderError(c, http.StatusTooManyRequests, "rate_limited")
+	c.Abort()
+}
@@ -0,0 +1,71 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reissue replaces awaits whose links are opened after they've expired, so that users don't have to ask for new
+// ones: a replacement is registered under a new identifier, with the expired await's namespace, metadata, code and
+// restrictions, and the user is told that a new link is on its way. Each await is only replaced once, and awaits with
+// steps aren't replaced. Expired awaits are kept for Retention, or Resend.Grace, which is how long after expiring
+// their links are replaced.
+type Reissue struct {
+	// Deliver is called to send the replacement's link, such as VerifyURL(replacement.Identifier). If it fails, the
+	// replacement is cancelled and the user is told that their link expired.
+	Deliver func(ctx context.Context, expired PendingAwait, replacement *Handle) error
+}
+
+// reissue replaces the expired await for key, reporting whether it did. It mustn't be called with the server's lock
+// held.
+func (server *Server) reissue(c *gin.Context, key string) bool {
+	server.mu.Lock()
+	awaitKey, entry, current, ok := server.lookupIn(c, key)
+	if !ok || current != nil || entry.pending() || entry.status != StatusExpired || !entry.resent.Before(entry.expires) {
+		server.mu.Unlock()
+		return false
+	}
+	previous := entry.resent
+	entry.resent = time.Now()
+	expired := entry.pendingAwait(awaitKey, nil)
+	req := AwaitRequest{
+		Identifier:  newChallenge(),
+		Namespace:   entry.namespace,
+		Metadata:    expired.Metadata,
+		Code:        entry.code,
+		Methods:     entry.methods,
+		AllowList:   entry.allowList,
+		BlockList:   entry.blockList,
+		MaxAttempts: entry.maxAttempts,
+		Priority:    entry.priority,
+		Windows:     entry.windows,
+		AppLink:     entry.appLink,
+		WebFallback: entry.webFallback,
+		Renderer:    entry.renderer,
+		Template:    entry.template,
+		Headers:     entry.responseHeaders,
+	}
+	server.mu.Unlock()
+
+	replacement, err := server.Register(req)
+	if err == nil {
+		if err = server.Reissue.Deliver(c.Request.Context(), expired, replacement); err != nil {
+			server.Cancel(replacement.Identifier)
+		}
+	}
+	if err != nil {
+		server.mu.Lock()
+		entry.resent = previous
+		server.mu.Unlock()
+		server.logger().Error("gotcha: reissuing failed", "identifier", awaitKey, "error", err)
+		server.handleError(nil, fmt.Errorf("gotcha: reissuing: %w", err))
+		return false
+	}
+	server.metrics.observeReissue()
+	server.logger().Info("gotcha: reissued", "identifier", awaitKey, "replacement", server.HashIdentifier(req.Identifier),
+		"namespace", req.Namespace)
+	return true
+}
@@ -0,0 +1,100 @@
+package gotcha
+
+import (
+	"html/template"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reload changes the server's settings while it's running, without dropping pending awaits or connections. update is
+// called with the server locked, and can change Timeout, BlockList, RateLimiter, Theme and TemplateDir. Pending awaits
+// keep the Timeout that they were registered with. Templates are reparsed from TemplateDir; if they can't be parsed,
+// the old ones are kept and the error is returned.
+func (server *Server) Reload(update func(server *Server)) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	update(server)
+	return server.parseTemplates()
+}
+
+// parseTemplates parses the templates in TemplateDir over the built-in ones, and those in each Tenant's TemplateDir
+// over them. The server's lock must be held.
+func (server *Server) parseTemplates() error {
+	parsed, err := parseTemplateDirs(server.TemplateDir)
+	if err != nil {
+		return err
+	}
+	tenantTemplates := map[string]*template.Template{}
+	for namespace, tenant := range server.Tenants {
+		if tenant.TemplateDir == "" {
+			continue
+		}
+		if tenantTemplates[namespace], err = parseTemplateDirs(server.TemplateDir, tenant.TemplateDir); err != nil {
+			return err
+		}
+	}
+	server.templates, server.tenantTemplates = parsed, tenantTemplates
+	return nil
+}
+
+// parseTemplateDirs parses the templates in each of dirs that's set, in turn, over the built-in ones. It returns nil
+// if none of them are set.
+func parseTemplateDirs(dirs ...string) (*template.Template, error) {
+	var parsed *template.Template
+	var err error
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if parsed == nil {
+			// The built-in templates can't be cloned once they've been executed, so parse them again.
+			if parsed, err = template.ParseFS(templateFiles, "templates/*.html"); err != nil {
+				return nil, err
+			}
+		}
+		if parsed, err = parsed.ParseGlob(filepath.Join(dir, "*.html")); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// currentTheme returns the Theme for the request, set up to execute the server's templates: Theme, or that of the
+// Tenant that the request is for. c can be nil. The server's lock must be held.
+func (server *Server) currentTheme(c *gin.Context) Theme {
+	theme, templates := server.Theme, server.templates
+	if namespace, ok := namespaceOf(c); ok {
+		if tenant := server.Tenants[namespace]; tenant.Theme != nil {
+			theme = *tenant.Theme
+		}
+		if parsed, ok := server.tenantTemplates[namespace]; ok {
+			templates = parsed
+		}
+	}
+	theme.templates, theme.handleError = templates, server.handleError
+	if server.UnknownIdentifiers != nil {
+		theme.unknownPage = server.UnknownIdentifiers.Page
+	}
+	if server.Appeals != nil {
+		theme.appeal = server.basePath() + "/appeal"
+	}
+	if server.Resend != nil {
+		theme.resend, theme.csrfToken = server.basePath()+"/resend/", server.csrfToken
+	}
+	return theme
+}
+
+// theme is currentTheme for when the server's lock isn't held.
+func (server *Server) theme(c *gin.Context) Theme {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.currentTheme(c)
+}
+
+// rateLimiter returns RateLimiter, which Reload can change.
+func (server *Server) rateLimiter() RateLimiter {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.RateLimiter
+}
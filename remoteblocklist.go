@@ -0,0 +1,155 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// RemoteBlockList is a blocklist that's fetched from a URL and refreshed periodically, so that an organisation-wide
+// deny list reaches every instance. Its entries are checked alongside Server.BlockList. Serve runs it.
+//
+// The list can be in any format that ParseBlockList reads, such as plain text with an IP address or CIDR prefix per
+// line, JSON, CSV, ipset save output or nginx deny directives.
+type RemoteBlockList struct {
+	// URL is where the list is fetched from.
+	URL string
+	// Header is added to each request, e.g. for authentication.
+	Header http.Header
+	// Interval is how often the list is refreshed. Defaults to 15 minutes.
+	Interval time.Duration
+	// Reason is shown to blocked clients when the list doesn't give one. Defaults to a generic message.
+	Reason string
+	// Client fetches the list. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Namespaces, if set, are the only namespaces of awaits that the list applies to.
+	Namespaces []string
+
+	mu       sync.RWMutex
+	addrs    map[netip.Addr]string
+	prefixes *prefixTrie
+	etag     string
+}
+
+// torExitListURL is the Tor Project's list of exit node addresses.
+const torExitListURL = "https://check.torproject.org/torbulkexitlist"
+
+// NewTorBlockList returns a RemoteBlockList of Tor exit nodes, for flows that mustn't accept anonymised traffic. Set
+// its Namespaces to only apply it to some of them.
+func NewTorBlockList() *RemoteBlockList {
+	return &RemoteBlockList{
+		URL:      torExitListURL,
+		Interval: 30 * time.Minute,
+		Reason:   "Verification isn't available over Tor.",
+	}
+}
+
+// appliesTo reports whether the list applies to awaits in namespace.
+func (list *RemoteBlockList) appliesTo(namespace string) bool {
+	if len(list.Namespaces) == 0 {
+		return true
+	}
+	for _, allowed := range list.Namespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns why ip is on the list, if it is.
+func (list *RemoteBlockList) lookup(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+	if reason, ok := list.addrs[addr]; ok {
+		return reason, true
+	}
+	return list.prefixes.lookup(addr)
+}
+
+// Refresh fetches the list, replacing what was fetched before. If the server says that the list hasn't changed since
+// the last fetch, it's kept as is. If fetching fails, the old list is kept too.
+func (list *RemoteBlockList) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, list.URL, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range list.Header {
+		req.Header[name] = values
+	}
+	list.mu.RLock()
+	if list.etag != "" {
+		req.Header.Set("If-None-Match", list.etag)
+	}
+	list.mu.RUnlock()
+
+	client := list.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gotcha: fetching blocklist %s: %s", list.URL, resp.Status)
+	}
+	entries, err := ParseBlockList(resp.Body)
+	if err != nil {
+		return fmt.Errorf("gotcha: parsing blocklist %s: %w", list.URL, err)
+	}
+
+	reason := list.Reason
+	if reason == "" {
+		reason = blocklistReason
+	}
+	addrs, prefixes := map[netip.Addr]string{}, &prefixTrie{}
+	for entry, entryReason := range entries {
+		if entryReason == "" {
+			entryReason = reason
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			addrs[addr.Unmap()] = entryReason
+		} else if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes.insert(prefix.Masked(), entryReason)
+		}
+	}
+
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.addrs, list.prefixes, list.etag = addrs, prefixes, resp.Header.Get("ETag")
+	return nil
+}
+
+// run refreshes the list every Interval until ctx is done.
+func (list *RemoteBlockList) run(ctx context.Context, logger *slog.Logger) {
+	interval := list.Interval
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := list.Refresh(ctx); err != nil {
+			logger.Warn("gotcha: refreshing blocklist failed", "url", list.URL, "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
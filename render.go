@@ -0,0 +1,104 @@
+package gotcha
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Renderer renders responses to verification requests, such as to return styled HTML. Each outcome has its own
+// method, so that a renderer only has to handle those that it cares about and fall back to DefaultRenderer for the
+// rest.
+type Renderer interface {
+	// Success renders a verified await, or step of one.
+	Success(c *gin.Context, ctx RenderContext)
+	// Expired renders a link that has expired.
+	Expired(c *gin.Context, ctx RenderContext)
+	// Blocked renders a client that was turned away: blocked, not on the await's allowlist, rejected by the Verifier,
+	// or locked out after too many failed attempts.
+	Blocked(c *gin.Context, ctx RenderContext)
+	// Error renders everything else, such as invalid links and codes, rate limiting and overload.
+	Error(c *gin.Context, ctx RenderContext)
+}
+
+// RenderContext describes the response that a Renderer renders.
+type RenderContext struct {
+	// Status is the HTTP status to respond with.
+	Status int
+	// Code is a short, machine-readable description of the outcome, such as "verified", "invalid_code" or
+	// "rate_limited".
+	Code string
+	// Body is what API clients are sent: "message", and "reason", "token" and so on where they apply.
+	Body map[string]string
+	// Await is the await that the request was for, as it was before the request, or nil if it wasn't for one. Pages can
+	// show its Metadata, e.g. the account that's being confirmed.
+	Await *PendingAwait
+	// Remaining is how long the await had left before it expired when the request was made.
+	Remaining time.Duration
+	// ClientIP and UserAgent describe the client.
+	ClientIP  string
+	UserAgent string
+}
+
+// DefaultRenderer returns the Renderer that's used when Renderer isn't set. Browsers are shown pages styled with
+// Theme, and other clients get JSON, or one of Serializers.
+func (server *Server) DefaultRenderer() Renderer {
+	return defaultRenderer{server: server}
+}
+
+type defaultRenderer struct {
+	server *Server
+}
+
+func (renderer defaultRenderer) Success(c *gin.Context, ctx RenderContext) { renderer.render(c, ctx) }
+func (renderer defaultRenderer) Expired(c *gin.Context, ctx RenderContext) { renderer.render(c, ctx) }
+func (renderer defaultRenderer) Blocked(c *gin.Context, ctx RenderContext) { renderer.render(c, ctx) }
+func (renderer defaultRenderer) Error(c *gin.Context, ctx RenderContext)   { renderer.render(c, ctx) }
+
+func (renderer defaultRenderer) render(c *gin.Context, ctx RenderContext) {
+	// API clients usually send one of these, which can be answered without parsing the header.
+	switch c.GetHeader("Accept") {
+	case "", "*/*", gin.MIMEJSON:
+		renderer.server.respond(c, ctx.Status, ctx.Body)
+		return
+	}
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		renderer.server.theme(c).Render(c, ctx)
+		return
+	}
+	renderer.server.respond(c, ctx.Status, ctx.Body)
+}
+
+// render passes ctx to the Renderer method for its outcome.
+func (server *Server) render(c *gin.Context, ctx RenderContext) {
+	renderer := server.Renderer
+	if ctx.Await != nil && ctx.Await.renderer != nil {
+		renderer = ctx.Await.renderer
+	}
+	if renderer == nil {
+		renderer = server.DefaultRenderer()
+	}
+	if ctx.Code == "invalid_identifier" && server.redirectUnknown(c) {
+		return
+	}
+	ctx.ClientIP, ctx.UserAgent = server.clientIP(c), c.Request.UserAgent()
+	if ctx.Await != nil && ctx.Await.Expires.After(time.Now()) {
+		ctx.Remaining = time.Until(ctx.Await.Expires)
+	}
+	switch ctx.Code {
+	case "verified", "step_verified", "appealed", "resent", "reissued":
+		renderer.Success(c, ctx)
+	case "expired":
+		renderer.Expired(c, ctx)
+	case "blocked", "locked", "not_allowed", "rejected":
+		renderer.Blocked(c, ctx)
+	default:
+		renderer.Error(c, ctx)
+	}
+}
+
+// renderError renders an error that has nothing to say beyond its status.
+func (server *Server) renderError(c *gin.Context, status int, code string) {
+	server.render(c, RenderContext{Status: status, Code: code, Body: map[string]string{"message": http.StatusText(status)}})
+}
@@ -0,0 +1,59 @@
+package gotcha
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usedLink is when a verified await's link was used, for ReplayWindow.
+type usedLink struct {
+	at        time.Time
+	namespace string
+}
+
+// markUsed records that the links of entry, which was just verified, have been used. The server's lock must be held,
+// and resolveMu too if it's only held shared.
+func (server *Server) markUsed(key string, entry *awaited) {
+	if server.ReplayWindow <= 0 {
+		return
+	}
+	if server.used == nil {
+		server.used = map[string]usedLink{}
+	}
+	if len(server.used) >= server.usedSweepAt {
+		for key, used := range server.used {
+			if entry.resolved.Sub(used.at) >= server.ReplayWindow {
+				delete(server.used, key)
+			}
+		}
+		server.usedSweepAt = 2*len(server.used) + 64
+	}
+	used := usedLink{at: entry.resolved, namespace: entry.namespace}
+	server.used[key] = used
+	for _, other := range entry.otherKeys() {
+		server.used[other] = used
+	}
+}
+
+// replayed reports whether key is the link of an await that was verified within ReplayWindow, and if so emits
+// EventReplayed and returns what to respond with. The server's lock must be held, shared or exclusively.
+func (server *Server) replayed(c *gin.Context, key string) (outcome, bool) {
+	server.resolveMu.Lock()
+	used, ok := server.used[key]
+	server.resolveMu.Unlock()
+	if !ok || time.Since(used.at) >= server.ReplayWindow {
+		return outcome{}, false
+	}
+	if namespace, ok := namespaceOf(c); ok && namespace != used.namespace {
+		return outcome{}, false
+	}
+	server.emit(Event{Type: EventReplayed, Identifier: key, Namespace: used.namespace, Time: time.Now(),
+		ClientIP: server.clientIP(c), RequestID: c.GetString(requestIDKey), TraceParent: traceParent(c)})
+	status := http.StatusConflict
+	return outcome{status: status, code: "already_used", body: map[string]string{
+		"message": http.StatusText(status),
+		"used":    used.at.UTC().Format(time.RFC3339),
+	}}, true
+}
@@ -0,0 +1,57 @@
+package gotcha
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDKey is the gin context key that the request ID is stored under.
+const requestIDKey = "gotcha.request_id"
+
+// requestIDHeader returns the header that request IDs are read from and returned in.
+func (server *Server) requestIDHeader() string {
+	if server.RequestIDHeader == "" {
+		return "X-Request-ID"
+	}
+	return server.RequestIDHeader
+}
+
+// requestID is middleware that gives each request an ID, taken from the request if the client or a proxy in front sent
+// a sensible one. It's echoed in the response and kept in the request's header, so that forwarded requests keep it.
+func (server *Server) requestID(c *gin.Context) {
+	header := server.requestIDHeader()
+	id := c.GetHeader(header)
+	if !validRequestID(id) {
+		var raw [16]byte
+		rand.Read(raw[:])
+		id = hex.EncodeToString(raw[:])
+		c.Request.Header.Set(header, id)
+	}
+	c.Set(requestIDKey, id)
+	if header == "X-Request-ID" {
+		// Skip canonicalising the default on every request.
+		c.Writer.Header()["X-Request-Id"] = []string{id}
+	} else {
+		c.Header(header, id)
+	}
+}
+
+// validRequestID reports whether id is short and printable enough to be logged and passed on.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDOf returns the ID that the requestID middleware gave c.
+func requestIDOf(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
@@ -0,0 +1,95 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resend enables POST /resend/:identifier, which asks the application to send a verification again, such as when the
+// email never arrived or its link expired, so that the application doesn't need an endpoint of its own for it. The
+// page shown for an expired link has a button that posts to it. It's rate limited like verification requests, and per
+// await by Interval.
+type Resend struct {
+	// Issue is called to send the await again, such as by emailing its link once more, or by cancelling it and
+	// registering a new one. await's Expires is in the past if it expired, in which case Issue is only called once
+	// for it. If it fails, the user is asked to try again later.
+	Issue func(ctx context.Context, await PendingAwait) error
+	// Grace is how long expired awaits are kept for, so that their links still offer a resend. Defaults to Retention.
+	Grace time.Duration
+	// Interval is the least time between resends of a pending await. Defaults to 1 minute.
+	Interval time.Duration
+}
+
+func (resend *Resend) interval() time.Duration {
+	if resend.Interval <= 0 {
+		return time.Minute
+	}
+	return resend.Interval
+}
+
+// resend handles POST /resend/:identifier, asking the application to send a pending or expired await again.
+func (server *Server) resend(c *gin.Context) {
+	key := server.HashIdentifier(c.Param("identifier"))
+	now := time.Now()
+	server.mu.Lock()
+	awaitKey, entry, current, ok := server.lookupIn(c, key)
+	var expired, resolved, issue bool
+	var retryAfter time.Duration
+	var pending PendingAwait
+	if ok {
+		expired = !entry.pending() && entry.status == StatusExpired
+		switch {
+		case expired:
+			// Only once since it expired.
+			issue = entry.resent.Before(entry.expires)
+		case entry.pending():
+			retryAfter = entry.resent.Add(server.Resend.interval()).Sub(now)
+			issue = retryAfter <= 0
+		default:
+			resolved = true
+		}
+	}
+	previous := time.Time{}
+	if issue {
+		previous, entry.resent = entry.resent, now
+		pending = entry.pendingAwait(awaitKey, current)
+	}
+	server.mu.Unlock()
+
+	switch {
+	case !ok:
+		server.renderError(c, server.unknownStatus(), "invalid_identifier")
+		return
+	case resolved:
+		// It was verified, cancelled or blocked, so there's nothing to send.
+		server.renderError(c, http.StatusConflict, "resolved")
+		return
+	case !issue && !expired:
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		server.renderError(c, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+	if issue {
+		if err := server.Resend.Issue(c.Request.Context(), pending); err != nil {
+			server.mu.Lock()
+			entry.resent = previous
+			server.mu.Unlock()
+			server.logger().Error("gotcha: resending failed", "identifier", awaitKey, "error", err)
+			if server.handleError(c, fmt.Errorf("gotcha: resending: %w", err)) {
+				return
+			}
+			server.renderError(c, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+		server.metrics.observeResend()
+		server.logger().Info("gotcha: resent", "identifier", awaitKey, "namespace", pending.Namespace, "expired", expired)
+	}
+	// Pressing the button again for an expired link is answered the same way, without sending another.
+	server.renderError(c, http.StatusAccepted, "resent")
+}
@@ -0,0 +1,288 @@
+package gotcha
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Serializer encodes responses for API clients in a format other than JSON. See Server.Serializers.
+type Serializer interface {
+	// ContentType is the media type of the encoding, which clients ask for with the Accept header.
+	ContentType() string
+	// Marshal encodes a response body. Bodies are maps of field names to JSON-like values.
+	Marshal(body interface{}) ([]byte, error)
+}
+
+// respond writes body with the Serializer that the client asked for, or as JSON.
+func (server *Server) respond(c *gin.Context, status int, body interface{}) {
+	if len(server.Serializers) > 0 {
+		offers := []string{gin.MIMEJSON}
+		for _, serializer := range server.Serializers {
+			offers = append(offers, serializer.ContentType())
+		}
+		accepted := c.NegotiateFormat(offers...)
+		for _, serializer := range server.Serializers {
+			if serializer.ContentType() != accepted {
+				continue
+			}
+			data, err := serializer.Marshal(body)
+			if err != nil {
+				c.Error(err)
+				if server.handleError(c, err) {
+					return
+				}
+				break
+			}
+			c.Data(status, serializer.ContentType(), data)
+			return
+		}
+	}
+	if fields, ok := body.(map[string]string); ok {
+		writeJSON(c, status, fields)
+		return
+	}
+	c.JSON(status, body)
+}
+
+// jsonContentType is the Content-Type of JSON responses, shared between them as gin does.
+var jsonContentType = []string{"application/json; charset=utf-8"}
+
+// jsonBuffers holds buffers for encoding responses in, so that verification requests don't allocate one each.
+var jsonBuffers = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// writeJSON writes fields as JSON, exactly as c.JSON would, but without going through reflection, since most
+// verification responses are bodies like this.
+func writeJSON(c *gin.Context, status int, fields map[string]string) {
+	c.Status(status)
+	c.Writer.Header()["Content-Type"] = jsonContentType
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	buf := jsonBuffers.Get().(*[]byte)
+	*buf = appendJSONObject((*buf)[:0], fields)
+	c.Writer.Write(*buf)
+	if cap(*buf) <= 64<<10 {
+		jsonBuffers.Put(buf)
+	}
+}
+
+// appendJSONObject appends fields to dst as a JSON object with sorted keys, as encoding/json encodes them.
+func appendJSONObject(dst []byte, fields map[string]string) []byte {
+	// Bodies rarely have more than a handful of fields, so their keys are sorted on the stack.
+	var stack [8]string
+	keys := stack[:0]
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	dst = append(dst, '{')
+	for i, key := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, key)
+		dst = append(dst, ':')
+		dst = appendJSONString(dst, fields[key])
+	}
+	return append(dst, '}')
+}
+
+// appendJSONString appends s to dst as a JSON string, escaped as encoding/json escapes it: with HTML characters,
+// U+2028 and U+2029 escaped, and invalid UTF-8 replaced with U+FFFD.
+func appendJSONString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// plain converts body to the values that encoding/json decodes into, so that serializers only have to handle those.
+func plain(body interface{}) (interface{}, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err = decoder.Decode(&value)
+	return value, err
+}
+
+// MessagePack is a Serializer for application/msgpack.
+type MessagePack struct{}
+
+// ContentType implements Serializer.
+func (MessagePack) ContentType() string {
+	return "application/msgpack"
+}
+
+// Marshal implements Serializer.
+func (MessagePack) Marshal(body interface{}) ([]byte, error) {
+	value, err := plain(body)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, value)
+}
+
+func appendMsgpack(buf []byte, value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if value {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			if n >= 0 && n < 128 {
+				return append(buf, byte(n)), nil
+			}
+			return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n)), nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f)), nil
+	case string:
+		buf = appendMsgpackLength(buf, len(value), 0xa0, 0xd9, 0xda, 0xdb)
+		return append(buf, value...), nil
+	case []interface{}:
+		buf = appendMsgpackLength(buf, len(value), 0x90, 0, 0xdc, 0xdd)
+		for _, item := range value {
+			var err error
+			if buf, err = appendMsgpack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = appendMsgpackLength(buf, len(value), 0x80, 0, 0xde, 0xdf)
+		for _, key := range sortedKeys(value) {
+			var err error
+			if buf, err = appendMsgpack(buf, key); err != nil {
+				return nil, err
+			}
+			if buf, err = appendMsgpack(buf, value[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, errors.New("gotcha: can't encode value as MessagePack")
+}
+
+// appendMsgpackLength appends the header of a string, array or map of length n. fix is the tag of the fixed-size
+// form, and tag8, tag16 and tag32 those with 8, 16 and 32-bit lengths; tag8 is 0 if there isn't one.
+func appendMsgpackLength(buf []byte, n int, fix, tag8, tag16, tag32 byte) []byte {
+	switch {
+	case fix == 0xa0 && n < 32, fix != 0xa0 && n < 16:
+		return append(buf, fix|byte(n))
+	case tag8 != 0 && n <= math.MaxUint8:
+		return append(buf, tag8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, tag16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, tag32), uint32(n))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Protobuf is a Serializer for application/x-protobuf, encoding responses as the Response message in event.proto.
+// Fields that aren't strings are encoded as JSON.
+type Protobuf struct{}
+
+// ContentType implements Serializer.
+func (Protobuf) ContentType() string {
+	return "application/x-protobuf"
+}
+
+// Marshal implements Serializer.
+func (Protobuf) Marshal(body interface{}) ([]byte, error) {
+	value, err := plain(body)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("gotcha: can't encode a response that isn't an object as protobuf")
+	}
+	var buf []byte
+	for _, name := range sortedKeys(fields) {
+		field, ok := fields[name].(string)
+		if !ok {
+			data, err := json.Marshal(fields[name])
+			if err != nil {
+				return nil, err
+			}
+			field = string(data)
+		}
+		buf = appendProtoMessage(buf, 1, appendProtoString(appendProtoString(nil, 1, name), 2, field))
+	}
+	return buf, nil
+}
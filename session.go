@@ -0,0 +1,117 @@
+package gotcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrInvalidSession is returned by SessionCookie.Read when the cookie is missing, has been tampered with or has
+// expired.
+var ErrInvalidSession = errors.New("gotcha: invalid session")
+
+// SessionCookie configures the signed cookie that's set when an await is verified, so that magic-link logins can
+// land the user in a session straight away. The application reads it back with Read.
+type SessionCookie struct {
+	// Key signs the cookie. It's required.
+	Key []byte
+	// Name is the name of the cookie. Defaults to "gotcha_session".
+	Name string
+	// Domain and Path scope the cookie. Path defaults to "/".
+	Domain string
+	Path   string
+	// TTL is how long the session lasts. Defaults to 24 hours.
+	TTL time.Duration
+	// SameSite defaults to http.SameSiteLaxMode, which lets the cookie be sent when the user follows a link to the
+	// application.
+	SameSite http.SameSite
+	// Insecure lets the cookie be sent over plain HTTP, for local development.
+	Insecure bool
+	// Redirect, if set, is where clients are sent once the cookie has been set, instead of being shown a page.
+	Redirect string
+}
+
+// Session is what's carried in a session cookie.
+type Session struct {
+	// Identifier is the identifier of the await that was verified, as returned by HashIdentifier.
+	Identifier string            `json:"identifier"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Expires    time.Time         `json:"expires"`
+}
+
+func (cookie *SessionCookie) name() string {
+	if cookie.Name == "" {
+		return "gotcha_session"
+	}
+	return cookie.Name
+}
+
+// set adds a cookie carrying session to the response.
+func (cookie *SessionCookie) set(c *gin.Context, session Session) {
+	ttl := cookie.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	path := cookie.Path
+	if path == "" {
+		path = "/"
+	}
+	sameSite := cookie.SameSite
+	if sameSite == 0 {
+		sameSite = http.SameSiteLaxMode
+	}
+	session.Expires = time.Now().Add(ttl).Truncate(time.Second)
+
+	payload, err := json.Marshal(session)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     cookie.name(),
+		Value:    value + "." + cookie.sign(value),
+		Domain:   cookie.Domain,
+		Path:     path,
+		Expires:  session.Expires,
+		MaxAge:   int(ttl / time.Second),
+		Secure:   !cookie.Insecure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+// Read returns the session carried by the cookie in r.
+func (cookie *SessionCookie) Read(r *http.Request) (*Session, error) {
+	raw, err := r.Cookie(cookie.name())
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	value, sig, ok := strings.Cut(raw.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(cookie.sign(value))) {
+		return nil, ErrInvalidSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	session := &Session{}
+	if err := json.Unmarshal(payload, session); err != nil || time.Now().After(session.Expires) {
+		return nil, ErrInvalidSession
+	}
+	return session, nil
+}
+
+func (cookie *SessionCookie) sign(value string) string {
+	mac := hmac.New(sha256.New, cookie.Key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,37 @@
+package gotcha
+
+import (
+	"errors"
+	"fmt"
+)
+
+// shortCodeAlphabet is userCodeAlphabet and the digits, less 0, 1 and L, which are easily mistaken for O and I.
+const shortCodeAlphabet = "23456789BCDFGHJKMNPQRSTVWXZ"
+
+// shortCodeAttempts is how many codes RegisterShortCode tries before giving up.
+const shortCodeAttempts = 10
+
+// RegisterShortCode registers req under a random code of length characters, for flows where users read the code
+// aloud or type it in on another device. Codes are upper case, and leave out vowels and easily confused characters.
+// length defaults to 6 and can be at most 8. The code is the returned Handle's Identifier. If a code is already
+// pending, another is tried; otherwise it fails as Register does.
+func (server *Server) RegisterShortCode(req AwaitRequest, length int) (*Handle, error) {
+	if length == 0 {
+		length = 6
+	}
+	if length < 6 || length > 8 {
+		return nil, fmt.Errorf("gotcha: short codes must be 6 to 8 characters, not %d", length)
+	}
+	for attempt := 0; attempt < shortCodeAttempts; attempt++ {
+		code, err := randomCode(shortCodeAlphabet, length)
+		if err != nil {
+			return nil, err
+		}
+		req.Identifier = code
+		handle, err := server.Register(req)
+		if !errors.Is(err, ErrPending) {
+			return handle, err
+		}
+	}
+	return nil, fmt.Errorf("%w: no free short code after %d attempts", ErrPending, shortCodeAttempts)
+}
@@ -0,0 +1,120 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Shortener shortens the links that VerifyURL returns, such as for SMS, where every character counts. The short link
+// only has to redirect to the long one, which is still what's verified when it arrives.
+type Shortener interface {
+	Shorten(ctx context.Context, link string) (string, error)
+}
+
+// ShortenerFunc adapts a function to a Shortener.
+type ShortenerFunc func(ctx context.Context, link string) (string, error)
+
+// Shorten calls f.
+func (f ShortenerFunc) Shorten(ctx context.Context, link string) (string, error) {
+	return f(ctx, link)
+}
+
+// HTTPShortener is a Shortener for URL shortening services with a JSON API: it posts {"url": link} and reads the short
+// link from the response.
+type HTTPShortener struct {
+	// Endpoint is the URL that links are posted to.
+	Endpoint string
+	// Header is added to each request, e.g. for an API key.
+	Header http.Header
+	// RequestField is the field of the request that the link is sent in. Defaults to "url".
+	RequestField string
+	// ResponseField is the field of the response that the short link is read from, with dots for nested fields, such
+	// as "data.tiny_url". Defaults to "short_url".
+	ResponseField string
+	// Timeout is the longest that shortening a link can take. Defaults to 5 seconds.
+	Timeout time.Duration
+	// Client sends the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Shorten posts link to Endpoint and returns the short link from the response.
+func (shortener *HTTPShortener) Shorten(ctx context.Context, link string) (string, error) {
+	timeout := shortener.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	field := shortener.RequestField
+	if field == "" {
+		field = "url"
+	}
+	body, err := json.Marshal(map[string]string{field: link})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, shortener.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	for name, values := range shortener.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	client := shortener.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("gotcha: shortening link: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", fmt.Errorf("gotcha: parsing shortened link: %w", err)
+	}
+	path := shortener.ResponseField
+	if path == "" {
+		path = "short_url"
+	}
+	for _, name := range strings.Split(path, ".") {
+		object, _ := value.(map[string]interface{})
+		value = object[name]
+	}
+	short, _ := value.(string)
+	if short == "" {
+		return "", fmt.Errorf("gotcha: shortening link: no %q in the response", path)
+	}
+	return short, nil
+}
+
+// shorten returns link shortened by Shortener, or link itself if there isn't one or it fails.
+func (server *Server) shorten(link string) string {
+	if server.Shortener == nil {
+		return link
+	}
+	short, err := server.Shortener.Shorten(context.Background(), link)
+	if err != nil {
+		// A long link still works.
+		server.logger().Error("gotcha: shortening link failed", "error", err)
+		server.handleError(nil, fmt.Errorf("gotcha: shortening link: %w", err))
+		return link
+	}
+	return short
+}
@@ -0,0 +1,61 @@
+package gotcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyURL returns the link that a client should follow to verify identifier. If SigningKey is set, it's the signed
+// /verify?token=...&expires=...&sig=... form, expiring with the await, or after Timeout if it isn't registered yet.
+// Otherwise it's /verify/:identifier, or the Path of the await's Tenant. Either way, it's under APIVersion. If Shortener
+// is set, the link is shortened, unless that fails.
+func (server *Server) VerifyURL(identifier string) string {
+	server.mu.Lock()
+	expiry := time.Now().Add(server.Timeout)
+	var tenant Tenant
+	if _, entry, _, ok := server.lookup(server.HashIdentifier(identifier)); ok && entry.pending() {
+		expiry, tenant = entry.expires, server.Tenants[entry.namespace]
+	}
+	server.mu.Unlock()
+	base := tenant.baseURL(server.BaseURL) + server.versionPath()
+	if server.SigningKey == nil {
+		return server.shorten(base + tenant.verifyPath(identifier))
+	}
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	query := url.Values{
+		"token":   {identifier},
+		"expires": {expires},
+		"sig":     {server.sign(identifier, expires)},
+	}
+	return server.shorten(base + "/verify?" + query.Encode())
+}
+
+func (server *Server) sign(token, expires string) string {
+	mac := hmac.New(sha256.New, server.SigningKey)
+	mac.Write([]byte(token + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySigned handles GET /verify?token=...&expires=...&sig=.... Links with a bad signature or that have expired are
+// rejected before the await is looked up.
+func (server *Server) verifySigned(c *gin.Context) {
+	token, expires, sig := c.Query("token"), c.Query("expires"), c.Query("sig")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(server.sign(token, expires))) {
+		server.renderError(c, http.StatusUnauthorized, "invalid_signature")
+		return
+	}
+	if time.Now().Unix() > expiresAt {
+		server.renderError(c, http.StatusGone, "expired")
+		return
+	}
+	server.fallback(c, token)
+	server.verify(c, server.HashIdentifier(token))
+}
@@ -0,0 +1,84 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot periodically saves the pending awaits, the runtime blocklist, the Analytics rollups, the Accounting totals,
+// the dashboard's outcome counts, which /admin/metrics reports as well, and the events that EventSinks haven't taken
+// yet to a file, and restores them when Serve starts, as lightweight durability for deployments without an external
+// store, so that a restart doesn't wipe them. The awaits and the events are saved at the same moment, so an await
+// that's left the pending ones has had its event either taken by the sinks or saved. Anything that happens between the last save and a crash is lost. The file is in Export's format, sealed with
+// Server.Keyring if it's set.
+type Snapshot struct {
+	// Path is the file that's saved to and restored from.
+	Path string
+	// Interval is how often the file is saved. Defaults to 1 minute.
+	Interval time.Duration
+}
+
+// Save writes a snapshot of server to Path, replacing the old one atomically.
+func (snapshot *Snapshot) Save(server *Server) error {
+	data, _, err := server.export(true)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(snapshot.Path), filepath.Base(snapshot.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), snapshot.Path)
+}
+
+// Restore imports the snapshot at Path into server, returning how many awaits were restored. It's not an error for
+// there not to be a snapshot yet.
+func (snapshot *Snapshot) Restore(server *Server) (int, error) {
+	data, err := os.ReadFile(snapshot.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return server.restore(data)
+}
+
+// run saves a snapshot of server every Interval until ctx is done.
+func (snapshot *Snapshot) run(ctx context.Context, server *Server, logger *slog.Logger) {
+	interval := snapshot.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := snapshot.Save(server); err != nil {
+			logger.Error("gotcha: saving snapshot failed", "path", snapshot.Path, "error", err)
+			server.handleError(nil, fmt.Errorf("gotcha: saving snapshot %s: %w", snapshot.Path, err))
+		}
+	}
+}
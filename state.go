@@ -0,0 +1,92 @@
+package gotcha
+
+import "time"
+
+// State describes where an await is in its lifecycle.
+type State int
+
+const (
+	// StatePending means the await is still waiting for a request.
+	StatePending State = iota
+	// StateVerified means the await was fulfilled.
+	StateVerified
+	// StateExpired means Timeout elapsed before the await was fulfilled.
+	StateExpired
+	// StateBlocked means the await was resolved by a blocked client.
+	StateBlocked
+	// StateCancelled means the await was cancelled.
+	StateCancelled
+	// StateDenied means the await was denied.
+	StateDenied
+	// StateUndeliverable means the await's link couldn't be delivered.
+	StateUndeliverable
+	// StateLocked means there were too many failed attempts to verify the await.
+	StateLocked
+	// StateEvicted means the await was evicted for one with a higher priority.
+	StateEvicted
+)
+
+var stateNames = map[State]string{
+	StatePending:       "pending",
+	StateVerified:      "verified",
+	StateExpired:       "expired",
+	StateBlocked:       "blocked",
+	StateCancelled:     "cancelled",
+	StateDenied:        "denied",
+	StateUndeliverable: "undeliverable",
+	StateLocked:        "locked",
+	StateEvicted:       "evicted",
+}
+
+func (state State) String() string {
+	if name, ok := stateNames[state]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// stateOf returns the State that corresponds to a status returned by Await.
+func stateOf(status int) State {
+	switch status {
+	case StatusVerified:
+		return StateVerified
+	case StatusExpired:
+		return StateExpired
+	case StatusCancelled:
+		return StateCancelled
+	case StatusDenied:
+		return StateDenied
+	case StatusUndeliverable:
+		return StateUndeliverable
+	case StatusLocked:
+		return StateLocked
+	case StatusEvicted:
+		return StateEvicted
+	default:
+		return StateBlocked
+	}
+}
+
+// Peek returns the state of the await for identifier without blocking or resolving it.
+// Resolved awaits can be seen for Retention after they resolve; ok is false if identifier isn't known.
+func (server *Server) Peek(identifier string) (state State, ok bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	entry, ok := server.awaits.get(server.HashIdentifier(identifier))
+	if !ok {
+		return 0, false
+	}
+	return server.state(entry), true
+}
+
+// state returns the current State of entry. The server's lock must be held.
+func (server *Server) state(entry *awaited) State {
+	if !entry.pending() {
+		return stateOf(entry.status)
+	}
+	if !time.Now().Before(entry.expires) {
+		return StateExpired
+	}
+	return StatePending
+}
@@ -0,0 +1,122 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsdPacketSize keeps packets under the usual MTU, so that they aren't fragmented.
+const statsdPacketSize = 1432
+
+// StatsD exports the server's metrics to a statsd agent over UDP, for deployments that don't use Prometheus. It emits
+// the same counters and timings as /admin/metrics. Serve runs it.
+type StatsD struct {
+	// Address is the agent's address, e.g. "127.0.0.1:8125".
+	Address string
+	// Prefix is prepended to every metric name. Defaults to "gotcha.".
+	Prefix string
+	// DogStatsD sends outcomes as tags, in the DogStatsD format, rather than as part of the metric name.
+	DogStatsD bool
+	// Tags are added to every metric, e.g. "env:prod". They need DogStatsD.
+	Tags []string
+	// FlushInterval is how often metrics are sent, along with gauges like the number of pending awaits. Defaults to
+	// 10 seconds.
+	FlushInterval time.Duration
+
+	lines chan string
+}
+
+// statsdQueueSize is how many metrics can be waiting to be sent before new ones are dropped.
+const statsdQueueSize = 4096
+
+func (statsd *StatsD) init() {
+	if statsd.lines == nil {
+		statsd.lines = make(chan string, statsdQueueSize)
+	}
+}
+
+// send queues a metric of kind, such as "c" or "ms", without blocking. outcome is set if the metric has one.
+func (statsd *StatsD) send(name, outcome string, value float64, kind string) {
+	statsd.sendTagged(name, "outcome", outcome, value, kind)
+}
+
+// sendTagged is like send, but for a tag other than the outcome. Without DogStatsD, value is appended to the name.
+func (statsd *StatsD) sendTagged(name, tag, tagValue string, value float64, kind string) {
+	prefix := statsd.Prefix
+	if prefix == "" {
+		prefix = "gotcha."
+	}
+	tags := statsd.Tags
+	if tagValue != "" {
+		if statsd.DogStatsD {
+			tags = append(tags[:len(tags):len(tags)], tag+":"+statsdTag(tagValue))
+		} else {
+			name += "." + statsdTag(tagValue)
+		}
+	}
+	line := fmt.Sprintf("%s%s:%g|%s", prefix, name, value, kind)
+	if statsd.DogStatsD && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	select {
+	case statsd.lines <- line:
+	default:
+	}
+}
+
+// statsdTag makes value safe to use in a tag or metric name, which free text like block reasons might not be.
+func statsdTag(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', '\n', ' ', '.':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// run sends queued metrics to the agent until ctx is done, batching them into packets. pending is called on each
+// flush for the number of pending awaits.
+func (statsd *StatsD) run(ctx context.Context, pending func() int) error {
+	conn, err := net.Dial("udp", statsd.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	interval := statsd.FlushInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var packet []byte
+	flush := func() {
+		if len(packet) > 0 {
+			// UDP is fire and forget; a missing agent shouldn't stop anything.
+			conn.Write(packet)
+			packet = packet[:0]
+		}
+	}
+	for {
+		select {
+		case line := <-statsd.lines:
+			if len(packet)+len(line)+1 > statsdPacketSize {
+				flush()
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		case <-ticker.C:
+			statsd.send("awaits.pending", "", float64(pending()), "g")
+			flush()
+		case <-ctx.Done():
+			flush()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package gotcha
+
+// Step is one of several verifications that an await needs before it's fulfilled, such as an email link and an SMS
+// code.
+type Step struct {
+	// Name describes the step in /wait responses, e.g. "email".
+	Name string
+	// Identifier is what the client must request /verify/:identifier with to complete the step.
+	Identifier string
+	// Code, if set, must be presented along with Identifier, as with AwaitRequest.Code.
+	Code string
+}
+
+type step struct {
+	name string
+	// key is the step's identifier, as returned by HashIdentifier.
+	key  string
+	code string
+	done bool
+}
+
+// stepsDone reports whether every step of entry has been completed. The server's lock must be held.
+func (entry *awaited) stepsDone() bool {
+	for _, step := range entry.steps {
+		if !step.done {
+			return false
+		}
+	}
+	return true
+}
+
+// stepStates describes the progress of entry's steps for /wait. The server's lock must be held.
+func (entry *awaited) stepStates() map[string]string {
+	states := make(map[string]string, len(entry.steps))
+	for _, step := range entry.steps {
+		if step.done {
+			states[step.name] = StateVerified.String()
+		} else {
+			states[step.name] = StatePending.String()
+		}
+	}
+	return states
+}
+
+// lookup finds the await that key verifies, which is the await itself, one of its steps or one of its aliases. The
+// identifier of an await with steps can't verify it on its own. The server's lock must be held.
+func (server *Server) lookup(key string) (awaitKey string, entry *awaited, current *step, ok bool) {
+	if ref, ok := server.steps[key]; ok {
+		return ref.key, ref.entry, ref.step, true
+	}
+	if ref, ok := server.aliases[key]; ok {
+		return ref.key, ref.entry, nil, true
+	}
+	entry, ok = server.awaits.get(key)
+	if !ok || len(entry.steps) > 0 {
+		return "", nil, nil, false
+	}
+	return key, entry, nil, true
+}
+
+// stepRef points at a step of a registered await.
+type stepRef struct {
+	key   string
+	entry *awaited
+	step  *step
+}
+
+// expectedCode returns the code that must be presented to verify entry, or current if it's one of entry's steps.
+func (entry *awaited) expectedCode(current *step) string {
+	if current != nil {
+		return current.code
+	}
+	return entry.code
+}
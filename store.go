@@ -0,0 +1,111 @@
+package gotcha
+
+import (
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// awaitShards is how many shards an awaitStore is split into.
+const awaitShards = 64
+
+// awaitStore holds the registered awaits by their keys, as returned by HashIdentifier. It's split into shards by a
+// hash of the key, each with its own lock, so that verifying one await doesn't queue up behind verifying another.
+//
+// The shards' maps only change with the server's lock held exclusively, as when awaits are registered or forgotten,
+// so they can be read with it held either way. A shard's lock guards the awaits in it: they can be read and changed
+// with the server's lock held exclusively, or with it held shared and the lock of the await's shard held too, as
+// verifying, probing and waiting do. See Server.mu for the order that the locks are taken in.
+type awaitStore struct {
+	shards [awaitShards]awaitShard
+}
+
+// awaitShard is one of the shards of an awaitStore.
+type awaitShard struct {
+	mu     sync.Mutex
+	awaits map[string]*awaited
+}
+
+// shard returns the shard that key is in.
+func (store *awaitStore) shard(key string) *awaitShard {
+	// FNV-1a, inlined so that picking a shard doesn't allocate.
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &store.shards[hash%awaitShards]
+}
+
+// get returns the await for key.
+func (store *awaitStore) get(key string) (*awaited, bool) {
+	entry, ok := store.shard(key).awaits[key]
+	return entry, ok
+}
+
+// put stores entry as the await for key. The server's lock must be held exclusively.
+func (store *awaitStore) put(key string, entry *awaited) {
+	shard := store.shard(key)
+	if shard.awaits == nil {
+		shard.awaits = map[string]*awaited{}
+	}
+	shard.awaits[key] = entry
+}
+
+// remove removes the await for key. The server's lock must be held exclusively.
+func (store *awaitStore) remove(key string) {
+	delete(store.shard(key).awaits, key)
+}
+
+// len returns how many awaits there are.
+func (store *awaitStore) len() int {
+	n := 0
+	for i := range store.shards {
+		n += len(store.shards[i].awaits)
+	}
+	return n
+}
+
+// each calls fn for each await, until it returns false. fn can remove the await that it's called for, as forget does.
+// The server's lock must be held exclusively.
+func (store *awaitStore) each(fn func(key string, entry *awaited) bool) {
+	for i := range store.shards {
+		for key, entry := range store.shards[i].awaits {
+			if !fn(key, entry) {
+				return
+			}
+		}
+	}
+}
+
+// lockAwait takes the lock of the shard that key is in, as returned by lookup. The server's lock must be held shared,
+// and the shard's lock is released, along with it, by unlockAwait.
+func (server *Server) lockAwait(key string) *awaitShard {
+	shard := server.awaits.shard(key)
+	shard.mu.Lock()
+	return shard
+}
+
+// lookupAwait is lookupIn for requests that hold the server's lock shared. It takes the lock of the shard of the
+// await that it finds, or of key if it finds none, which unlockAwait releases along with the server's lock.
+func (server *Server) lookupAwait(c *gin.Context, key string) (awaitKey string, entry *awaited, current *step, ok bool,
+	shard *awaitShard) {
+	awaitKey, entry, current, ok = server.lookupIn(c, key)
+	if !ok {
+		return awaitKey, entry, current, ok, server.lockAwait(key)
+	}
+	return awaitKey, entry, current, ok, server.lockAwait(awaitKey)
+}
+
+// unlockAwait releases shard's lock and the server's lock, which is held shared.
+func (server *Server) unlockAwait(shard *awaitShard) {
+	shard.mu.Unlock()
+	server.mu.RUnlock()
+}
+
+// relockAwait takes the server's lock shared and shard's lock again, after unlockAwait, such as once a hook that runs
+// without them has returned.
+func (server *Server) relockAwait(shard *awaitShard) {
+	server.mu.RLock()
+	shard.mu.Lock()
+}
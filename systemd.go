@@ -0,0 +1,31 @@
+package gotcha
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+// systemdListenFD is the first file descriptor that systemd passes sockets as.
+const systemdListenFD = 3
+
+// systemdListener returns the socket that systemd passed to the process with socket activation, such as one bound to
+// :443 so that the process doesn't need CAP_NET_BIND_SERVICE. It returns nil if there isn't one. Only the first socket
+// is used.
+func systemdListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// The variables are meant for this process only, not for anything it starts.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(systemdListenFD, "LISTEN_FD_3")
+	defer file.Close()
+	return net.FileListener(file)
+}
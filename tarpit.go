@@ -0,0 +1,174 @@
+package gotcha
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tarpit slows down clients that keep failing to verify, raising the cost of guessing codes without locking out
+// someone who mistyped one. After Free failures from an IP address or against an identifier, each request from it or
+// for it is held up for Delay, doubling with each further failure up to MaxDelay.
+type Tarpit struct {
+	// Free is how many failures are allowed before requests are delayed. Defaults to 3.
+	Free int
+	// Delay is the first delay. Defaults to 500 milliseconds.
+	Delay time.Duration
+	// MaxDelay is the longest delay. Defaults to 10 seconds.
+	MaxDelay time.Duration
+	// Window is how long failures are remembered for after the last one. Defaults to 15 minutes.
+	Window time.Duration
+	// BlockedDelay, if set, holds up responses to blocked clients for this long, up to a minute, to waste an attacker's
+	// time. It has no effect with ShadowBan set, since the delay would give the ban away.
+	BlockedDelay time.Duration
+	// MaxBlockedHeld is the most responses to blocked clients that are held up at once; those beyond it are sent
+	// straight away, so that a flood of blocked requests can't pile up. Defaults to 100.
+	MaxBlockedHeld int
+
+	mu       sync.Mutex
+	failures map[string]tarpitFailures
+	sweepAt  int
+	heldOnce sync.Once
+	held     chan struct{}
+}
+
+// maxBlockedDelay bounds Tarpit.BlockedDelay.
+const maxBlockedDelay = time.Minute
+
+type tarpitFailures struct {
+	count int
+	last  time.Time
+}
+
+func (tarpit *Tarpit) window() time.Duration {
+	if tarpit.Window <= 0 {
+		return 15 * time.Minute
+	}
+	return tarpit.Window
+}
+
+// delay returns how long to hold up a request that's tracked under keys.
+func (tarpit *Tarpit) delay(keys ...string) time.Duration {
+	tarpit.mu.Lock()
+	count := 0
+	for _, key := range keys {
+		if failures, ok := tarpit.failures[key]; ok && time.Since(failures.last) < tarpit.window() && failures.count > count {
+			count = failures.count
+		}
+	}
+	tarpit.mu.Unlock()
+
+	free := tarpit.Free
+	if free <= 0 {
+		free = 3
+	}
+	if count < free {
+		return 0
+	}
+	delay, maxDelay := tarpit.Delay, tarpit.MaxDelay
+	if delay <= 0 {
+		delay = 500 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Second
+	}
+	for i := free; i < count && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// fail records a failure under keys.
+func (tarpit *Tarpit) fail(keys ...string) {
+	tarpit.mu.Lock()
+	defer tarpit.mu.Unlock()
+	now := time.Now()
+	if tarpit.failures == nil {
+		tarpit.failures = map[string]tarpitFailures{}
+	}
+	if len(tarpit.failures) >= tarpit.sweepAt {
+		for key, failures := range tarpit.failures {
+			if now.Sub(failures.last) >= tarpit.window() {
+				delete(tarpit.failures, key)
+			}
+		}
+		tarpit.sweepAt = 2*len(tarpit.failures) + 64
+	}
+	for _, key := range keys {
+		failures := tarpit.failures[key]
+		if now.Sub(failures.last) >= tarpit.window() {
+			failures.count = 0
+		}
+		failures.count++
+		failures.last = now
+		tarpit.failures[key] = failures
+	}
+}
+
+// forgive forgets the failures under keys, e.g. once an identifier has been verified.
+func (tarpit *Tarpit) forgive(keys ...string) {
+	tarpit.mu.Lock()
+	defer tarpit.mu.Unlock()
+	for _, key := range keys {
+		delete(tarpit.failures, key)
+	}
+}
+
+// tarpitKeys returns the keys that a request for key is tracked under.
+func (server *Server) tarpitKeys(c *gin.Context, key string) []string {
+	return []string{"ip:" + server.clientKey(server.clientIP(c)), "identifier:" + key}
+}
+
+// holdUp delays the request for key if its client or identifier has failed too often. It returns false if the client
+// went away in the meantime.
+func (server *Server) holdUp(c *gin.Context, key string) bool {
+	if server.Tarpit == nil {
+		return true
+	}
+	delay := server.Tarpit.delay(server.tarpitKeys(c, key)...)
+	if delay <= 0 {
+		return true
+	}
+	return pause(c, delay)
+}
+
+// holdUpBlocked delays the response to the client by Tarpit.BlockedDelay if it's blocked, as it is if the request was,
+// unless MaxBlockedHeld already are being. It returns false if the client went away in the meantime.
+func (server *Server) holdUpBlocked(c *gin.Context, blocked bool) bool {
+	tarpit := server.Tarpit
+	if tarpit == nil || tarpit.BlockedDelay <= 0 {
+		return true
+	}
+	if !blocked {
+		if _, blocked = server.lockedBlockReason(server.clientIP(c)); !blocked {
+			return true
+		}
+	}
+	tarpit.heldOnce.Do(func() {
+		maxHeld := tarpit.MaxBlockedHeld
+		if maxHeld <= 0 {
+			maxHeld = 100
+		}
+		tarpit.held = make(chan struct{}, maxHeld)
+	})
+	select {
+	case tarpit.held <- struct{}{}:
+		defer func() { <-tarpit.held }()
+	default:
+		return true
+	}
+	return pause(c, min(tarpit.BlockedDelay, maxBlockedDelay))
+}
+
+// pause waits for delay, returning false if the client goes away first.
+func pause(c *gin.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
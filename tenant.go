@@ -0,0 +1,128 @@
+package gotcha
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant customises how a namespace's awaits are served, for white-label products sharing one server.
+type Tenant struct {
+	// Path, if set, is an alternative to /verify/:identifier that the namespace's links use, such as
+	// "/confirm-email/:id". It must end with a parameter, which is the identifier. Only the namespace's awaits can be
+	// verified at it.
+	Path string
+	// BaseURL, if set, is used by VerifyURL instead of Server.BaseURL for the namespace's awaits. Defaults to HTTPS on
+	// the first of Hosts.
+	BaseURL string
+	// Hosts are the hostnames, such as "verify.brand-a.com", whose requests are for the namespace. Only its awaits can
+	// be verified at them, and pages are shown with its Theme and templates.
+	Hosts []string
+	// Theme, if set, replaces Server.Theme for the namespace's pages.
+	Theme *Theme
+	// TemplateDir, if set, is a directory of templates parsed over the server's, for the namespace's pages.
+	TemplateDir string
+	// BlockList blocks clients from verifying the namespace's awaits, on top of Server.BlockList.
+	BlockList map[string]string
+}
+
+// namespaceKey is the gin.Context key of the namespace that a request is restricted to, by its host or path.
+const namespaceKey = "gotcha.namespace"
+
+// namespaceOf returns the namespace that the request is restricted to, if there is one.
+func namespaceOf(c *gin.Context) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	namespace, ok := c.Get(namespaceKey)
+	if !ok {
+		return "", false
+	}
+	return namespace.(string), true
+}
+
+// inScope reports whether entry can be seen by the request, which might be restricted to a namespace.
+func inScope(c *gin.Context, entry *awaited) bool {
+	namespace, ok := namespaceOf(c)
+	return !ok || entry.namespace == namespace
+}
+
+// lookupIn is lookup for a request, which doesn't find awaits outside the namespace that it's restricted to. The
+// server's lock must be held.
+func (server *Server) lookupIn(c *gin.Context, key string) (awaitKey string, entry *awaited, current *step, ok bool) {
+	awaitKey, entry, current, ok = server.lookup(key)
+	if ok && !inScope(c, entry) {
+		return "", nil, nil, false
+	}
+	return awaitKey, entry, current, ok
+}
+
+// hostTenant is middleware that restricts requests for one of a Tenant's Hosts to its namespace.
+func (server *Server) hostTenant(c *gin.Context) {
+	if len(server.tenantHosts) == 0 {
+		return
+	}
+	host := c.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if namespace, ok := server.tenantHosts[strings.ToLower(host)]; ok {
+		c.Set(namespaceKey, namespace)
+	}
+}
+
+// tenantHosts maps each host in Tenants to its namespace.
+func tenantHosts(tenants map[string]Tenant) map[string]string {
+	hosts := map[string]string{}
+	for namespace, tenant := range tenants {
+		for _, host := range tenant.Hosts {
+			hosts[strings.ToLower(host)] = namespace
+		}
+	}
+	return hosts
+}
+
+// tenantPath returns Path with the name of its parameter replaced with "identifier", which the handlers read.
+func (tenant Tenant) tenantPath() (string, error) {
+	i := strings.LastIndexByte(tenant.Path, '/')
+	if !strings.HasPrefix(tenant.Path, "/") || i < 0 || !strings.HasPrefix(tenant.Path[i+1:], ":") {
+		return "", fmt.Errorf("gotcha: tenant path %q must end with a parameter, such as /verify/:identifier", tenant.Path)
+	}
+	return tenant.Path[:i+1] + ":identifier", nil
+}
+
+// baseURL returns BaseURL, or base if it isn't set, without a trailing slash.
+func (tenant Tenant) baseURL(base string) string {
+	if tenant.BaseURL != "" {
+		base = tenant.BaseURL
+	} else if len(tenant.Hosts) > 0 {
+		base = "https://" + tenant.Hosts[0]
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// verifyPath returns the unsigned path that identifier is verified at.
+func (tenant Tenant) verifyPath(identifier string) string {
+	path := "/verify/:identifier"
+	if tenant.Path != "" {
+		path, _ = tenant.tenantPath()
+	}
+	return strings.TrimSuffix(path, ":identifier") + url.PathEscape(identifier)
+}
+
+// inNamespace is middleware that restricts requests to namespace, turning away those that are already restricted to
+// another one by their host.
+func (server *Server) inNamespace(namespace string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scoped, ok := namespaceOf(c); ok && scoped != namespace {
+			server.renderError(c, http.StatusNotFound, "not_found")
+			c.Abort()
+			return
+		}
+		c.Set(namespaceKey, namespace)
+	}
+}
@@ -0,0 +1,38 @@
+package gotcha
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWaitOtherTenant checks that a request on one tenant's host can't wait on another tenant's await.
+func TestWaitOtherTenant(t *testing.T) {
+	server := &Server{Timeout: 60e9, Tenants: map[string]Tenant{
+		"a": {Hosts: []string{"verify.a.example"}},
+		"b": {Hosts: []string{"verify.b.example"}},
+	}}
+	handler, err := server.Handler("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Register(AwaitRequest{Identifier: "x", Namespace: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		host string
+		want int
+	}{
+		{"verify.a.example", http.StatusNotFound},
+		{"verify.b.example", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/wait/x?timeout=0s", nil)
+		req.Host = test.host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.want {
+			t.Errorf("waiting on %s got %d, want %d", test.host, w.Code, test.want)
+		}
+	}
+}
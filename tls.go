@@ -0,0 +1,80 @@
+package gotcha
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// TLSCertificate is a certificate for some of the hostnames that the server is reached by, such as when one instance
+// serves the verification domains of several brands. See Server.TLSCertificates.
+type TLSCertificate struct {
+	// Hosts are the hostnames that the certificate is served for, such as "verify.example.com" or "*.example.com".
+	// Defaults to the names in the certificate.
+	Hosts []string
+	// CertFile and KeyFile are the filepaths of the certificate and its key.
+	CertFile string
+	KeyFile  string
+}
+
+// usesTLS reports whether the server is served over HTTPS.
+func (server *Server) usesTLS() bool {
+	return server.UseTLS || server.TLSConfig != nil || len(server.TLSCertificates) > 0 || server.HTTP3 != nil
+}
+
+// tlsConfig returns the TLS configuration to serve with: TLSConfig, or a new one, with TLSCert and TLSKey loaded and
+// TLSCertificates chosen between by SNI.
+func (server *Server) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if server.TLSConfig != nil {
+		config = server.TLSConfig.Clone()
+	}
+	if server.TLSCert != "" && server.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(server.TLSCert, server.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(server.TLSCertificates) == 0 {
+		return config, nil
+	}
+
+	hosts := map[string]*tls.Certificate{}
+	var fallback *tls.Certificate
+	if len(config.Certificates) > 0 {
+		fallback = &config.Certificates[0]
+	}
+	for _, certificate := range server.TLSCertificates {
+		cert, err := tls.LoadX509KeyPair(certificate.CertFile, certificate.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		names := certificate.Hosts
+		if len(names) == 0 && cert.Leaf != nil {
+			names = cert.Leaf.DNSNames
+		}
+		for _, name := range names {
+			hosts[strings.ToLower(name)] = &cert
+		}
+		if fallback == nil {
+			fallback = &cert
+		}
+	}
+	getCertificate := config.GetCertificate
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+		if cert, ok := hosts[name]; ok {
+			return cert, nil
+		}
+		if _, parent, ok := strings.Cut(name, "."); ok {
+			if cert, ok := hosts["*."+parent]; ok {
+				return cert, nil
+			}
+		}
+		if getCertificate != nil {
+			return getCertificate(hello)
+		}
+		return fallback, nil
+	}
+	return config, nil
+}
@@ -0,0 +1,60 @@
+package gotcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// TokenIssuer configures the short-lived JWT that's returned to clients when an await is verified, so that single
+// page apps get a credential straight away. Tokens are signed with HS256.
+type TokenIssuer struct {
+	// Key signs tokens. It's required.
+	Key []byte
+	// Issuer and Audience, if set, become the iss and aud claims.
+	Issuer   string
+	Audience string
+	// TTL is how long tokens are valid for. Defaults to 5 minutes.
+	TTL time.Duration
+	// Claims maps claim names to the keys of AwaitRequest.Metadata that they're taken from, e.g. {"sub": "user_id"}.
+	// Missing metadata is left out.
+	Claims map[string]string
+}
+
+// jwtHeader is the encoded JOSE header, {"alg":"HS256","typ":"JWT"}.
+const jwtHeader = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9"
+
+// issue returns a token for an await with metadata.
+func (issuer *TokenIssuer) issue(metadata map[string]string) (string, error) {
+	ttl := issuer.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	if issuer.Issuer != "" {
+		claims["iss"] = issuer.Issuer
+	}
+	if issuer.Audience != "" {
+		claims["aud"] = issuer.Audience
+	}
+	for claim, key := range issuer.Claims {
+		if value, ok := metadata[key]; ok {
+			claims[claim] = value
+		}
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	token := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, issuer.Key)
+	mac.Write([]byte(token))
+	return token + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,96 @@
+package gotcha
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// MetadataTOTPSecret is the AwaitRequest.Metadata key of the base32 TOTP secret that the user's authenticator app
+// was set up with. Awaits with it can be verified at POST /verify/:identifier/totp with a code from the app, for
+// users who can't receive the link. See Server.TOTP.
+const MetadataTOTPSecret = "totp_secret"
+
+// totpStep is how long each TOTP code lasts, as recommended by RFC 6238.
+const totpStep = 30 * time.Second
+
+// verifyTOTP handles POST /verify/:identifier/totp. The code is sent as the "code" form field or in a JSON body.
+func (server *Server) verifyTOTP(c *gin.Context) {
+	var code string
+	if c.ContentType() == binding.MIMEJSON {
+		var req struct {
+			Code string `json:"code"`
+		}
+		// The body is kept around in case the request has to be forwarded to another instance.
+		c.ShouldBindBodyWith(&req, binding.JSON)
+		code = req.Code
+	} else {
+		code = c.PostForm("code")
+	}
+
+	key := server.HashIdentifier(c.Param("identifier"))
+	server.mu.Lock()
+	_, entry, current, ok := server.lookupIn(c, key)
+	var secret, expected string
+	if ok {
+		secret, expected = entry.metadata[MetadataTOTPSecret], entry.expectedCode(current)
+	}
+	server.mu.Unlock()
+
+	if !ok && server.forward(c, key, "") {
+		return
+	}
+	if ok && secret != "" && !validTOTP(secret, code, time.Now()) {
+		server.mu.Lock()
+		if awaitKey, entry, _, found := server.lookup(key); found {
+			server.failed(awaitKey, entry, "invalid_code")
+		}
+		server.mu.Unlock()
+		ok = false
+	}
+	if !ok || secret == "" {
+		// A wrong code doesn't consume the await, so that the user can try again.
+		server.renderError(c, http.StatusUnauthorized, "invalid_code")
+		return
+	}
+	// The TOTP code stands in for the await's own code, which was sent with the link that the user didn't get.
+	result := server.attempt(c, key, expected)
+	if !result.forwarded {
+		server.render(c, result.context())
+	}
+}
+
+// validTOTP reports whether code is the 6 digit SHA-1 TOTP code for secret at now, allowing for a step of clock
+// drift either way.
+func validTOTP(secret, code string, now time.Time) bool {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(code) != 6 {
+		return false
+	}
+	valid := 0
+	counter := now.Unix() / int64(totpStep/time.Second)
+	for offset := int64(-1); offset <= 1; offset++ {
+		valid |= subtle.ConstantTimeCompare([]byte(code), []byte(hotp(key, uint64(counter+offset))))
+	}
+	return valid == 1
+}
+
+// hotp returns the 6 digit HOTP code for key and counter, as defined by RFC 4226.
+func hotp(key []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
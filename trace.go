@@ -0,0 +1,27 @@
+package gotcha
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// traceParent returns the request's W3C traceparent header, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", so that events and the work that they set off can join
+// the trace of the click that verified an await. It returns "" if the request doesn't have a valid one.
+func traceParent(c *gin.Context) string {
+	header := strings.ToLower(strings.TrimSpace(c.GetHeader("traceparent")))
+	parts := strings.SplitN(header, "-", 5)
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) > 4) {
+		return ""
+	}
+	for i, size := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != size || strings.Trim(parts[i], "0123456789abcdef") != "" {
+			return ""
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return ""
+	}
+	return header
+}
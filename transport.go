@@ -0,0 +1,38 @@
+package gotcha
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// Transport serves the router on the listener that Serve opens, in place of net/http's server.
+//
+// There's no fasthttp Transport: the router is gin, which is built on net/http, so fasthttp could only serve it
+// through an adaptor that turns every request back into a net/http one, and that was slower than net/http itself in
+// BenchmarkTransport. A Transport is only worth adding if it beats net/http there.
+type Transport interface {
+	// Serve serves handler on listener until it fails or Shutdown is called, after which it returns
+	// http.ErrServerClosed. tlsConfig is nil when the server isn't served over HTTPS.
+	Serve(listener net.Listener, handler http.Handler, tlsConfig *tls.Config) error
+	// Shutdown stops accepting connections and waits for open ones to be done with, until ctx is done.
+	Shutdown(ctx context.Context) error
+}
+
+// netHTTP is the Transport that's used by default.
+type netHTTP struct {
+	server *http.Server
+}
+
+func (transport netHTTP) Serve(listener net.Listener, handler http.Handler, tlsConfig *tls.Config) error {
+	transport.server.Handler, transport.server.TLSConfig = handler, tlsConfig
+	if tlsConfig != nil {
+		return transport.server.ServeTLS(listener, "", "")
+	}
+	return transport.server.Serve(listener)
+}
+
+func (transport netHTTP) Shutdown(ctx context.Context) error {
+	return transport.server.Shutdown(ctx)
+}
@@ -0,0 +1,52 @@
+package gotcha
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// BenchmarkTransport measures how fast each Transport serves HEAD /verify over loopback, from parallel clients. A
+// Transport under consideration is added here and compared with net/http's. A fasthttp one, serving gin through
+// fasthttpadaptor, was dropped for being slower: about 75µs a request to net/http's 59µs, measured with fasthttp's
+// client.
+func BenchmarkTransport(b *testing.B) {
+	for _, test := range []struct {
+		name      string
+		transport func() Transport
+	}{
+		{"net/http", func() Transport { return netHTTP{server: &http.Server{}} }},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			server := &Server{Timeout: 60e9}
+			handler, err := server.Handler("")
+			if err != nil {
+				b.Fatal(err)
+			}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			transport := test.transport()
+			go transport.Serve(listener, handler, nil)
+			defer transport.Shutdown(context.Background())
+
+			url := "http://" + listener.Addr().String() + "/verify/unknown"
+			client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1024}}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Head(url)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package gotcha
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UnknownIdentifiers configures how requests for identifiers that aren't known, or have already been used, are
+// answered. Honeytokens are answered the same way, so that they can't be told apart.
+type UnknownIdentifiers struct {
+	// Status is the status to respond with, such as 404 Not Found. Defaults to 401 Unauthorized.
+	Status int
+	// Redirect, if set, is where browsers are sent instead of being shown a page, such as a support article about
+	// broken links. API clients are still sent Status.
+	Redirect string
+	// Page, if set, is the template in TemplateDir, or a Tenant's, that browsers are shown instead of result.html. It's
+	// executed with the same data.
+	Page string
+}
+
+// unknownStatus returns the status to answer requests for identifiers that aren't known with.
+func (server *Server) unknownStatus() int {
+	if server.UnknownIdentifiers == nil || server.UnknownIdentifiers.Status == 0 {
+		return http.StatusUnauthorized
+	}
+	return server.UnknownIdentifiers.Status
+}
+
+// redirectUnknown redirects browsers to UnknownIdentifiers.Redirect, if it's set, reporting whether it did.
+func (server *Server) redirectUnknown(c *gin.Context) bool {
+	if server.UnknownIdentifiers == nil || server.UnknownIdentifiers.Redirect == "" ||
+		c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) != gin.MIMEHTML {
+		return false
+	}
+	c.Redirect(http.StatusSeeOther, server.UnknownIdentifiers.Redirect)
+	return true
+}
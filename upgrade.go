@@ -0,0 +1,103 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+)
+
+// upgradeEnv is set in the environment of the process that Upgrade starts.
+const upgradeEnv = "GOTCHA_UPGRADE"
+
+// File descriptors that Upgrade passes to the new process: the listening socket, and a pipe that the pending awaits
+// are written to, in Export's format.
+const (
+	upgradeListenFD = 3
+	upgradeStateFD  = 4
+)
+
+// Upgrade hands over to a new process for a zero-downtime restart, such as after the executable has been replaced
+// with a new build: it starts the executable again with the same arguments and environment, passing it the listening
+// socket, stops accepting connections, waits for requests in flight to finish or for ctx to be done, then passes the
+// pending awaits over so that their links keep working. Connections that arrive in between wait in the socket's
+// backlog. Serve then returns http.ErrServerClosed, and the process should exit once it's done with anything else.
+// Nothing in this process is told how the awaits resolve, so it should rely on events rather than Handles across
+// upgrades. HTTP3 isn't handed over; the new process listens again.
+func (server *Server) Upgrade(ctx context.Context) (*os.Process, error) {
+	if len(server.Listeners) > 0 {
+		return nil, errors.New("gotcha: Upgrade can't hand over Listeners")
+	}
+	server.mu.Lock()
+	listener, transport := server.listener, server.transport
+	server.mu.Unlock()
+	if listener == nil {
+		return nil, errors.New("gotcha: Upgrade needs Serve to be listening")
+	}
+	fileListener, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("gotcha: the listener can't be passed to another process")
+	}
+	listenFile, err := fileListener.File()
+	if err != nil {
+		return nil, err
+	}
+	defer listenFile.Close()
+	stateReader, stateWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer stateWriter.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		stateReader.Close()
+		return nil, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), upgradeEnv+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{listenFile, stateReader}
+	err = cmd.Start()
+	stateReader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// The new process waits for the state before it starts accepting connections.
+	shutdownErr := transport.Shutdown(ctx)
+	data, _, err := server.export(true)
+	if err == nil {
+		_, err = stateWriter.Write(data)
+	}
+	if err != nil {
+		return cmd.Process, err
+	}
+	return cmd.Process, shutdownErr
+}
+
+// upgradeListener returns the listening socket passed by Upgrade, after importing the state passed with it. It returns
+// nil if this process wasn't started by Upgrade.
+func (server *Server) upgradeListener() (net.Listener, error) {
+	if os.Getenv(upgradeEnv) == "" {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeEnv)
+	state := os.NewFile(upgradeStateFD, "upgrade-state")
+	data, err := io.ReadAll(state)
+	state.Close()
+	if err != nil {
+		return nil, err
+	}
+	// An old process that couldn't export its state still closes the pipe, so carry on without it.
+	if len(data) > 0 {
+		if _, err := server.restore(data); err != nil {
+			return nil, err
+		}
+	}
+	file := os.NewFile(upgradeListenFD, "upgrade-listener")
+	defer file.Close()
+	return net.FileListener(file)
+}
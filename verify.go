@@ -0,0 +1,482 @@
+package gotcha
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// outcome is the result of an attempt to verify an await.
+type outcome struct {
+	// status is the HTTP status to respond with.
+	status int
+	// code is a short, machine-readable description of the outcome for API clients.
+	code string
+	// body is passed to the Renderer.
+	body map[string]string
+	// forwarded is set if another instance handled the request and has already responded, if ErrorHandler responded,
+	// or if the client went away before there was anything to respond with.
+	forwarded bool
+	// shadowBanned is set if the client was blocked but told otherwise. See Server.ShadowBan.
+	shadowBanned bool
+	// await is the await that the request was for, as it was before the request, if there was one.
+	await *PendingAwait
+	// appLink and webFallback are where a browser that verified the await is sent. See AwaitRequest.AppLink.
+	appLink, webFallback string
+}
+
+// context returns what the Renderer is passed for the outcome.
+func (result outcome) context() RenderContext {
+	return RenderContext{Status: result.status, Code: result.code, Body: result.body, Await: result.await}
+}
+
+// Header values that are set on most responses.
+var (
+	noStore     = []string{"no-store"}
+	noCache     = []string{"no-cache"}
+	expiresZero = []string{"0"}
+	noIndex     = []string{"noindex, nofollow"}
+)
+
+// cacheControl is middleware that stops verification responses from being cached, or indexed by search engines.
+func (server *Server) cacheControl(c *gin.Context) {
+	cacheControl := server.CacheControl
+	if cacheControl == "" {
+		cacheControl = "no-store"
+	}
+	// The headers are set directly, with shared values for the default, since this runs on every request.
+	header := c.Writer.Header()
+	if cacheControl == "no-store" {
+		header["Cache-Control"] = noStore
+	} else {
+		header.Set("Cache-Control", cacheControl)
+	}
+	if strings.Contains(cacheControl, "no-store") {
+		header["Pragma"], header["Expires"] = noCache, expiresZero
+	}
+	header["X-Robots-Tag"] = noIndex
+}
+
+// verify tries to fulfil the await for key on behalf of the client and renders the outcome. The client can present
+// the await's code with ?code=.
+func (server *Server) verify(c *gin.Context, key string) {
+	result := server.attempt(c, key, c.Query("code"))
+	if result.forwarded {
+		return
+	}
+	if result.code == "verified" && result.appLink != "" && !result.shadowBanned &&
+		c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		server.openApp(c, result.appLink, result.webFallback)
+		return
+	}
+	if result.code == "verified" && server.Session != nil && server.Session.Redirect != "" {
+		c.Redirect(http.StatusSeeOther, server.Session.Redirect)
+		return
+	}
+	server.render(c, result.context())
+}
+
+// attempt tries to fulfil the await for key, as returned by HashIdentifier, on behalf of the client.
+func (server *Server) attempt(c *gin.Context, key, code string) outcome {
+	body := map[string]string{}
+	status, reason := server.unknownStatus(), "invalid_identifier"
+	shadowBanned := false
+	// DNSBL and ASN lookups can be slow, so they're done before taking the lock, as is the tarpit's delay.
+	reputationReason, reputationBlocked := server.checkDNSBL(c)
+	if !reputationBlocked {
+		reputationReason, reputationBlocked = server.checkASN(c)
+	}
+	if !server.holdUp(c, key) {
+		// The client went away; there's no one to respond to.
+		c.Abort()
+		return outcome{forwarded: true}
+	}
+
+	// The lock is only held shared, so that requests for other awaits don't wait on this one; the await itself is
+	// guarded by its shard's lock. See awaitStore.
+	server.mu.RLock()
+	if server.honeytoken(c, key) {
+		server.mu.RUnlock()
+		body["message"] = http.StatusText(status)
+		result := outcome{status: status, code: reason, body: body}
+		server.logAttempt(c, key, result)
+		return result
+	}
+	awaitKey, found, current, ok, shard := server.lookupAwait(c, key)
+	if (!ok || !found.pending()) && server.Cluster != nil && !isForwarded(c) && !isDryRun(c) {
+		server.unlockAwait(shard)
+		if server.forward(c, key, "") {
+			return outcome{forwarded: true}
+		}
+		server.mu.RLock()
+		awaitKey, found, current, ok, shard = server.lookupAwait(c, key)
+	}
+	if server.ReplayWindow > 0 && (!ok || (!found.pending() && found.status == StatusVerified)) {
+		if result, replayed := server.replayed(c, key); replayed {
+			server.unlockAwait(shard)
+			server.logAttempt(c, key, result)
+			return result
+		}
+	}
+	dryRun := !ok && isDryRun(c)
+	if dryRun {
+		awaitKey, found, ok = key, server.dryRunEntry(c), true
+	}
+	var pending *PendingAwait
+	var failure error
+	var link, fallback string
+	if ok {
+		link, fallback = found.appLink, found.webFallback
+		if !dryRun {
+			server.markAlias(c, key)
+			server.markRequested(found)
+		}
+		for name, values := range found.responseHeaders {
+			c.Writer.Header()[http.CanonicalHeaderKey(name)] = values
+		}
+		snapshot := found.pendingAwait(awaitKey, current)
+		pending = &snapshot
+		if !found.pending() {
+			switch found.status {
+			case StatusExpired:
+				status, reason = http.StatusGone, "expired"
+			case StatusLocked:
+				body["reason"] = lockedReason
+				status, reason = http.StatusForbidden, "locked"
+			}
+		} else if !found.allows(c.Request.Method) {
+			c.Header("Allow", strings.Join(found.methods, ", "))
+			status, reason = http.StatusMethodNotAllowed, "method_not_allowed"
+		} else if current != nil && current.done {
+			// The step has already been used, though the await is still waiting on the others.
+			status, reason = server.unknownStatus(), "invalid_identifier"
+		} else if found.scheduled() {
+			body["activates"] = found.activates.UTC().Format(time.RFC3339)
+			status, reason = http.StatusForbidden, "not_active"
+		} else if !time.Now().Before(found.expires) {
+			server.resolve(awaitKey, found, StatusExpired)
+			status, reason = http.StatusGone, "expired"
+		} else if !found.open(time.Now()) {
+			if opens := found.opens(time.Now()); !opens.IsZero() {
+				body["opens"] = opens.UTC().Format(time.RFC3339)
+			}
+			status, reason = http.StatusForbidden, "outside_window"
+		} else if expected := found.expectedCode(current); expected != "" &&
+			subtle.ConstantTimeCompare([]byte(code), []byte(expected)) != 1 {
+			// A wrong code doesn't consume the await, so that the user can try again.
+			status, reason = http.StatusUnauthorized, "invalid_code"
+		} else if !found.allowsIP(server.clientIP(c)) {
+			server.metrics.observeAllowListDenial()
+			status, reason = http.StatusForbidden, "not_allowed"
+		} else if block, ok := server.blockReasonFor(found, server.clientIP(c)); ok || reputationBlocked {
+			if !ok {
+				block = blocked{reason: reputationReason}
+			}
+			// Hits are counted by the reason as configured, so that templated reasons don't make a series per client.
+			server.metrics.observeBlockHit(block.reason)
+			if server.ShadowBan != ShadowBanPending && !dryRun {
+				server.identify(c, found)
+				server.resolve(awaitKey, found, StatusBlocked)
+			}
+			if server.ShadowBan == ShadowBanOff {
+				body["reason"] = server.expandReason(block, server.clientIP(c))
+				status, reason = http.StatusForbidden, "blocked"
+			} else {
+				status, reason, shadowBanned = http.StatusOK, "verified", true
+			}
+		} else if dryRun {
+			body["dry_run"] = "true"
+			status, reason = http.StatusOK, "verified"
+		} else {
+			status, reason, failure = server.fulfil(c, shard, awaitKey, found, current, body)
+		}
+		switch {
+		case dryRun:
+		case reason == "invalid_code", reason == "not_allowed", reason == "blocked", reason == "rejected":
+			server.failed(awaitKey, found, reason)
+		case shadowBanned:
+			// Only hidden blocks that leave the await pending get here; they don't count towards MaxAttempts, so that a
+			// blocked client can't lock the real recipient out.
+			found.noteFailure("blocked")
+		}
+	}
+	server.unlockAwait(shard)
+
+	if server.Tarpit != nil {
+		switch reason {
+		case "verified", "step_verified":
+			server.Tarpit.forgive(server.tarpitKeys(c, key)...)
+		case "invalid_identifier", "invalid_code", "not_allowed", "blocked", "rejected":
+			server.Tarpit.fail(server.tarpitKeys(c, key)...)
+		}
+		if server.ShadowBan == ShadowBanOff && !server.holdUpBlocked(c, reason == "blocked") {
+			c.Abort()
+			return outcome{forwarded: true}
+		}
+	}
+
+	if reason == "expired" && server.Reissue != nil && server.reissue(c, key) {
+		status, reason = http.StatusAccepted, "reissued"
+	}
+	body["message"] = http.StatusText(status)
+	result := outcome{status: status, code: reason, body: body, shadowBanned: shadowBanned, await: pending,
+		appLink: link, webFallback: fallback}
+	server.logAttempt(c, key, result)
+	if failure != nil && server.handleError(c, failure) {
+		result.forwarded = true
+	}
+	return result
+}
+
+// fulfil resolves entry with StatusVerified once the Verifier, if there is one, allows it and every step is done.
+// It's called with the server's lock held shared and shard's lock held, which it releases while the Verifier runs.
+// The error, if there is one, is for ErrorHandler.
+func (server *Server) fulfil(c *gin.Context, shard *awaitShard, key string, entry *awaited, current *step,
+	body map[string]string) (int, string, error) {
+	if server.Verifier != nil {
+		pending := entry.pendingAwait(key, current)
+		pending.Flags, pending.Fingerprint = flagsOf(c), server.fingerprint(c)
+		server.unlockAwait(shard)
+		decision, err := server.Verifier(c, pending)
+		server.relockAwait(shard)
+
+		if err != nil {
+			server.logger().Error("gotcha: verifier failed", "identifier", key, "error", err)
+			return http.StatusInternalServerError, "internal_error", fmt.Errorf("gotcha: verifier: %w", err)
+		}
+		if !entry.pending() {
+			// Another request resolved it while the Verifier was running.
+			return server.unknownStatus(), "invalid_identifier", nil
+		}
+		if decision.Reject || decision.Block {
+			if decision.Reason != "" {
+				body["reason"] = decision.Reason
+			}
+			if decision.Block {
+				server.identify(c, entry)
+				server.resolve(key, entry, StatusBlocked)
+			}
+			if decision.Status == 0 {
+				return http.StatusForbidden, "rejected", nil
+			}
+			return decision.Status, "rejected", nil
+		}
+	}
+
+	if server.Precondition != nil {
+		if status, reason, ok := server.checkPrecondition(c, shard, key, entry, current, body); !ok {
+			return status, reason, nil
+		}
+	}
+
+	server.identify(c, entry)
+	if current != nil {
+		current.done = true
+		if !entry.stepsDone() {
+			body["step"] = current.name
+			return http.StatusOK, "step_verified", nil
+		}
+	}
+	if server.Token != nil {
+		token, err := server.Token.issue(entry.metadata)
+		if err != nil {
+			server.logger().Error("gotcha: issuing token failed", "identifier", key, "error", err)
+			return http.StatusInternalServerError, "internal_error", fmt.Errorf("gotcha: issuing token: %w", err)
+		}
+		body["token"] = token
+	}
+	server.resolve(key, entry, StatusVerified)
+	var failure error
+	if server.Exchange != nil {
+		code, err := server.newExchangeCode(key, entry)
+		if err != nil {
+			c.Error(err)
+			server.logger().Error("gotcha: issuing exchange code failed", "identifier", key, "error", err)
+			failure = fmt.Errorf("gotcha: issuing exchange code: %w", err)
+		} else {
+			body["exchange_code"] = code
+		}
+	}
+	if server.Session != nil {
+		server.Session.set(c, Session{Identifier: key, Namespace: entry.namespace, Metadata: entry.metadata})
+	}
+	return http.StatusOK, "verified", failure
+}
+
+// lockedReason is shown to clients that try to verify a locked await.
+const lockedReason = "There were too many failed attempts. Request a new link and try again."
+
+// failed records a failed attempt to verify entry that was answered with code, locking it if there have been too many.
+// The server's lock must be held, exclusively or along with the lock of entry's shard.
+func (server *Server) failed(key string, entry *awaited, code string) {
+	if !entry.pending() {
+		return
+	}
+	entry.noteFailure(code)
+	entry.failures++
+	if entry.maxAttempts > 0 && entry.failures >= entry.maxAttempts {
+		server.resolve(key, entry, StatusLocked)
+	}
+}
+
+// noteFailure counts a failed attempt to verify entry that was answered with code, if it's still pending. The server's
+// lock must be held, exclusively or along with the lock of entry's shard.
+func (entry *awaited) noteFailure(code string) {
+	if !entry.pending() {
+		return
+	}
+	if entry.failureCodes == nil {
+		entry.failureCodes = map[string]int{}
+	}
+	entry.failureCodes[code]++
+}
+
+// identify records details of the client that's resolving entry.
+func (server *Server) identify(c *gin.Context, entry *awaited) {
+	entry.clientIP = server.clientIP(c)
+	entry.requestID = requestIDOf(c)
+	entry.traceParent = traceParent(c)
+	entry.alias = c.GetString(aliasKey)
+	entry.flags = flagsOf(c)
+	entry.fingerprint = server.fingerprint(c)
+	if server.CaptureAttribution {
+		entry.attribution = attribution(c)
+	}
+	for _, name := range server.CaptureHeaders {
+		if value := c.GetHeader(name); value != "" {
+			if entry.headers == nil {
+				entry.headers = map[string]string{}
+			}
+			entry.headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+}
+
+// attribution returns the Referer and utm_* query parameters of the request, or nil if there are none.
+func attribution(c *gin.Context) map[string]string {
+	var values map[string]string
+	add := func(name, value string) {
+		if value == "" {
+			return
+		}
+		if values == nil {
+			values = map[string]string{}
+		}
+		values[name] = value
+	}
+	add("referer", c.Request.Referer())
+	for name, query := range c.Request.URL.Query() {
+		if strings.HasPrefix(name, "utm_") && len(query) > 0 {
+			add(name, query[0])
+		}
+	}
+	return values
+}
+
+// verifyJSON handles POST /verify for API clients, such as mobile apps confirming a code that the user typed in.
+// The request body is {"identifier": "...", "code": "..."}. Responses are always JSON, with an "error" field on
+// failure. Signed links are passed on to verifySigned if POST is one of Methods.
+func (server *Server) verifyJSON(c *gin.Context) {
+	if server.SigningKey != nil && c.Query("sig") != "" && server.allowsMethod(http.MethodPost) {
+		server.verifySigned(c)
+		return
+	}
+
+	var req struct {
+		Identifier string `json:"identifier" binding:"required"`
+		Code       string `json:"code"`
+	}
+	// The body is kept around in case the request has to be forwarded to another instance.
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		server.respond(c, http.StatusBadRequest, gin.H{"error": "invalid_request", "message": err.Error()})
+		return
+	}
+	if !server.validIdentifier(req.Identifier) {
+		status := server.unknownStatus()
+		server.respond(c, status, gin.H{"error": "invalid_identifier", "message": http.StatusText(status)})
+		return
+	}
+
+	server.fallback(c, req.Identifier)
+	result := server.attempt(c, server.HashIdentifier(req.Identifier), req.Code)
+	if result.forwarded {
+		return
+	}
+	response := gin.H{"message": result.body["message"]}
+	if result.status == http.StatusOK {
+		response["status"] = result.code
+	} else {
+		response["error"] = result.code
+	}
+	if reason, ok := result.body["reason"]; ok {
+		response["reason"] = reason
+	}
+	for _, field := range []string{"step", "token", "exchange_code", "activates", "opens"} {
+		if value, ok := result.body[field]; ok {
+			response[field] = value
+		}
+	}
+	server.respond(c, result.status, response)
+}
+
+// methods returns the HTTP methods that verification routes are registered for.
+func (server *Server) methods() []string {
+	if len(server.Methods) == 0 {
+		return []string{http.MethodGet}
+	}
+	methods := make([]string, 0, len(server.Methods))
+	for _, method := range server.Methods {
+		method = strings.ToUpper(method)
+		if method != http.MethodHead && method != http.MethodOptions {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// allowsMethod reports whether method is one of the verification methods.
+func (server *Server) allowsMethod(method string) bool {
+	for _, allowed := range server.methods() {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// probe handles HEAD /verify/:identifier. It responds with the status that verifying would, but doesn't touch the
+// await.
+func (server *Server) probe(c *gin.Context) {
+	status := server.unknownStatus()
+	server.mu.RLock()
+	_, entry, current, ok, shard := server.lookupAwait(c, server.HashIdentifier(c.Param("identifier")))
+	var state State
+	scheduled, done := false, false
+	if ok {
+		state, scheduled = server.state(entry), entry.scheduled() || !entry.open(time.Now())
+		done = current != nil && current.done
+	}
+	server.unlockAwait(shard)
+	if ok && !done {
+		switch {
+		case scheduled:
+			status = http.StatusForbidden
+		case state == StatePending:
+			status = http.StatusOK
+		case state == StateExpired:
+			status = http.StatusGone
+		}
+	}
+	c.Status(status)
+}
+
+// options handles OPTIONS /verify/:identifier.
+func (server *Server) options(c *gin.Context) {
+	c.Header("Allow", strings.Join(append(server.methods(), http.MethodHead, http.MethodOptions), ", "))
+	c.Status(http.StatusNoContent)
+}
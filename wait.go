@@ -0,0 +1,70 @@
+package gotcha
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wait handles GET /wait/:identifier. It holds the connection until the await resolves, the client goes away, or
+// the poll timeout passes, then responds with the await's state, whether its confirmation page has been opened, the
+// progress of any steps and, once it's resolved, the failed attempts to verify it. Clients should poll again if it's
+// still pending.
+func (server *Server) wait(c *gin.Context) {
+	timeout := server.WaitTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if raw := c.Query("timeout"); raw != "" {
+		requested, err := time.ParseDuration(raw)
+		if err != nil || requested < 0 {
+			server.respond(c, http.StatusBadRequest, map[string]string{"message": "invalid timeout"})
+			return
+		}
+		if requested < timeout {
+			timeout = requested
+		}
+	}
+
+	key := server.HashIdentifier(c.Param("identifier"))
+	server.mu.RLock()
+	entry, ok := server.awaits.get(key)
+	server.mu.RUnlock()
+	// Awaits with steps are waited on by their own identifier, which lookupIn doesn't find, so the namespace that the
+	// request is restricted to is checked here instead.
+	if !ok || !inScope(c, entry) {
+		server.respond(c, http.StatusNotFound, map[string]string{"message": http.StatusText(http.StatusNotFound)})
+		return
+	}
+
+	// A poll that starts before the confirmation page is opened returns once it is, so that the caller can say so.
+	var opened <-chan struct{}
+	if !entry.isOpened() {
+		opened = entry.opened
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-entry.done:
+	case <-opened:
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+		return
+	}
+
+	server.mu.RLock()
+	shard := server.lockAwait(key)
+	response := gin.H{"state": server.state(entry).String()}
+	if entry.isOpened() {
+		response["opened"] = true
+	}
+	if len(entry.steps) > 0 {
+		response["steps"] = entry.stepStates()
+	}
+	if !entry.pending() && len(entry.failureCodes) > 0 {
+		response["failures"], response["failure_codes"] = entry.failures, entry.failureCodes
+	}
+	server.unlockAwait(shard)
+	server.respond(c, http.StatusOK, response)
+}
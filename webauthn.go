@@ -0,0 +1,128 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetadataWebAuthnCredentials is the AwaitRequest.Metadata key of the comma-separated, base64url-encoded IDs of the
+// passkeys that can confirm the await. Awaits with it need a passkey assertion on the confirmation page, as well as
+// the link. See Server.WebAuthn.
+const MetadataWebAuthnCredentials = "webauthn_credentials"
+
+// WebAuthn configures passkey step-up on the confirmation page, for awaits that have MetadataWebAuthnCredentials.
+// gotcha doesn't register credentials; it only checks assertions from ones that the application already knows.
+type WebAuthn struct {
+	// RPID is the relying party ID that the credentials were registered with, usually the application's domain.
+	RPID string
+	// Origins are the origins that the confirmation page can be served from. Defaults to Server.BaseURL.
+	Origins []string
+	// PublicKey returns the public key of a credential: an *ecdsa.PublicKey, *rsa.PublicKey or ed25519.PublicKey.
+	PublicKey func(ctx context.Context, pending PendingAwait, credentialID []byte) (crypto.PublicKey, error)
+	// UserVerification requires the authenticator to have verified the user, e.g. with a fingerprint or PIN, rather than
+	// only checking that they're present.
+	UserVerification bool
+}
+
+// Authenticator data flags.
+const (
+	webAuthnUserPresent  = 0x01
+	webAuthnUserVerified = 0x04
+)
+
+var errWebAuthn = errors.New("gotcha: invalid WebAuthn assertion")
+
+// verify checks the assertion posted by the confirmation page against challenge. baseURL is the default origin.
+func (webAuthn *WebAuthn) verify(c *gin.Context, baseURL string, pending PendingAwait, challenge string,
+	credentials []string) error {
+	if challenge == "" || webAuthn.PublicKey == nil {
+		return errWebAuthn
+	}
+	credentialID := c.PostForm("credential_id")
+	allowed := false
+	for _, credential := range credentials {
+		if credential == credentialID {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return errWebAuthn
+	}
+	rawID, err1 := base64.RawURLEncoding.DecodeString(credentialID)
+	authenticatorData, err2 := base64.RawURLEncoding.DecodeString(c.PostForm("authenticator_data"))
+	clientDataJSON, err3 := base64.RawURLEncoding.DecodeString(c.PostForm("client_data_json"))
+	signature, err4 := base64.RawURLEncoding.DecodeString(c.PostForm("signature"))
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return errWebAuthn
+	}
+
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return errWebAuthn
+	}
+	if clientData.Type != "webauthn.get" || clientData.Challenge != challenge || !webAuthn.allowsOrigin(clientData.Origin, baseURL) {
+		return errWebAuthn
+	}
+
+	// Authenticator data starts with the SHA-256 hash of the RP ID, then a byte of flags and a 4 byte sign count.
+	rpIDHash := sha256.Sum256([]byte(webAuthn.RPID))
+	if len(authenticatorData) < 37 || !bytes.Equal(authenticatorData[:32], rpIDHash[:]) {
+		return errWebAuthn
+	}
+	flags := authenticatorData[32]
+	if flags&webAuthnUserPresent == 0 || webAuthn.UserVerification && flags&webAuthnUserVerified == 0 {
+		return errWebAuthn
+	}
+
+	publicKey, err := webAuthn.PublicKey(c.Request.Context(), pending, rawID)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(authenticatorData, clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, signed, signature) {
+			return nil
+		}
+	}
+	return errWebAuthn
+}
+
+// allowsOrigin reports whether origin is one that the confirmation page is served from.
+func (webAuthn *WebAuthn) allowsOrigin(origin, baseURL string) bool {
+	origins := webAuthn.Origins
+	if len(origins) == 0 {
+		origins = []string{strings.TrimSuffix(baseURL, "/")}
+	}
+	for _, allowed := range origins {
+		if allowed != "" && allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package gotcha
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AppLinks serves the files that let mobile apps open the server's verification links, for AwaitRequest.AppLink, as
+// /.well-known/apple-app-site-association and /.well-known/assetlinks.json. Apple and Google only look for them at
+// the root of the host, so they need the server to be served there, rather than mounted under a prefix.
+type AppLinks struct {
+	// AppleAppIDs are the iOS apps that can open the links, as "<team ID>.<bundle ID>".
+	AppleAppIDs []string
+	// AndroidApps are the Android apps that can open the links.
+	AndroidApps []AndroidApp
+	// Paths are the paths that iOS apps can open, such as "/verify/*". Defaults to the verification paths, including
+	// those of Tenants, both under APIVersion and unversioned.
+	Paths []string
+}
+
+// AndroidApp is an Android app that can open verification links.
+type AndroidApp struct {
+	// Package is the app's package name, e.g. "com.example.app".
+	Package string
+	// Fingerprints are the SHA-256 fingerprints of the app's signing certificates, e.g. "14:6D:E9:...".
+	Fingerprints []string
+}
+
+// appLinkPaths returns Paths, or the verification paths.
+func (server *Server) appLinkPaths() []string {
+	if len(server.AppLinks.Paths) > 0 {
+		return server.AppLinks.Paths
+	}
+	var paths []string
+	for _, base := range []string{server.basePath(), server.prefix} {
+		var tenantPaths []string
+		for _, tenant := range server.Tenants {
+			if path, err := tenant.tenantPath(); tenant.Path != "" && err == nil {
+				tenantPaths = append(tenantPaths, base+strings.TrimSuffix(path, ":identifier")+"*")
+			}
+		}
+		sort.Strings(tenantPaths)
+		paths = append(append(paths, base+"/verify/*"), tenantPaths...)
+		if server.SigningKey != nil {
+			paths = append(paths, base+"/verify")
+		}
+	}
+	return paths
+}
+
+// appleAppSiteAssociation handles GET /.well-known/apple-app-site-association.
+func (server *Server) appleAppSiteAssociation(c *gin.Context) {
+	components := []gin.H{}
+	for _, path := range server.appLinkPaths() {
+		components = append(components, gin.H{"/": path})
+	}
+	c.JSON(http.StatusOK, gin.H{"applinks": gin.H{"details": []gin.H{{
+		"appIDs":     server.AppLinks.AppleAppIDs,
+		"components": components,
+	}}}})
+}
+
+// assetLinks handles GET /.well-known/assetlinks.json.
+func (server *Server) assetLinks(c *gin.Context) {
+	statements := []gin.H{}
+	for _, app := range server.AppLinks.AndroidApps {
+		statements = append(statements, gin.H{
+			"relation": []string{"delegate_permission/common.handle_all_urls"},
+			"target": gin.H{
+				"namespace":                "android_app",
+				"package_name":             app.Package,
+				"sha256_cert_fingerprints": app.Fingerprints,
+			},
+		})
+	}
+	c.JSON(http.StatusOK, statements)
+}
+
+// WellKnownFile returns a handler for Server.WellKnown that serves content, such as a security.txt.
+func WellKnownFile(contentType string, content []byte) http.Handler {
+	modified := time.Now()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, "", modified, bytes.NewReader(content))
+	})
+}
+
+// wellKnownHandlers returns the handlers for the paths under /.well-known/: WellKnown, and the files for AppLinks.
+func (server *Server) wellKnownHandlers() map[string]gin.HandlerFunc {
+	handlers := map[string]gin.HandlerFunc{}
+	for name, handler := range server.WellKnown {
+		handlers[strings.TrimPrefix(name, "/")] = gin.WrapH(handler)
+	}
+	if server.AppLinks != nil && len(server.AppLinks.AppleAppIDs) > 0 {
+		handlers["apple-app-site-association"] = server.appleAppSiteAssociation
+	}
+	if server.AppLinks != nil && len(server.AppLinks.AndroidApps) > 0 {
+		handlers["assetlinks.json"] = server.assetLinks
+	}
+	return handlers
+}
+
+// serveWellKnown handles GET /.well-known/*name with the handler for name, or the longest key of handlers ending in
+// "/" that it's below.
+func (server *Server) serveWellKnown(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := strings.TrimPrefix(c.Param("name"), "/")
+		handler, ok := handlers[name]
+		if !ok {
+			longest := ""
+			for key, h := range handlers {
+				if strings.HasSuffix(key, "/") && strings.HasPrefix(name, key) && len(key) > len(longest) {
+					longest, handler = key, h
+				}
+			}
+		}
+		if handler != nil {
+			handler(c)
+		} else if server.NotFound != nil {
+			server.NotFound(c)
+		} else {
+			c.AbortWithStatus(http.StatusNotFound)
+		}
+	}
+}
+
+// defaultRobotsTxt keeps every crawler away.
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// servesRobots reports whether /robots.txt is served, which it isn't if it's one of Honeypots.
+func (server *Server) servesRobots() bool {
+	for _, path := range server.Honeypots {
+		if path == "/robots.txt" {
+			return false
+		}
+	}
+	return true
+}
+
+// robots handles GET /robots.txt.
+func (server *Server) robots(c *gin.Context) {
+	robots := server.RobotsTxt
+	if robots == "" {
+		robots = defaultRobotsTxt
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(robots))
+}
@@ -0,0 +1,93 @@
+package gotcha
+
+import "time"
+
+// Window is a recurring period when an await can be verified, such as business hours. See AwaitRequest.Windows.
+type Window struct {
+	// Days are the days of the week that the window opens on. Defaults to every day.
+	Days []time.Weekday
+	// Start and End are the times of day that the window opens and closes, as offsets from midnight, such as
+	// 9*time.Hour and 17*time.Hour. A window that ends before it starts runs past midnight, and one that starts and
+	// ends at the same time lasts all day.
+	Start, End time.Duration
+	// Location is the time zone of Days, Start and End. Defaults to UTC.
+	Location *time.Location
+}
+
+func (window Window) location() *time.Location {
+	if window.Location == nil {
+		return time.UTC
+	}
+	return window.Location
+}
+
+// opensOn reports whether the window opens on day.
+func (window Window) opensOn(day time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, open := range window.Days {
+		if open == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether t is in the window.
+func (window Window) contains(t time.Time) bool {
+	t = t.In(window.location())
+	// The offset is the wall-clock time of day, rather than the time since midnight, which is an hour out on the days
+	// that the clocks change.
+	hour, minute, second := t.Clock()
+	offset := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second +
+		time.Duration(t.Nanosecond())
+	today, yesterday := t.Weekday(), (t.Weekday()+6)%7
+	switch {
+	case window.Start == window.End:
+		return window.opensOn(today)
+	case window.Start < window.End:
+		return window.opensOn(today) && offset >= window.Start && offset < window.End
+	default:
+		return window.opensOn(today) && offset >= window.Start || window.opensOn(yesterday) && offset < window.End
+	}
+}
+
+// next returns when the window next opens after t, or the zero time if it never does.
+func (window Window) next(t time.Time) time.Time {
+	t = t.In(window.location())
+	year, month, day := t.Date()
+	start := window.Start
+	hour, minute, second := int(start/time.Hour), int(start%time.Hour/time.Minute), int(start%time.Minute/time.Second)
+	for days := 0; days <= 7; days++ {
+		opens := time.Date(year, month, day+days, hour, minute, second, int(start%time.Second), t.Location())
+		if window.opensOn(opens.Weekday()) && opens.After(t) {
+			return opens
+		}
+	}
+	return time.Time{}
+}
+
+// open reports whether entry can be verified at t, going by its windows.
+func (entry *awaited) open(t time.Time) bool {
+	if len(entry.windows) == 0 {
+		return true
+	}
+	for _, window := range entry.windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// opens returns when the first of entry's windows next opens after t, or the zero time if none do.
+func (entry *awaited) opens(t time.Time) time.Time {
+	var first time.Time
+	for _, window := range entry.windows {
+		if next := window.next(t); !next.IsZero() && (first.IsZero() || next.Before(first)) {
+			first = next
+		}
+	}
+	return first
+}